@@ -0,0 +1,114 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagAdd  = "add"
+	flagDrop = "drop"
+)
+
+var commandAmend = &cobra.Command{
+	Use:   "amend <transaction id> --add <public key address> --drop <member index>",
+	Short: "Add and/or drop members of the relationship that was initiated in the specified transaction.",
+	Long: "Add and/or drop members of the relationship that was initiated in the specified " +
+		"transaction. Member indexes are the order of members shown by the list command.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		addArgs, err := c.Flags().GetStringSlice(flagAdd)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get add members : %s", err)
+		}
+
+		dropArgs, err := c.Flags().GetUintSlice(flagDrop)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get drop members : %s", err)
+		}
+
+		if len(addArgs) == 0 && len(dropArgs) == 0 {
+			c.Help()
+			logger.Fatal(ctx, "No members to add or drop")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandAmend)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(addArgs))); err != nil {
+			logger.Fatal(ctx, "Failed to write add member count : %s", err)
+		}
+
+		for _, arg := range addArgs {
+			ad, err := bitcoin.DecodeAddress(arg)
+			if err != nil {
+				logger.Fatal(ctx, "Failed to parse address : %s", err)
+			}
+
+			ra := bitcoin.NewRawAddressFromAddress(ad)
+			if _, err := buf.Write(ra.Bytes()); err != nil {
+				logger.Fatal(ctx, "Failed to write raw address : %s", err)
+			}
+		}
+
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(dropArgs))); err != nil {
+			logger.Fatal(ctx, "Failed to write drop member count : %s", err)
+		}
+
+		for _, index := range dropArgs {
+			if err := binary.Write(&buf, binary.LittleEndian, uint32(index)); err != nil {
+				logger.Fatal(ctx, "Failed to write member index : %s", err)
+			}
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		fmt.Printf("%s\n", string(response))
+		return nil
+	},
+}
+
+func init() {
+	commandAmend.Flags().StringSlice(flagAdd, nil, "public key address of member to add")
+	commandAmend.Flags().UintSlice(flagDrop, nil, "index of member to drop")
+}
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagText     = "text"
+	flagType     = "type"
+	flagHashOnly = "hash-only"
+)
+
+var commandAttach = &cobra.Command{
+	Use:   "attach <relationship tx id> <file path> [file path...]",
+	Short: "Send files to the relationship that was initiated in the specified transaction.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) < 2 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		text, err := c.Flags().GetString(flagText)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get text : %s", err)
+		}
+
+		mimeType, err := c.Flags().GetString(flagType)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get type : %s", err)
+		}
+
+		hashOnly, err := c.Flags().GetBool(flagHashOnly)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get hash only : %s", err)
+		}
+
+		threadStr, err := c.Flags().GetString(flagThread)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get thread : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandAttach)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		if err := writeBytes(&buf, []byte(text)); err != nil {
+			logger.Fatal(ctx, "Failed to write message : %s", err)
+		}
+
+		if err := binary.Write(&buf, binary.LittleEndian, hashOnly); err != nil {
+			logger.Fatal(ctx, "Failed to write hash only : %s", err)
+		}
+
+		files := args[1:]
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(files))); err != nil {
+			logger.Fatal(ctx, "Failed to write attachment count : %s", err)
+		}
+
+		for _, path := range files {
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				logger.Fatal(ctx, "Failed to read file : %s", err)
+			}
+
+			fileType := mimeType
+			if len(fileType) == 0 {
+				fileType = mime.TypeByExtension(filepath.Ext(path))
+			}
+			if len(fileType) == 0 {
+				fileType = http.DetectContentType(contents)
+			}
+
+			if err := writeBytes(&buf, []byte(filepath.Base(path))); err != nil {
+				logger.Fatal(ctx, "Failed to write file name : %s", err)
+			}
+
+			if err := writeBytes(&buf, []byte(fileType)); err != nil {
+				logger.Fatal(ctx, "Failed to write file type : %s", err)
+			}
+
+			if err := writeBytes(&buf, contents); err != nil {
+				logger.Fatal(ctx, "Failed to write file contents : %s", err)
+			}
+
+			fmt.Printf("Attaching %s (%s, %d bytes)\n", filepath.Base(path), fileType,
+				len(contents))
+		}
+
+		if err := writeOptionalTxId(&buf, threadStr); err != nil {
+			logger.Fatal(ctx, "Failed to write thread txid : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		fmt.Printf("%s\n", string(response))
+		return nil
+	},
+}
+
+// writeBytes writes the size of the bytes followed by the bytes.
+func writeBytes(buf *bytes.Buffer, b []byte) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+
+	_, err := buf.Write(b)
+	return err
+}
+
+func init() {
+	commandAttach.Flags().String(flagText, "", "text message to send with the files")
+	commandAttach.Flags().String(flagType, "",
+		"MIME type of the files (determined from each file when not specified)")
+	commandAttach.Flags().Bool(flagHashOnly, false,
+		"send only the hashes of the files so the files can be sent separately and verified")
+	commandAttach.Flags().String(flagThread, "", "tx id of the thread to send the files in")
+}
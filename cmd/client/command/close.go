@@ -0,0 +1,60 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandClose = &cobra.Command{
+	Use:   "close <relationship tx id>",
+	Short: "Leave the relationship that was initiated in the specified transaction.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandClose)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		fmt.Printf("%s\n", string(response))
+		return nil
+	},
+}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -9,13 +10,18 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
 	"github.com/tokenized/smart-contract/pkg/json"
 	"github.com/tokenized/smart-contract/pkg/logger"
 	"github.com/tokenized/smart-contract/pkg/rpcnode"
 	"github.com/tokenized/smart-contract/pkg/spynode"
 	"github.com/tokenized/smart-contract/pkg/txbuilder"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var clientCommand = &cobra.Command{
@@ -26,9 +32,25 @@ var clientCommand = &cobra.Command{
 func Execute() {
 	clientCommand.AddCommand(commandReceive)
 	clientCommand.AddCommand(commandInitiate)
+	clientCommand.AddCommand(commandPendingAccept)
 	clientCommand.AddCommand(commandAccept)
+	clientCommand.AddCommand(commandDecline)
+	clientCommand.AddCommand(commandAmend)
 	clientCommand.AddCommand(commandMessage)
+	clientCommand.AddCommand(commandAttach)
+	clientCommand.AddCommand(commandSign)
 	clientCommand.AddCommand(commandList)
+	clientCommand.AddCommand(commandHistory)
+	clientCommand.AddCommand(commandRead)
+	clientCommand.AddCommand(commandResend)
+	clientCommand.AddCommand(commandThread)
+	clientCommand.AddCommand(commandThreads)
+	clientCommand.AddCommand(commandClose)
+	clientCommand.AddCommand(commandPolicy)
+	clientCommand.AddCommand(commandExport)
+	clientCommand.AddCommand(commandImport)
+	clientCommand.AddCommand(commandSweep)
+	clientCommand.AddCommand(commandOutbox)
 	clientCommand.Execute()
 }
 
@@ -85,9 +107,76 @@ func dumpJSON(o interface{}) error {
 	return nil
 }
 
+// sendCommand sends the command to the daemon and returns the response. When the command's txs
+//   weren't all broadcast it says they are queued, so the command isn't mistaken as sent.
+func sendCommand(ctx context.Context, cfg *config.Config, command []byte) []byte {
+	response, err := node.SendCommand(ctx, cfg, command)
+	if err != nil {
+		if queued, ok := err.(*node.QueuedError); ok {
+			fmt.Printf("%s\n", queued.Error())
+			return response
+		}
+		logger.Fatal(ctx, "Failed to send command : %s", err)
+	}
+
+	return response
+}
+
 func isError(response []byte) (bool, string) {
 	if len(response) >= 5 && bytes.Equal(response[:5], []byte("err: ")) {
 		return true, string(response[5:])
 	}
 	return false, ""
 }
+
+// readPassphrase returns the passphrase for an export from the EXPORT_PASSPHRASE environment
+//   variable, or prompts for it so it isn't kept in the shell history. When confirm is true the
+//   prompted passphrase must be entered twice.
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv("EXPORT_PASSPHRASE"); len(passphrase) > 0 {
+		return passphrase, nil
+	}
+
+	passphrase, err := promptPassphrase("Passphrase : ")
+	if err != nil {
+		return "", err
+	}
+
+	if len(passphrase) == 0 {
+		return "", errors.New("Passphrase required")
+	}
+
+	if confirm {
+		again, err := promptPassphrase("Confirm passphrase : ")
+		if err != nil {
+			return "", err
+		}
+
+		if again != passphrase {
+			return "", errors.New("Passphrases don't match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// promptPassphrase reads a line from stdin without echoing it when stdin is a terminal.
+func promptPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return "", errors.Wrap(err, "read passphrase")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", errors.Wrap(err, "read passphrase")
+	}
+
+	return string(b), nil
+}
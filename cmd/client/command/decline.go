@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagStopMonitoring = "stop"
+)
+
+var commandDecline = &cobra.Command{
+	Use:   "decline <relationship tx id>",
+	Short: "Decline the relationship that was initiated in the specified transaction, instead of accepting it.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		stopMonitoring, err := c.Flags().GetBool(flagStopMonitoring)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get stop flag : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandDecline)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		if err := binary.Write(&buf, binary.LittleEndian, stopMonitoring); err != nil {
+			logger.Fatal(ctx, "Failed to write stop flag : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		fmt.Printf("%s\n", string(response))
+		return nil
+	},
+}
+
+func init() {
+	commandDecline.Flags().Bool(flagStopMonitoring, false, "stop monitoring the relationship's keys")
+}
@@ -0,0 +1,66 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandExport = &cobra.Command{
+	Use:   "export <file path>",
+	Short: "Save the relationships and their history to a file encrypted with a passphrase.",
+	Long: "Save the relationships and their history to a file encrypted with a passphrase. The " +
+		"passphrase is read from EXPORT_PASSPHRASE, or prompted for when it isn't set.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		passphrase, err := readPassphrase(true)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get passphrase : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandExport)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := writeBytes(&buf, []byte(passphrase)); err != nil {
+			logger.Fatal(ctx, "Failed to write passphrase : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		if err := ioutil.WriteFile(args[0], response, 0600); err != nil {
+			logger.Fatal(ctx, "Failed to write file : %s", err)
+		}
+
+		fmt.Printf("Exported %d bytes to %s\n", len(response), args[0])
+		return nil
+	},
+}
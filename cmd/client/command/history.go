@@ -0,0 +1,194 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/relationships"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagConversation = "conversation"
+)
+
+var commandHistory = &cobra.Command{
+	Use:   "history <transaction id>",
+	Short: "Lists the messages in the relationship that was initiated in the specified transaction.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		threadStr, err := c.Flags().GetString(flagThread)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get thread : %s", err)
+		}
+
+		conversation, err := c.Flags().GetString(flagConversation)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get conversation : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandHistory)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		if err := writeOptionalTxId(&buf, threadStr); err != nil {
+			logger.Fatal(ctx, "Failed to write thread txid : %s", err)
+		}
+
+		if err := writeOptionalTxId(&buf, conversation); err != nil {
+			logger.Fatal(ctx, "Failed to write conversation txid : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		var count uint32
+		read := bytes.NewReader(response)
+		if err := binary.Read(read, binary.LittleEndian, &count); err != nil {
+			logger.Fatal(ctx, "Failed to read message count : %s", err)
+		}
+
+		if len(conversation) > 0 {
+			fmt.Printf("Conversation : \n")
+		} else {
+			fmt.Printf("History : \n")
+		}
+		for i := uint32(0); i < count; i++ {
+			var m relationships.Message
+			if err := m.Deserialize(read); err != nil {
+				logger.Fatal(ctx, "Failed to read message : %s", err)
+			}
+
+			var inOutbox bool
+			if err := binary.Read(read, binary.LittleEndian, &inOutbox); err != nil {
+				logger.Fatal(ctx, "Failed to read outbox flag : %s", err)
+			}
+
+			var outboxState uint8
+			if err := binary.Read(read, binary.LittleEndian, &outboxState); err != nil {
+				logger.Fatal(ctx, "Failed to read outbox state : %s", err)
+			}
+
+			printMessage(&m)
+
+			if inOutbox {
+				fmt.Printf("    Outbox : %s\n", wallet.OutboxStateName[outboxState])
+			}
+		}
+
+		return nil
+	},
+}
+
+// printMessage prints the details of a message and its text.
+func printMessage(m *relationships.Message) {
+	from := "Sent"
+	if m.Direction == relationships.DirectionIncoming {
+		from = fmt.Sprintf("From member %d", m.MemberIndex)
+	}
+
+	state := "Unconfirmed"
+	if m.Confirmed {
+		state = "Confirmed"
+	} else if m.Failed {
+		state = "Failed"
+	}
+
+	fmt.Printf("  %s %s (%s) %s\n", time.Unix(0, int64(m.Timestamp)).Format(time.RFC3339),
+		from, state, m.TxId.String())
+
+	if !m.Thread.Equal(&bitcoin.Hash32{}) {
+		fmt.Printf("    Thread : %s\n", m.Thread.String())
+	}
+
+	if !m.ReplyTo.Equal(&bitcoin.Hash32{}) {
+		fmt.Printf("    In reply to : %s\n", m.ReplyTo.String())
+	}
+
+	for _, receipt := range m.Receipts {
+		status := "Delivered to"
+		if receipt.Status == relationships.ReceiptRead {
+			status = "Read by"
+		}
+
+		fmt.Printf("    %s member %d at %s\n", status, receipt.MemberIndex,
+			time.Unix(0, int64(receipt.Timestamp)).Format(time.RFC3339))
+	}
+
+	p, err := messages.Deserialize(m.MessageCode, m.Payload)
+	if err != nil {
+		fmt.Printf("    Failed to deserialize message : %s\n", err)
+		return
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		fmt.Printf("    Message code %d\n", p.Code())
+		return
+	}
+
+	if len(privateMessage.Subject) > 0 {
+		fmt.Printf("    Subject : %s\n", privateMessage.Subject)
+	}
+
+	if privateMessage.PrivateMessage != nil {
+		if privateMessage.PrivateMessage.Type == "text/plain" ||
+			len(privateMessage.PrivateMessage.Type) == 0 {
+			fmt.Printf("    %s\n", string(privateMessage.PrivateMessage.Contents))
+		} else {
+			fmt.Printf("    %s (%d bytes)\n", privateMessage.PrivateMessage.Type,
+				len(privateMessage.PrivateMessage.Contents))
+		}
+	}
+
+	for _, attachment := range privateMessage.Attachments {
+		fmt.Printf("    Attachment : %s (%s, %d bytes)\n", attachment.Name, attachment.Type,
+			len(attachment.Contents))
+	}
+}
+
+func init() {
+	commandHistory.Flags().String(flagThread, "", "tx id of the thread to list messages for")
+	commandHistory.Flags().String(flagConversation, "",
+		"tx id of a message to list the messages it replies to and the replies to it")
+}
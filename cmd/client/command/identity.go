@@ -0,0 +1,90 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/tokenized/relationship-example/internal/node"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagPaymail    = "paymail"
+	flagEntity     = "entity"
+	flagNoIdentity = "no-identity"
+)
+
+func init() {
+	addIdentityFlags(commandInitiate)
+	addIdentityFlags(commandPendingAccept)
+	addIdentityFlags(commandAccept)
+}
+
+// addIdentityFlags adds the flags used to specify the proof of identity attached to a message.
+// Without any flags the daemon attaches the entity from its ENTITY config, if there is one.
+func addIdentityFlags(c *cobra.Command) {
+	c.Flags().String(flagPaymail, "", "attach a paymail proof of identity for the handle")
+	c.Flags().String(flagEntity, "",
+		"attach an identity oracle proof of identity for the JSON entity")
+	c.Flags().Bool(flagNoIdentity, false, "don't attach a proof of identity")
+}
+
+// writeIdentity writes the identity option specified by the command's flags.
+func writeIdentity(c *cobra.Command, buf *bytes.Buffer) error {
+	paymail, err := c.Flags().GetString(flagPaymail)
+	if err != nil {
+		return errors.Wrap(err, "paymail flag")
+	}
+
+	entity, err := c.Flags().GetString(flagEntity)
+	if err != nil {
+		return errors.Wrap(err, "entity flag")
+	}
+
+	noIdentity, err := c.Flags().GetBool(flagNoIdentity)
+	if err != nil {
+		return errors.Wrap(err, "no identity flag")
+	}
+
+	count := 0
+	option := node.IdentityDefault
+	value := ""
+	if len(paymail) > 0 {
+		count++
+		option = node.IdentityPaymail
+		value = paymail
+	}
+	if len(entity) > 0 {
+		count++
+		option = node.IdentityEntity
+		value = entity
+	}
+	if noIdentity {
+		count++
+		option = node.IdentityNone
+	}
+
+	if count > 1 {
+		return errors.New("Only one of paymail, entity, and no-identity can be specified")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, option); err != nil {
+		return errors.Wrap(err, "write identity option")
+	}
+
+	if option != node.IdentityPaymail && option != node.IdentityEntity {
+		return nil
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(value))); err != nil {
+		return errors.Wrap(err, "write identity size")
+	}
+
+	if _, err := buf.Write([]byte(value)); err != nil {
+		return errors.Wrap(err, "write identity")
+	}
+
+	return nil
+}
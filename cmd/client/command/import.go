@@ -0,0 +1,71 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandImport = &cobra.Command{
+	Use:   "import <file path>",
+	Short: "Merge the relationships and their history from a file created by export.",
+	Long: "Merge the relationships and their history from a file created by export. The " +
+		"passphrase is read from EXPORT_PASSPHRASE, or prompted for when it isn't set.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		passphrase, err := readPassphrase(false)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get passphrase : %s", err)
+		}
+
+		contents, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to read file : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandImport)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := writeBytes(&buf, []byte(passphrase)); err != nil {
+			logger.Fatal(ctx, "Failed to write passphrase : %s", err)
+		}
+
+		if err := writeBytes(&buf, contents); err != nil {
+			logger.Fatal(ctx, "Failed to write export : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		fmt.Printf("%s\n", string(response))
+		return nil
+	},
+}
@@ -56,11 +56,12 @@ var commandInitiate = &cobra.Command{
 			}
 		}
 
-		response, err := node.SendCommand(ctx, cfg, buf.Bytes())
-		if err != nil {
-			logger.Fatal(ctx, "Failed to send command : %s", err)
+		if err := writeIdentity(c, &buf); err != nil {
+			logger.Fatal(ctx, "Failed to write identity : %s", err)
 		}
 
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
 		if t, m := isError(response); t {
 			logger.Fatal(ctx, "Error Response : %s", m)
 		}
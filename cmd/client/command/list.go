@@ -35,10 +35,7 @@ var commandList = &cobra.Command{
 			logger.Fatal(ctx, "Failed to write command name : %s", err)
 		}
 
-		response, err := node.SendCommand(ctx, cfg, buf.Bytes())
-		if err != nil {
-			logger.Fatal(ctx, "Failed to send command : %s", err)
-		}
+		response := sendCommand(ctx, cfg, buf.Bytes())
 
 		if t, m := isError(response); t {
 			logger.Fatal(ctx, "Error Response : %s", m)
@@ -56,7 +53,50 @@ var commandList = &cobra.Command{
 			if err := txid.Deserialize(read); err != nil {
 				logger.Fatal(ctx, "Failed to read relationship : %s", err)
 			}
-			fmt.Printf("  %s\n", txid.String())
+
+			var closed bool
+			if err := binary.Read(read, binary.LittleEndian, &closed); err != nil {
+				logger.Fatal(ctx, "Failed to read closed : %s", err)
+			}
+
+			var declined bool
+			if err := binary.Read(read, binary.LittleEndian, &declined); err != nil {
+				logger.Fatal(ctx, "Failed to read declined : %s", err)
+			}
+
+			switch {
+			case declined:
+				fmt.Printf("  %s (declined)\n", txid.String())
+			case closed:
+				fmt.Printf("  %s (closed)\n", txid.String())
+			default:
+				fmt.Printf("  %s\n", txid.String())
+			}
+
+			var memberCount uint32
+			if err := binary.Read(read, binary.LittleEndian, &memberCount); err != nil {
+				logger.Fatal(ctx, "Failed to read member count : %s", err)
+			}
+
+			for j := uint32(0); j < memberCount; j++ {
+				var ra bitcoin.RawAddress
+				if err := ra.Deserialize(read); err != nil {
+					logger.Fatal(ctx, "Failed to read member : %s", err)
+				}
+
+				var memberDeclined bool
+				if err := binary.Read(read, binary.LittleEndian, &memberDeclined); err != nil {
+					logger.Fatal(ctx, "Failed to read member declined : %s", err)
+				}
+
+				if memberDeclined {
+					fmt.Printf("    %d : %s (declined)\n", j,
+						bitcoin.NewAddressFromRawAddress(ra, cfg.Net).String())
+				} else {
+					fmt.Printf("    %d : %s\n", j,
+						bitcoin.NewAddressFromRawAddress(ra, cfg.Net).String())
+				}
+			}
 		}
 
 		return nil
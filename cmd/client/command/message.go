@@ -3,6 +3,7 @@ package command
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 
 	"github.com/tokenized/relationship-example/internal/node"
@@ -11,9 +12,17 @@ import (
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 	"github.com/tokenized/smart-contract/pkg/logger"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+const (
+	flagCoSigner = "cosigner"
+	flagThread   = "thread"
+	flagSubject  = "subject"
+	flagReplyTo  = "reply-to"
+)
+
 var commandMessage = &cobra.Command{
 	Use:   "message <relationship tx id> <text of message>",
 	Short: "Send a message to the relationship that was initiated in the specified transaction.",
@@ -35,6 +44,26 @@ var commandMessage = &cobra.Command{
 			logger.Fatal(ctx, "Failed to convert config : %s", err)
 		}
 
+		coSigners, err := c.Flags().GetUintSlice(flagCoSigner)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get co-signers : %s", err)
+		}
+
+		threadStr, err := c.Flags().GetString(flagThread)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get thread : %s", err)
+		}
+
+		subject, err := c.Flags().GetString(flagSubject)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get subject : %s", err)
+		}
+
+		replyTo, err := c.Flags().GetString(flagReplyTo)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get reply to : %s", err)
+		}
+
 		txid, err := bitcoin.NewHash32FromStr(args[0])
 		if err != nil {
 			logger.Fatal(ctx, "Failed to parse txid : %s", err)
@@ -57,16 +86,67 @@ var commandMessage = &cobra.Command{
 			logger.Fatal(ctx, "Failed to write message : %s", err)
 		}
 
-		response, err := node.SendCommand(ctx, cfg, buf.Bytes())
-		if err != nil {
-			logger.Fatal(ctx, "Failed to send command : %s", err)
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(coSigners))); err != nil {
+			logger.Fatal(ctx, "Failed to write co-signer count : %s", err)
+		}
+
+		for _, index := range coSigners {
+			if err := binary.Write(&buf, binary.LittleEndian, uint32(index)); err != nil {
+				logger.Fatal(ctx, "Failed to write co-signer : %s", err)
+			}
 		}
 
+		if err := writeOptionalTxId(&buf, threadStr); err != nil {
+			logger.Fatal(ctx, "Failed to write thread txid : %s", err)
+		}
+
+		if err := writeBytes(&buf, []byte(subject)); err != nil {
+			logger.Fatal(ctx, "Failed to write subject : %s", err)
+		}
+
+		if err := writeOptionalTxId(&buf, replyTo); err != nil {
+			logger.Fatal(ctx, "Failed to write reply to txid : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
 		if t, m := isError(response); t {
 			logger.Fatal(ctx, "Error Response : %s", m)
 		}
 
+		if len(coSigners) > 0 {
+			// Co-signed message must be passed to the co-signers to sign.
+			fmt.Printf("Co-signed message : %s\n", hex.EncodeToString(response))
+			return nil
+		}
+
 		fmt.Printf("%s\n", string(response))
 		return nil
 	},
 }
+
+// writeOptionalTxId writes a bool specifying if a txid is included, followed by the txid parsed
+//   from the string when it isn't empty.
+func writeOptionalTxId(buf *bytes.Buffer, s string) error {
+	if len(s) == 0 {
+		return binary.Write(buf, binary.LittleEndian, false)
+	}
+
+	txid, err := bitcoin.NewHash32FromStr(s)
+	if err != nil {
+		return errors.Wrap(err, "parse txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, true); err != nil {
+		return err
+	}
+
+	return txid.Serialize(buf)
+}
+
+func init() {
+	commandMessage.Flags().UintSlice(flagCoSigner, nil, "index of member to co-sign message")
+	commandMessage.Flags().String(flagThread, "", "tx id of the thread to send the message in")
+	commandMessage.Flags().String(flagSubject, "", "subject of the message")
+	commandMessage.Flags().String(flagReplyTo, "", "tx id of the message this message replies to")
+}
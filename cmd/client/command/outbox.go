@@ -0,0 +1,74 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandOutbox = &cobra.Command{
+	Use:   "outbox",
+	Short: "Lists the transactions broadcast by the daemon and their states.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 0 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandOutbox)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		var count uint32
+		read := bytes.NewReader(response)
+		if err := binary.Read(read, binary.LittleEndian, &count); err != nil {
+			logger.Fatal(ctx, "Failed to read outbox count : %s", err)
+		}
+
+		fmt.Printf("Outbox : \n")
+		for i := uint32(0); i < count; i++ {
+			var otx wallet.OutboxTx
+			if err := otx.Deserialize(read); err != nil {
+				logger.Fatal(ctx, "Failed to read outbox tx : %s", err)
+			}
+
+			fmt.Printf("  %s %s (%s, broadcast %d times)\n",
+				time.Unix(0, int64(otx.Timestamp)).Format(time.RFC3339), otx.Tx.TxHash().String(),
+				wallet.OutboxStateName[otx.State], otx.Attempts)
+
+			if otx.Funds != nil {
+				fmt.Printf("    Funding for : %s\n", otx.Funds.String())
+			}
+		}
+
+		return nil
+	},
+}
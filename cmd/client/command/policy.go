@@ -0,0 +1,213 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/relationships"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagAutoAccept     = "auto-accept"
+	flagAllow          = "allow"
+	flagAllowIdentity  = "allow-identity"
+	flagBlock          = "block"
+	flagRemove         = "remove"
+	flagRemoveIdentity = "remove-identity"
+)
+
+var commandPolicy = &cobra.Command{
+	Use:   "policy",
+	Short: "Show or change the policy for relationships initiated with you.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 0 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, []byte(node.CommandPolicy))
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		var p relationships.Policy
+		if err := p.Deserialize(bytes.NewReader(response)); err != nil {
+			logger.Fatal(ctx, "Failed to read policy : %s", err)
+		}
+
+		changed := false
+
+		if c.Flags().Changed(flagAutoAccept) {
+			p.AutoAccept, err = c.Flags().GetBool(flagAutoAccept)
+			if err != nil {
+				logger.Fatal(ctx, "Failed to get auto accept : %s", err)
+			}
+			changed = true
+		}
+
+		removeArgs, err := c.Flags().GetStringSlice(flagRemove)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get remove addresses : %s", err)
+		}
+
+		for _, arg := range removeArgs {
+			publicKey := parsePublicKey(ctx, arg)
+			p.Allow = removeKey(p.Allow, publicKey)
+			p.Block = removeKey(p.Block, publicKey)
+			changed = true
+		}
+
+		allowArgs, err := c.Flags().GetStringSlice(flagAllow)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get allow addresses : %s", err)
+		}
+
+		for _, arg := range allowArgs {
+			publicKey := parsePublicKey(ctx, arg)
+			p.Block = removeKey(p.Block, publicKey)
+			p.Allow = append(removeKey(p.Allow, publicKey), publicKey)
+			changed = true
+		}
+
+		blockArgs, err := c.Flags().GetStringSlice(flagBlock)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get block addresses : %s", err)
+		}
+
+		for _, arg := range blockArgs {
+			publicKey := parsePublicKey(ctx, arg)
+			p.Allow = removeKey(p.Allow, publicKey)
+			p.Block = append(removeKey(p.Block, publicKey), publicKey)
+			changed = true
+		}
+
+		removeIdentities, err := c.Flags().GetStringSlice(flagRemoveIdentity)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get remove identities : %s", err)
+		}
+
+		for _, identity := range removeIdentities {
+			p.AllowIdentities = removeString(p.AllowIdentities, identity)
+			changed = true
+		}
+
+		allowIdentities, err := c.Flags().GetStringSlice(flagAllowIdentity)
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get allow identities : %s", err)
+		}
+
+		for _, identity := range allowIdentities {
+			p.AllowIdentities = append(removeString(p.AllowIdentities, identity), identity)
+			changed = true
+		}
+
+		if changed {
+			var buf bytes.Buffer
+			if _, err := buf.Write([]byte(node.CommandSetPolicy)); err != nil {
+				logger.Fatal(ctx, "Failed to write command name : %s", err)
+			}
+
+			if err := p.Serialize(&buf); err != nil {
+				logger.Fatal(ctx, "Failed to write policy : %s", err)
+			}
+
+			response := sendCommand(ctx, cfg, buf.Bytes())
+
+			if t, m := isError(response); t {
+				logger.Fatal(ctx, "Error Response : %s", m)
+			}
+
+			fmt.Printf("%s\n", string(response))
+		}
+
+		fmt.Printf("Policy : \n")
+		fmt.Printf("  Auto Accept : %t\n", p.AutoAccept)
+
+		fmt.Printf("  Allow : \n")
+		for _, publicKey := range p.Allow {
+			printPublicKey(ctx, cfg, publicKey)
+		}
+
+		fmt.Printf("  Allow Identities : \n")
+		for _, identity := range p.AllowIdentities {
+			fmt.Printf("    %s\n", identity)
+		}
+
+		fmt.Printf("  Block : \n")
+		for _, publicKey := range p.Block {
+			printPublicKey(ctx, cfg, publicKey)
+		}
+
+		return nil
+	},
+}
+
+func parsePublicKey(ctx context.Context, address string) bitcoin.PublicKey {
+	publicKey, err := config.PublicKeyFromAddress(address)
+	if err != nil {
+		logger.Fatal(ctx, "Failed to parse address : %s", err)
+	}
+
+	return publicKey
+}
+
+func printPublicKey(ctx context.Context, cfg *config.Config, publicKey bitcoin.PublicKey) {
+	ra, err := bitcoin.NewRawAddressPublicKey(publicKey)
+	if err != nil {
+		logger.Fatal(ctx, "Failed to get address : %s", err)
+	}
+
+	fmt.Printf("    %s\n", bitcoin.NewAddressFromRawAddress(ra, cfg.Net).String())
+}
+
+func removeKey(keys []bitcoin.PublicKey, publicKey bitcoin.PublicKey) []bitcoin.PublicKey {
+	var result []bitcoin.PublicKey
+	for _, key := range keys {
+		if !key.Equal(publicKey) {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func removeString(list []string, value string) []string {
+	var result []string
+	for _, s := range list {
+		if s != value {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func init() {
+	commandPolicy.Flags().Bool(flagAutoAccept, false, "accept all relationships that aren't blocked")
+	commandPolicy.Flags().StringSlice(flagAllow, nil, "relationship address of initiator to accept")
+	commandPolicy.Flags().StringSlice(flagAllowIdentity, nil,
+		"verified identity of initiator to accept")
+	commandPolicy.Flags().StringSlice(flagBlock, nil, "relationship address of initiator to ignore")
+	commandPolicy.Flags().StringSlice(flagRemove, nil,
+		"relationship address to remove from allow and block")
+	commandPolicy.Flags().StringSlice(flagRemoveIdentity, nil, "identity to remove from allow")
+}
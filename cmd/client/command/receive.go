@@ -54,10 +54,7 @@ var commandReceive = &cobra.Command{
 			logger.Fatal(ctx, "Failed to write type : %s", err)
 		}
 
-		response, err := node.SendCommand(ctx, cfg, buf.Bytes())
-		if err != nil {
-			logger.Fatal(ctx, "Failed to send command : %s", err)
-		}
+		response := sendCommand(ctx, cfg, buf.Bytes())
 
 		if t, m := isError(response); t {
 			logger.Fatal(ctx, "Error Response : %s", m)
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandSign = &cobra.Command{
+	Use:   "sign <co-signed message hex>",
+	Short: "Sign the inputs of a co-signed message that are from this wallet. The message is sent when all inputs are signed.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		csm, err := hex.DecodeString(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse co-signed message hex : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandSign)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if _, err := buf.Write(csm); err != nil {
+			logger.Fatal(ctx, "Failed to write co-signed message : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		read := bytes.NewReader(response)
+		var complete bool
+		if err := binary.Read(read, binary.LittleEndian, &complete); err != nil {
+			logger.Fatal(ctx, "Failed to read complete : %s", err)
+		}
+
+		if complete {
+			fmt.Printf("Message Sent\n")
+			return nil
+		}
+
+		fmt.Printf("Co-signed message : %s\n", hex.EncodeToString(response[1:]))
+		return nil
+	},
+}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/node"
+	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/relationships"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var commandThreads = &cobra.Command{
+	Use:   "threads <relationship tx id>",
+	Short: "Lists the threads in the relationship that was initiated in the specified transaction.",
+	RunE: func(c *cobra.Command, args []string) error {
+		ctx := Context()
+
+		if len(args) != 1 {
+			c.Help()
+			logger.Fatal(ctx, "Wrong number of arguments")
+		}
+
+		envConfig, err := config.Environment()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to get config : %s", err)
+		}
+
+		cfg, err := envConfig.Config()
+		if err != nil {
+			logger.Fatal(ctx, "Failed to convert config : %s", err)
+		}
+
+		txid, err := bitcoin.NewHash32FromStr(args[0])
+		if err != nil {
+			logger.Fatal(ctx, "Failed to parse txid : %s", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.Write([]byte(node.CommandThreads)); err != nil {
+			logger.Fatal(ctx, "Failed to write command name : %s", err)
+		}
+
+		if err := txid.Serialize(&buf); err != nil {
+			logger.Fatal(ctx, "Failed to write txid : %s", err)
+		}
+
+		response := sendCommand(ctx, cfg, buf.Bytes())
+
+		if t, m := isError(response); t {
+			logger.Fatal(ctx, "Error Response : %s", m)
+		}
+
+		var count uint32
+		read := bytes.NewReader(response)
+		if err := binary.Read(read, binary.LittleEndian, &count); err != nil {
+			logger.Fatal(ctx, "Failed to read thread count : %s", err)
+		}
+
+		fmt.Printf("Threads : \n")
+		for i := uint32(0); i < count; i++ {
+			var t relationships.Thread
+			if err := t.Deserialize(read); err != nil {
+				logger.Fatal(ctx, "Failed to read thread : %s", err)
+			}
+
+			fmt.Printf("  %s %s\n", t.TxId.String(), t.Name)
+		}
+
+		return nil
+	},
+}
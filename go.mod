@@ -14,6 +14,7 @@ require (
 	github.com/tokenized/smart-contract v0.2.3-0.20200507021731-03fd29fa5b10
 	github.com/tokenized/specification v0.2.3-0.20200507021905-6e47e4a34a62
 	go.opencensus.io v0.22.2
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	launchpad.net/gocheck v0.0.0-20140225173054-000000000087 // indirect
 )
 
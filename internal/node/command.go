@@ -4,27 +4,81 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"time"
 
 	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/relationships"
 
 	"github.com/tokenized/specification/dist/golang/messages"
 
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 	"github.com/tokenized/smart-contract/pkg/logger"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 )
 
 const (
-	CommandReceive  = "rec"
-	CommandInitiate = "ini"
-	CommandAccept   = "acc"
-	CommandMessage  = "mes"
-	CommandList     = "lst"
+	CommandReceive       = "rec"
+	CommandInitiate      = "ini"
+	CommandPendingAccept = "pac"
+	CommandAccept        = "acc"
+	CommandAmend         = "amd"
+	CommandMessage       = "mes"
+	CommandSign          = "sgn"
+	CommandList          = "lst"
+	CommandHistory       = "hst"
+	CommandClose         = "cls"
+	CommandDecline       = "dcl"
+	CommandPolicy        = "pol"
+	CommandSetPolicy     = "spl"
+	CommandThread        = "thr"
+	CommandThreads       = "ths"
+	CommandAttach        = "att"
+	CommandRead          = "red"
+	CommandExport        = "exp"
+	CommandImport        = "imp"
+	CommandSweep         = "swp"
+	CommandOutbox        = "obx"
+	CommandResend        = "rsd"
+)
+
+// queuedPrefix starts a response to a command whose txs weren't all broadcast. It is followed by
+//   the count and txids of the txs that are queued in the outbox, then the command's response.
+const queuedPrefix = "queued: "
+
+// QueuedError is returned by SendCommand when the command completed, but some of its txs weren't
+//   broadcast yet. They are kept in the outbox and broadcast again later.
+type QueuedError struct {
+	TxIds []bitcoin.Hash32
+}
+
+func (e *QueuedError) Error() string {
+	result := "Not broadcast yet, queued in the outbox for retry :"
+	for _, txid := range e.TxIds {
+		result += " " + txid.String()
+	}
+	return result
+}
+
+// Identity options at the end of the initiate, pending accept, and accept commands that specify
+//   the proof of identity to include.
+const (
+	// IdentityDefault includes the identity configured in the entity config, if there is one.
+	IdentityDefault = uint8(0)
+
+	// IdentityNone doesn't include a proof of identity.
+	IdentityNone = uint8(1)
+
+	// IdentityPaymail is followed by a paymail handle.
+	IdentityPaymail = uint8(2)
+
+	// IdentityEntity is followed by a JSON entity.
+	IdentityEntity = uint8(3)
 )
 
 func (n *Node) RunCommandServer(ctx context.Context) error {
@@ -87,9 +141,34 @@ func (n *Node) RunConnection(ctx context.Context, conn net.Conn) error {
 			return errors.Wrap(err, "receive response")
 		}
 
-		logger.Info(ctx, "Received command : %x", command)
+		if isSecretCommand(command) {
+			// Don't log the passphrase
+			logger.Info(ctx, "Received command : %s", string(command[:3]))
+		} else {
+			logger.Info(ctx, "Received command : %x", command)
+		}
 
+		// Hold the lock so commands and the funding refill don't spend the same UTXOs.
+		n.lock.Lock()
+		start := uint64(time.Now().UnixNano())
 		response, err := n.ProcessCommand(ctx, command)
+		if err == nil {
+			if queued := n.wallet.QueuedTxs(start); len(queued) > 0 {
+				response = queuedResponse(queued, response)
+			}
+		}
+
+		// Save before responding so the changes made by the command aren't lost. A command that
+		//   fails may have made some changes, so it is saved too. The process lock is held so a tx
+		//   being processed doesn't change the state while it is saved.
+		if len(command) >= 3 && changesState(string(command[:3])) {
+			n.processLock.Lock()
+			if saveErr := n.Save(ctx); saveErr != nil {
+				logger.Error(ctx, "Failed to save after command : %s", saveErr)
+			}
+			n.processLock.Unlock()
+		}
+		n.lock.Unlock()
 
 		if err != nil {
 			if err := writeBytes(conn, []byte("err: "+err.Error())); err != nil {
@@ -113,6 +192,37 @@ func (n *Node) RunConnection(ctx context.Context, conn net.Conn) error {
 	return nil
 }
 
+// isSecretCommand returns true if the command or its response contains data that shouldn't be
+//   logged, like a passphrase or exported relationships.
+func isSecretCommand(command []byte) bool {
+	return len(command) >= 3 && (string(command[:3]) == CommandExport ||
+		string(command[:3]) == CommandImport)
+}
+
+// queuedResponse prefixes the response with the txids of the command's txs that are queued for
+//   broadcast.
+func queuedResponse(txids []bitcoin.Hash32, response []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte(queuedPrefix))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(txids)))
+	for _, txid := range txids {
+		txid.Serialize(&buf)
+	}
+	buf.Write(response)
+	return buf.Bytes()
+}
+
+// changesState returns true if the command can change the wallet or relationships, so they need
+//   to be saved after it.
+func changesState(name string) bool {
+	switch name {
+	case CommandList, CommandHistory, CommandPolicy, CommandThreads, CommandExport,
+		CommandOutbox:
+		return false
+	}
+	return true
+}
+
 func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, error) {
 	buf := bytes.NewReader(command)
 
@@ -156,8 +266,12 @@ func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, erro
 			members = append(members, publicKey)
 		}
 
-		// TODO Add support for proof of identity --ce
-		txid, _, err := n.rs.InitiateRelationship(ctx, members, nil)
+		proofOfIdentity, err := n.readProofOfIdentity(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "proof of identity")
+		}
+
+		txid, _, err := n.rs.InitiateRelationship(ctx, members, proofOfIdentity)
 		if err != nil {
 			return nil, errors.Wrap(err, "initiate relationship")
 		}
@@ -175,13 +289,88 @@ func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, erro
 			return nil, errors.New("Relationship not found")
 		}
 
-		_, err := n.rs.AcceptRelationship(ctx, r, nil)
+		proofOfIdentity, err := n.readProofOfIdentity(buf)
 		if err != nil {
+			return nil, errors.Wrap(err, "proof of identity")
+		}
+
+		if _, err := n.rs.AcceptRelationship(ctx, r, proofOfIdentity); err != nil {
 			return nil, errors.Wrap(err, "accept relationship")
 		}
 
 		return []byte("Accept Sent"), nil
 
+	case CommandPendingAccept:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		proofOfIdentity, err := n.readProofOfIdentity(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "proof of identity")
+		}
+
+		if _, err := n.rs.SendPendingAccept(ctx, r, proofOfIdentity); err != nil {
+			return nil, errors.Wrap(err, "pending accept relationship")
+		}
+
+		return []byte("Pending Accept Sent"), nil
+
+	case CommandAmend:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		var addCount uint32
+		if err := binary.Read(buf, binary.LittleEndian, &addCount); err != nil {
+			return nil, errors.Wrap(err, "add member count")
+		}
+
+		addMembers := make([]bitcoin.PublicKey, 0, addCount)
+		for i := uint32(0); i < addCount; i++ {
+			var ra bitcoin.RawAddress
+			if err := ra.Deserialize(buf); err != nil {
+				return nil, errors.Wrap(err, "deserialize address")
+			}
+
+			publicKey, err := ra.GetPublicKey()
+			if err != nil {
+				return nil, errors.Wrap(err, "get public key")
+			}
+
+			addMembers = append(addMembers, publicKey)
+		}
+
+		var dropCount uint32
+		if err := binary.Read(buf, binary.LittleEndian, &dropCount); err != nil {
+			return nil, errors.Wrap(err, "drop member count")
+		}
+
+		dropMembers := make([]uint32, dropCount)
+		for i := range dropMembers {
+			if err := binary.Read(buf, binary.LittleEndian, &dropMembers[i]); err != nil {
+				return nil, errors.Wrap(err, "drop member index")
+			}
+		}
+
+		if _, err := n.rs.AmendRelationship(ctx, r, addMembers, dropMembers); err != nil {
+			return nil, errors.Wrap(err, "amend relationship")
+		}
+
+		return []byte("Amendment Sent"), nil
+
 	case CommandMessage:
 		var txid bitcoin.Hash32
 		if err := txid.Deserialize(buf); err != nil {
@@ -205,18 +394,232 @@ func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, erro
 
 		// Plain text message
 		message := &messages.PrivateMessage{
+			Timestamp: uint64(time.Now().UnixNano()),
 			PrivateMessage: &messages.DocumentField{
 				Type:     "text/plain",
 				Contents: b,
 			},
 		}
 
+		// Optional co-signer member indexes
+		var coSigners []uint32
+		if buf.Len() > 0 {
+			var count uint32
+			if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+				return nil, errors.Wrap(err, "co-signer count")
+			}
+
+			coSigners = make([]uint32, count)
+			for i := range coSigners {
+				if err := binary.Read(buf, binary.LittleEndian, &coSigners[i]); err != nil {
+					return nil, errors.Wrap(err, "co-signer index")
+				}
+			}
+		}
+
+		// Optional thread txid
+		thread, err := readOptionalTxId(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read thread")
+		}
+
+		// Optional subject
+		if buf.Len() > 0 {
+			message.Subject, err = readString(buf)
+			if err != nil {
+				return nil, errors.Wrap(err, "read subject")
+			}
+		}
+
+		// Optional txid of the message this replies to
+		replyTo, err := readOptionalTxId(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read reply to")
+		}
+
+		if replyTo != nil {
+			if n.rs.FindMessage(ctx, r, *replyTo) == nil {
+				return nil, errors.New("Reply to message not found")
+			}
+
+			message.Regarding = &messages.OutpointField{
+				TxId: replyTo.Bytes(),
+			}
+		}
+
+		if len(coSigners) > 0 {
+			if thread != nil {
+				return nil, errors.New("Co-signed thread messages not supported")
+			}
+
+			csm, err := n.rs.CreateCoSignedMessage(ctx, r, message, coSigners)
+			if err != nil {
+				return nil, errors.Wrap(err, "create co-signed message")
+			}
+
+			var response bytes.Buffer
+			if err := csm.Serialize(&response); err != nil {
+				return nil, errors.Wrap(err, "serialize co-signed message")
+			}
+
+			return response.Bytes(), nil
+		}
+
+		if thread != nil {
+			if err := n.rs.SendThreadMessage(ctx, r, *thread, message); err != nil {
+				return nil, errors.Wrap(err, "send thread message")
+			}
+
+			return []byte("Message Sent"), nil
+		}
+
 		if err := n.rs.SendMessage(ctx, r, message); err != nil {
 			return nil, errors.Wrap(err, "send message")
 		}
 
 		return []byte("Message Sent"), nil
 
+	case CommandAttach:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		text, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read message")
+		}
+
+		message := &messages.PrivateMessage{
+			Timestamp: uint64(time.Now().UnixNano()),
+		}
+
+		if len(text) > 0 {
+			message.PrivateMessage = &messages.DocumentField{
+				Type:     "text/plain",
+				Contents: []byte(text),
+			}
+		}
+
+		var hashOnly bool
+		if err := binary.Read(buf, binary.LittleEndian, &hashOnly); err != nil {
+			return nil, errors.Wrap(err, "hash only")
+		}
+
+		var count uint32
+		if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+			return nil, errors.Wrap(err, "attachment count")
+		}
+
+		if count == 0 {
+			return nil, errors.New("Missing attachments")
+		}
+
+		for i := uint32(0); i < count; i++ {
+			attachment := &messages.DocumentField{}
+
+			attachment.Name, err = readString(buf)
+			if err != nil {
+				return nil, errors.Wrap(err, "read attachment name")
+			}
+
+			attachment.Type, err = readString(buf)
+			if err != nil {
+				return nil, errors.Wrap(err, "read attachment type")
+			}
+
+			attachment.Contents, err = readBytes(buf)
+			if err != nil {
+				return nil, errors.Wrap(err, "read attachment contents")
+			}
+
+			message.Attachments = append(message.Attachments, attachment)
+		}
+
+		// Optional thread txid
+		thread, err := readOptionalTxId(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read thread")
+		}
+
+		if err := n.rs.SendAttachments(ctx, r, thread, message, hashOnly); err != nil {
+			return nil, errors.Wrap(err, "send attachments")
+		}
+
+		return []byte("Message Sent"), nil
+
+	case CommandRead:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		var messageTxId bitcoin.Hash32
+		if err := messageTxId.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize message txid")
+		}
+
+		if err := n.rs.SendReadReceipt(ctx, r, messageTxId); err != nil {
+			return nil, errors.Wrap(err, "send read receipt")
+		}
+
+		return []byte("Read Receipt Sent"), nil
+
+	case CommandResend:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		var messageTxId bitcoin.Hash32
+		if err := messageTxId.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize message txid")
+		}
+
+		if err := n.rs.Resend(ctx, r, messageTxId); err != nil {
+			return nil, errors.Wrap(err, "resend")
+		}
+
+		return []byte("Message Resent"), nil
+
+	case CommandSign:
+		var csm relationships.CoSignedMessage
+		if err := csm.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize co-signed message")
+		}
+
+		complete, err := n.rs.SignCoSignedMessage(ctx, &csm)
+		if err != nil {
+			return nil, errors.Wrap(err, "sign co-signed message")
+		}
+
+		// Complete flag followed by the co-signed message with our signatures.
+		var response bytes.Buffer
+		if err := binary.Write(&response, binary.LittleEndian, complete); err != nil {
+			return nil, errors.Wrap(err, "write complete")
+		}
+
+		if err := csm.Serialize(&response); err != nil {
+			return nil, errors.Wrap(err, "serialize co-signed message")
+		}
+
+		return response.Bytes(), nil
+
 	case CommandList:
 		l := n.rs.ListRelationships(ctx)
 
@@ -229,6 +632,278 @@ func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, erro
 			if _, err := buf.Write(r.TxId[:]); err != nil {
 				return nil, errors.Wrap(err, "write relationship")
 			}
+
+			if err := binary.Write(&buf, binary.LittleEndian, r.Closed); err != nil {
+				return nil, errors.Wrap(err, "write closed")
+			}
+
+			if err := binary.Write(&buf, binary.LittleEndian, r.Declined); err != nil {
+				return nil, errors.Wrap(err, "write declined")
+			}
+
+			if err := binary.Write(&buf, binary.LittleEndian, uint32(len(r.Members))); err != nil {
+				return nil, errors.Wrap(err, "write member count")
+			}
+
+			for _, m := range r.Members {
+				ra, err := bitcoin.NewRawAddressPublicKey(m.BaseKey)
+				if err != nil {
+					return nil, errors.Wrap(err, "member address")
+				}
+
+				if err := ra.Serialize(&buf); err != nil {
+					return nil, errors.Wrap(err, "write member")
+				}
+
+				if err := binary.Write(&buf, binary.LittleEndian, m.Declined); err != nil {
+					return nil, errors.Wrap(err, "write member declined")
+				}
+			}
+		}
+
+		return buf.Bytes(), nil
+
+	case CommandClose:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		if err := n.rs.CloseRelationship(ctx, r); err != nil {
+			return nil, errors.Wrap(err, "close relationship")
+		}
+
+		return []byte("Relationship Closed"), nil
+
+	case CommandDecline:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		var stopMonitoring bool
+		if err := binary.Read(buf, binary.LittleEndian, &stopMonitoring); err != nil {
+			return nil, errors.Wrap(err, "read stop monitoring")
+		}
+
+		if err := n.rs.DeclineRelationship(ctx, r, stopMonitoring); err != nil {
+			return nil, errors.Wrap(err, "decline relationship")
+		}
+
+		return []byte("Decline Sent"), nil
+
+	case CommandPolicy:
+		p := n.rs.GetPolicy(ctx)
+
+		var buf bytes.Buffer
+		if err := p.Serialize(&buf); err != nil {
+			return nil, errors.Wrap(err, "serialize policy")
+		}
+
+		return buf.Bytes(), nil
+
+	case CommandSetPolicy:
+		var p relationships.Policy
+		if err := p.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize policy")
+		}
+
+		n.rs.SetPolicy(ctx, p)
+
+		return []byte("Policy Updated"), nil
+
+	case CommandExport:
+		passphrase, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read passphrase")
+		}
+
+		b, err := n.rs.Export(ctx, passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "export")
+		}
+
+		return b, nil
+
+	case CommandImport:
+		passphrase, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read passphrase")
+		}
+
+		b, err := readBytes(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read export")
+		}
+
+		added, err := n.rs.Import(ctx, passphrase, b)
+		if err != nil {
+			return nil, errors.Wrap(err, "import")
+		}
+
+		return []byte(fmt.Sprintf("Imported %d new relationships", added)), nil
+
+	case CommandSweep:
+		txs, err := n.wallet.Sweep(ctx, n)
+		if len(txs) == 0 {
+			if err != nil {
+				return nil, errors.Wrap(err, "sweep")
+			}
+			return []byte("Nothing to sweep"), nil
+		}
+
+		var response bytes.Buffer
+		for _, tx := range txs {
+			response.WriteString(fmt.Sprintf("Swept %d UTXOs : %s\n", len(tx.TxIn),
+				tx.TxHash().String()))
+		}
+
+		if err != nil {
+			// Some relationships were swept before the error.
+			response.WriteString(fmt.Sprintf("Failed to sweep the rest : %s\n", err))
+		}
+
+		return response.Bytes(), nil
+
+	case CommandOutbox:
+		outbox := n.wallet.GetOutbox(ctx)
+
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(outbox))); err != nil {
+			return nil, errors.Wrap(err, "write outbox count")
+		}
+
+		for _, otx := range outbox {
+			if err := otx.Serialize(&buf); err != nil {
+				return nil, errors.Wrap(err, "write outbox tx")
+			}
+		}
+
+		return buf.Bytes(), nil
+
+	case CommandThread:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		name, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read name")
+		}
+
+		if _, err := n.rs.InitiateThread(ctx, r, name); err != nil {
+			return nil, errors.Wrap(err, "initiate thread")
+		}
+
+		return []byte("Thread Initiated"), nil
+
+	case CommandThreads:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(r.Threads))); err != nil {
+			return nil, errors.Wrap(err, "write thread count")
+		}
+
+		for _, t := range r.Threads {
+			if err := t.Serialize(&buf); err != nil {
+				return nil, errors.Wrap(err, "write thread")
+			}
+		}
+
+		return buf.Bytes(), nil
+
+	case CommandHistory:
+		var txid bitcoin.Hash32
+		if err := txid.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "deserialize txid")
+		}
+
+		r := n.rs.FindRelationshipForTxId(ctx, txid)
+		if r == nil {
+			return nil, errors.New("Relationship not found")
+		}
+
+		// Optional thread txid
+		thread, err := readOptionalTxId(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read thread")
+		}
+
+		// Optional txid of a message to show the conversation of
+		conversation, err := readOptionalTxId(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read conversation")
+		}
+
+		var history []*relationships.Message
+		switch {
+		case thread != nil && conversation != nil:
+			return nil, errors.New("Thread and conversation can't both be specified")
+
+		case thread != nil:
+			if r.FindThread(*thread) == nil {
+				return nil, errors.New("Thread not found")
+			}
+
+			history = n.rs.GetThreadHistory(ctx, r, *thread)
+
+		case conversation != nil:
+			// The messages the message replies to, the message, then the replies to it.
+			history = n.rs.GetReplyChain(ctx, r, *conversation)
+			if len(history) == 0 {
+				return nil, errors.New("Message not found")
+			}
+
+			history = append(history, n.rs.GetReplies(ctx, r, *conversation)...)
+
+		default:
+			history = n.rs.GetHistory(ctx, r)
+		}
+
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(history))); err != nil {
+			return nil, errors.Wrap(err, "write message count")
+		}
+
+		// Each message is followed by the outbox state of its tx, if we sent it.
+		for _, m := range history {
+			if err := m.Serialize(&buf); err != nil {
+				return nil, errors.Wrap(err, "write message")
+			}
+
+			state, inOutbox := n.wallet.OutboxState(m.TxId)
+			if err := binary.Write(&buf, binary.LittleEndian, inOutbox); err != nil {
+				return nil, errors.Wrap(err, "write outbox flag")
+			}
+
+			if err := binary.Write(&buf, binary.LittleEndian, state); err != nil {
+				return nil, errors.Wrap(err, "write outbox state")
+			}
 		}
 
 		return buf.Bytes(), nil
@@ -237,6 +912,54 @@ func (n *Node) ProcessCommand(ctx context.Context, command []byte) ([]byte, erro
 	return nil, fmt.Errorf("Unknown command name : %s", string(name))
 }
 
+// readProofOfIdentity reads the identity option from a command and returns the unsigned proof of
+//   identity to include in the message.
+func (n *Node) readProofOfIdentity(buf *bytes.Reader) (proto.Message, error) {
+	if buf.Len() == 0 {
+		return n.rs.DefaultProofOfIdentity()
+	}
+
+	var option uint8
+	if err := binary.Read(buf, binary.LittleEndian, &option); err != nil {
+		return nil, errors.Wrap(err, "read identity option")
+	}
+
+	switch option {
+	case IdentityDefault:
+		return n.rs.DefaultProofOfIdentity()
+
+	case IdentityNone:
+		return nil, nil
+
+	case IdentityPaymail:
+		handle, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read handle")
+		}
+
+		return &messages.PaymailProofField{
+			Handle: handle,
+		}, nil
+
+	case IdentityEntity:
+		entityJSON, err := readString(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read entity")
+		}
+
+		entity := &messages.EntityField{}
+		if err := json.Unmarshal([]byte(entityJSON), entity); err != nil {
+			return nil, errors.Wrap(err, "unmarshal entity")
+		}
+
+		return &messages.IdentityOracleProofField{
+			Entity: entity,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown identity option : %d", option)
+}
+
 func SendCommand(ctx context.Context, cfg *config.Config, command []byte) ([]byte, error) {
 	address, err := net.ResolveUnixAddr("unix", cfg.CommandPath)
 	if err != nil {
@@ -252,7 +975,13 @@ func SendCommand(ctx context.Context, cfg *config.Config, command []byte) ([]byt
 		return nil, errors.Wrap(err, "send command")
 	}
 
-	logger.Info(ctx, "Sent command : %x", command)
+	secret := isSecretCommand(command)
+	if secret {
+		// Don't log the passphrase
+		logger.Info(ctx, "Sent command : %s", string(command[:3]))
+	} else {
+		logger.Info(ctx, "Sent command : %x", command)
+	}
 
 	response, err := readBytes(conn)
 	if err != nil {
@@ -262,12 +991,37 @@ func SendCommand(ctx context.Context, cfg *config.Config, command []byte) ([]byt
 		return nil, errors.Wrap(err, "receive response")
 	}
 
-	logger.Info(ctx, "Received response : %x", response)
+	if secret {
+		// Don't log the exported relationships
+		logger.Info(ctx, "Received response : %d bytes", len(response))
+	} else {
+		logger.Info(ctx, "Received response : %x", response)
+	}
 
 	if err := conn.Close(); err != nil {
 		return nil, errors.Wrap(err, "close")
 	}
 
+	if bytes.HasPrefix(response, []byte(queuedPrefix)) {
+		buf := bytes.NewReader(response[len(queuedPrefix):])
+
+		var count uint32
+		if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+			return nil, errors.Wrap(err, "read queued count")
+		}
+
+		queued := &QueuedError{}
+		for i := uint32(0); i < count; i++ {
+			var txid bitcoin.Hash32
+			if err := txid.Deserialize(buf); err != nil {
+				return nil, errors.Wrap(err, "read queued txid")
+			}
+			queued.TxIds = append(queued.TxIds, txid)
+		}
+
+		return response[len(response)-buf.Len():], queued
+	}
+
 	return response, nil
 }
 
@@ -298,9 +1052,47 @@ func readBytes(r io.Reader) ([]byte, error) {
 	}
 
 	b := make([]byte, size)
-	if _, err := r.Read(b); err != nil {
+	if _, err := io.ReadFull(r, b); err != nil {
 		return nil, errors.Wrap(err, "read bytes")
 	}
 
 	return b, nil
 }
+
+// readOptionalTxId reads a bool specifying if a txid follows, then the txid. It returns nil when
+//   there is no txid.
+func readOptionalTxId(buf *bytes.Reader) (*bitcoin.Hash32, error) {
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+
+	var included bool
+	if err := binary.Read(buf, binary.LittleEndian, &included); err != nil {
+		return nil, errors.Wrap(err, "included")
+	}
+
+	if !included {
+		return nil, nil
+	}
+
+	var txid bitcoin.Hash32
+	if err := txid.Deserialize(buf); err != nil {
+		return nil, errors.Wrap(err, "txid")
+	}
+
+	return &txid, nil
+}
+
+func readString(r io.Reader) (string, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return "", errors.Wrap(err, "read string length")
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errors.Wrap(err, "read string")
+	}
+
+	return string(b), nil
+}
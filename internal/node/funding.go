@@ -0,0 +1,59 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+// fundingInterval is how often the pre-funded relationship keys are checked.
+const fundingInterval = 10 * time.Second
+
+// RunFunding periodically refills the pre-funded keys of relationships while the node is in sync.
+//   It returns when the node is stopped.
+func (n *Node) RunFunding(ctx context.Context) {
+	if n.cfg.PrefundCount <= 0 {
+		return
+	}
+
+	last := time.Time{}
+	for {
+		// Check for stop request
+		val := n.stop.Load()
+		s, ok := val.(bool)
+		if !ok || s {
+			break
+		}
+
+		if !n.IsInSync() || n.rs.IsRecovering() || time.Since(last) < fundingInterval {
+			time.Sleep(time.Second)
+			continue
+		}
+		last = time.Now()
+
+		if err := n.refillFunding(ctx); err != nil {
+			logger.Warn(ctx, "Failed to refill relationship funding : %s", err)
+		}
+	}
+}
+
+// refillFunding refills the pre-funded keys. The lock is held so the funding txs don't spend the
+//   same UTXOs as a command. The funding txs created before an error are still saved.
+func (n *Node) refillFunding(ctx context.Context) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	txs, refillErr := n.rs.RefillFunding(ctx)
+	if len(txs) == 0 {
+		return refillErr
+	}
+
+	n.processLock.Lock()
+	defer n.processLock.Unlock()
+	if err := n.Save(ctx); err != nil {
+		return err
+	}
+
+	return refillErr
+}
@@ -2,6 +2,9 @@ package node
 
 import (
 	"context"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/wallet"
 
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 	"github.com/tokenized/smart-contract/pkg/logger"
@@ -9,6 +12,8 @@ import (
 	"github.com/tokenized/smart-contract/pkg/wire"
 
 	"github.com/tokenized/specification/dist/golang/protocol"
+
+	"github.com/pkg/errors"
 )
 
 // Implement spynode Listener interface
@@ -24,6 +29,12 @@ func (n *Node) HandleBlock(ctx context.Context, msgType int, block *handlers.Blo
 			logger.Info(ctx, "New Block (%d) : %s", block.Height, block.Hash.String())
 		} else {
 			logger.Info(ctx, "Refeed Block (%d) : %s", block.Height, block.Hash.String())
+
+			// The recovery replay is complete when the last block before it started is refed.
+			if !n.isRecoveryPending() && n.rs.IsRecovering() &&
+				block.Height >= n.recoverEndHeight {
+				n.completeRecovery(ctx)
+			}
 		}
 	case handlers.ListenerMsgBlockRevert:
 		logger.Info(ctx, "Reverted Block (%d) : %s", block.Height, block.Hash.String())
@@ -143,6 +154,7 @@ func (n *Node) HandleTxState(ctx context.Context, msgType int, txid bitcoin.Hash
 	switch msgType {
 	case handlers.ListenerMsgTxStateSafe:
 		logger.Info(ctx, "Tx Safe : %s", txid.String())
+		n.wallet.SetOutboxState(ctx, txid, wallet.OutboxSafe)
 		t, err := n.wallet.GetTx(ctx, txid)
 		if err != nil {
 			logger.Error(ctx, "Failed get tx : %s : %s", err, txid.String())
@@ -157,6 +169,7 @@ func (n *Node) HandleTxState(ctx context.Context, msgType int, txid bitcoin.Hash
 
 	case handlers.ListenerMsgTxStateConfirm:
 		logger.Info(ctx, "Tx Confirmed : %s", txid.String())
+		n.wallet.SetOutboxState(ctx, txid, wallet.OutboxConfirmed)
 		t, err := n.wallet.GetTx(ctx, txid)
 		if err != nil {
 			logger.Error(ctx, "Failed get tx : %s : %s", err, txid.String())
@@ -169,14 +182,17 @@ func (n *Node) HandleTxState(ctx context.Context, msgType int, txid bitcoin.Hash
 			logger.Info(ctx, "Tx Confirm not found : %s", txid.String())
 		}
 
+		n.rs.MarkConfirmed(ctx, txid)
+
 	case handlers.ListenerMsgTxStateCancel:
 		logger.Info(ctx, "Canceling tx : %s", txid.String())
+		n.wallet.SetOutboxState(ctx, txid, wallet.OutboxCancelled)
 		t, err := n.wallet.GetTx(ctx, txid)
 		if err != nil {
 			logger.Error(ctx, "Failed get tx : %s : %s", err, txid.String())
 		} else if t != nil {
-			if err := n.RevertTx(ctx, t); err != nil {
-				logger.Error(ctx, "Failed to revert tx : %s", err)
+			if err := n.CancelTx(ctx, t); err != nil {
+				logger.Error(ctx, "Failed to cancel tx : %s", err)
 				// TODO Stop the daemon
 			}
 		}
@@ -195,6 +211,7 @@ func (n *Node) HandleTxState(ctx context.Context, msgType int, txid bitcoin.Hash
 
 	case handlers.ListenerMsgTxStateRevert:
 		logger.Info(ctx, "Reverting tx : %s", txid.String())
+		n.wallet.SetOutboxState(ctx, txid, wallet.OutboxBroadcast)
 		t, err := n.wallet.GetTx(ctx, txid)
 		if err != nil {
 			logger.Error(ctx, "Failed get tx : %s : %s", err, txid.String())
@@ -215,5 +232,43 @@ func (n *Node) HandleInSync(ctx context.Context) error {
 	ctx = logger.ContextWithOutLogSubSystem(ctx)
 	n.isInSync.Store(true)
 	logger.Info(ctx, "In Sync")
+
+	// In recovery mode, rescan the chain from the start so that all relationship txs are seen
+	//   again.
+	if n.isRecoveryPending() {
+		height, err := n.findBlockHeight(ctx, n.cfg.RecoverHash)
+		if err != nil {
+			return errors.Wrap(err, "find recovery start block")
+		}
+
+		n.recoverEndHeight = n.blockHeight
+		n.recovering.Store(false)
+
+		if height >= n.recoverEndHeight {
+			n.completeRecovery(ctx) // no blocks to replay
+			return nil
+		}
+
+		logger.Info(ctx, "Recovery refeed blocks from %d to %d", height, n.recoverEndHeight)
+		n.spy.RefeedBlocksFromHeight(ctx, height)
+	}
+
 	return nil
 }
+
+// findBlockHeight returns the height of the block with the hash, searching back from the last
+//   block known by the spynode.
+func (n *Node) findBlockHeight(ctx context.Context, hash bitcoin.Hash32) (int, error) {
+	for height := n.spy.LastHeight(ctx); height >= 0; height-- {
+		h, err := n.spy.Hash(ctx, height)
+		if err != nil {
+			return 0, errors.Wrapf(err, "block hash %d", height)
+		}
+
+		if h.Equal(&hash) {
+			return height, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Block not found : %s", hash.String())
+}
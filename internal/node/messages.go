@@ -26,8 +26,14 @@ func (n *Node) ProcessMessage(ctx context.Context, itx *inspector.Transaction, i
 	switch payload := p.(type) {
 	case *messages.InitiateRelationship:
 		return true, n.rs.ProcessInitiateRelationship(ctx, itx, message, payload, encryptionKey)
+	case *messages.PendingAcceptRelationship:
+		return n.rs.ProcessPendingAcceptRelationship(ctx, itx, message, payload, flag)
 	case *messages.AcceptRelationship:
 		return n.rs.ProcessAcceptRelationship(ctx, itx, message, payload, flag)
+	case *messages.RelationshipAmendment:
+		return n.rs.ProcessRelationshipAmendment(ctx, itx, message, payload, flag)
+	case *messages.InitiateThread:
+		return n.rs.ProcessInitiateThread(ctx, itx, message, payload, flag)
 	case *messages.PrivateMessage:
 		return n.rs.ProcessPrivateMessage(ctx, itx, message, payload, flag)
 	}
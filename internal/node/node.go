@@ -25,6 +25,12 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	// recoveredKey is the key of the hash of the block that recovery started at, saved when
+	//   recovery is complete.
+	recoveredKey = "recovered"
+)
+
 type Node struct {
 	cfg         *config.Config
 	masterDB    *db.DB
@@ -34,12 +40,18 @@ type Node struct {
 	spy         *spynode.Node
 	lock        sync.Mutex
 	processLock sync.Mutex
+	saveLock    sync.Mutex
 	stop        atomic.Value
 	isInSync    atomic.Value
 
 	blockHeight  int
 	refeedNeeded atomic.Value
 
+	// recovering is true until the rescan of the chain for recovery starts. The rescan ends at
+	//   recoverEndHeight, the last block when it started.
+	recovering       atomic.Value
+	recoverEndHeight int
+
 	netListener net.Listener
 	netConns    []net.Conn
 	netLock     sync.Mutex
@@ -63,6 +75,7 @@ func NewNode(cfg *config.Config, masterDB *db.DB, wallet *wallet.Wallet, rpc *rp
 
 	result.stop.Store(false)
 	result.refeedNeeded.Store(false)
+	result.recovering.Store(cfg.Recover)
 	spy.RegisterListener(result)
 
 	return result, nil
@@ -80,12 +93,32 @@ func (n *Node) Run(ctx context.Context) error {
 
 	// return nil
 
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n.RunFunding(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n.RunOutbox(ctx)
+	}()
+
 	commandErr := n.RunCommandServer(ctx)
+
+	// The funding and outbox only finish when stopped, which hasn't happened if the command server
+	//   failed.
+	n.stop.Store(true)
+	wg.Wait()
 	if commandErr != nil {
 		logger.Error(ctx, "Command server returned in error : %s", commandErr)
 	}
 
+	n.processLock.Lock()
 	saveErr := n.Save(ctx)
+	n.processLock.Unlock()
 	if saveErr != nil {
 		logger.Error(ctx, "Failed to save node : %s", saveErr)
 	}
@@ -126,6 +159,10 @@ func (n *Node) PreprocessTx(ctx context.Context, tx *wire.MsgTx) error {
 		return errors.Wrap(err, "process utxos")
 	}
 
+	if err := n.Save(ctx); err != nil {
+		return errors.Wrap(err, "save")
+	}
+
 	return nil
 }
 
@@ -157,6 +194,9 @@ func (n *Node) ProcessTx(ctx context.Context, t *wallet.Transaction) error {
 		}
 	}
 
+	// Record relationship state so the tx can be reverted
+	n.rs.BeginTx(ctx, *t.Itx.Hash)
+
 	// Process any tokenized actions
 	for index, _ := range t.Itx.MsgTx.TxOut {
 		action, encryptionKey, err := n.rs.DecryptAction(ctx, t.Itx, index, flag)
@@ -171,6 +211,7 @@ func (n *Node) ProcessTx(ctx context.Context, t *wallet.Transaction) error {
 		case *actions.Message:
 			refeed, err := n.ProcessMessage(ctx, t.Itx, index, encryptionKey, message, flag)
 			if err != nil {
+				n.rs.EndTx(ctx, *t.Itx.Hash)
 				return errors.Wrap(err, "process message")
 			}
 			if refeed && !n.IsInSync() {
@@ -181,6 +222,12 @@ func (n *Node) ProcessTx(ctx context.Context, t *wallet.Transaction) error {
 		}
 	}
 
+	n.rs.EndTx(ctx, *t.Itx.Hash)
+
+	if err := n.Save(ctx); err != nil {
+		return errors.Wrap(err, "save")
+	}
+
 	return nil
 }
 
@@ -194,6 +241,50 @@ func (n *Node) RevertTx(ctx context.Context, t *wallet.Transaction) error {
 		return errors.Wrap(err, "revert utxos")
 	}
 
+	if _, err := n.rs.RevertTx(ctx, *t.Itx.Hash); err != nil {
+		return errors.Wrap(err, "revert relationships")
+	}
+
+	// Replace the processed tx so it is processed again if it is mined again.
+	if err := n.wallet.AddWireTx(ctx, t.Itx.MsgTx); err != nil {
+		return errors.Wrap(err, "reset tx")
+	}
+
+	if err := n.Save(ctx); err != nil {
+		return errors.Wrap(err, "save")
+	}
+
+	return nil
+}
+
+// CancelTx reverts a tx that was double spent. The UTXOs spent by a tx that wasn't processed yet
+//   were only reserved, so they are released instead. A message we sent in the tx is marked failed
+//   and the hashes it used are restored so it can be sent again.
+func (n *Node) CancelTx(ctx context.Context, t *wallet.Transaction) error {
+	n.processLock.Lock()
+	defer n.processLock.Unlock()
+
+	logger.Info(ctx, "Cancelling tx : %s", t.Itx.Hash.String())
+
+	isFinal := t.Itx.IsPromoted(ctx)
+	if err := n.wallet.RevertUTXOs(ctx, t.Itx.MsgTx, isFinal); err != nil {
+		return errors.Wrap(err, "revert utxos")
+	}
+
+	if isFinal {
+		if _, err := n.rs.RevertTx(ctx, *t.Itx.Hash); err != nil {
+			return errors.Wrap(err, "revert relationships")
+		}
+	}
+
+	if _, err := n.rs.CancelTx(ctx, *t.Itx.Hash); err != nil {
+		return errors.Wrap(err, "cancel relationships")
+	}
+
+	if err := n.Save(ctx); err != nil {
+		return errors.Wrap(err, "save")
+	}
+
 	return nil
 }
 
@@ -220,7 +311,39 @@ func (n *Node) BroadcastTx(ctx context.Context, tx *wire.MsgTx) error {
 	return nil
 }
 
+// Load recovers any save that was interrupted and then loads the wallet and relationships. In
+//   recovery mode the saved state is ignored and only the wallet keys are derived, so the
+//   relationships can be rebuilt from the chain.
 func (n *Node) Load(ctx context.Context) error {
+	recovered, err := n.masterDB.RecoverJournal(ctx)
+	if err != nil {
+		return errors.Wrap(err, "recover journal")
+	}
+	if recovered {
+		logger.Info(ctx, "Recovered interrupted save")
+	}
+
+	if n.cfg.Recover {
+		recovered, err := n.isRecovered(ctx)
+		if err != nil {
+			return errors.Wrap(err, "check recovered")
+		}
+
+		if recovered {
+			logger.Warn(ctx, "Recovery from block %s already complete. Loading saved state",
+				n.cfg.RecoverHash.String())
+			n.recovering.Store(false)
+		} else {
+			logger.Info(ctx, "Recovery mode. Rebuilding relationships from block %s",
+				n.cfg.RecoverHash.String())
+			n.rs.SetRecovering(true)
+			if err := n.wallet.Prepare(ctx); err != nil {
+				return errors.Wrap(err, "prepare wallet")
+			}
+			return nil
+		}
+	}
+
 	if err := n.wallet.Load(ctx, n.masterDB); err != nil {
 		return errors.Wrap(err, "load wallet")
 	}
@@ -231,14 +354,70 @@ func (n *Node) Load(ctx context.Context) error {
 	return nil
 }
 
+// Save saves the wallet and relationships through a journal so that if the process stops during
+//   the save, the previous state or the new state is loaded, but never a mix of both. It is called
+//   after every tx is processed and every command that changes state, so keys and hashes aren't
+//   lost if the process stops unexpectedly. The process lock must already be held so the state
+//   isn't changed by a tx while it is saved.
 func (n *Node) Save(ctx context.Context) error {
-	if err := n.wallet.Save(ctx, n.masterDB); err != nil {
+	n.saveLock.Lock()
+	defer n.saveLock.Unlock()
+
+	journal := db.NewJournal()
+
+	if err := n.wallet.Save(ctx, journal); err != nil {
 		return errors.Wrap(err, "save wallet")
 	}
 
-	if err := n.rs.Save(ctx, n.masterDB); err != nil {
+	if err := n.rs.Save(ctx, journal); err != nil {
 		return errors.Wrap(err, "save relationships")
 	}
 
+	if n.cfg.Recover && !n.isRecoveryPending() && !n.rs.IsRecovering() {
+		// Remember that recovery is complete so the saved state isn't replaced by another recovery
+		//   when the daemon is restarted with recovery still enabled.
+		if err := journal.Put(ctx, recoveredKey, n.cfg.RecoverHash.Bytes()); err != nil {
+			return errors.Wrap(err, "save recovered")
+		}
+	}
+
+	if err := n.masterDB.Commit(ctx, journal); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+
 	return nil
 }
+
+// isRecovered returns true if recovery from the configured start block was already completed.
+func (n *Node) isRecovered(ctx context.Context) (bool, error) {
+	b, err := n.masterDB.Fetch(ctx, recoveredKey)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "fetch recovered")
+	}
+
+	return bytes.Equal(b, n.cfg.RecoverHash.Bytes()), nil
+}
+
+// isRecoveryPending returns true if the rescan of the chain for recovery hasn't started yet.
+func (n *Node) isRecoveryPending() bool {
+	val := n.recovering.Load()
+	recovering, ok := val.(bool)
+	return ok && recovering
+}
+
+// completeRecovery ends recovery after the rescan of the chain is complete and saves the rebuilt
+//   state along with the marker that recovery is complete.
+func (n *Node) completeRecovery(ctx context.Context) {
+	logger.Info(ctx, "Recovery complete")
+	n.rs.SetRecovering(false)
+
+	n.processLock.Lock()
+	defer n.processLock.Unlock()
+
+	if err := n.Save(ctx); err != nil {
+		logger.Error(ctx, "Failed to save after recovery : %s", err)
+	}
+}
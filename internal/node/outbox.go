@@ -0,0 +1,60 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+// outboxInterval is how often the txs in the outbox are broadcast again.
+const outboxInterval = 30 * time.Second
+
+// RunOutbox periodically broadcasts the txs in the wallet's outbox again until spynode reports
+//   them safe, and cancels them when they have been broadcast the maximum number of times. It
+//   returns when the node is stopped.
+func (n *Node) RunOutbox(ctx context.Context) {
+	last := time.Now()
+	for {
+		// Check for stop request
+		val := n.stop.Load()
+		s, ok := val.(bool)
+		if !ok || s {
+			break
+		}
+
+		if !n.IsInSync() || time.Since(last) < outboxInterval || n.wallet.OutboxPending() == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+		last = time.Now()
+
+		if err := n.retryOutbox(ctx); err != nil {
+			logger.Warn(ctx, "Failed to retry outbox : %s", err)
+		}
+	}
+}
+
+// retryOutbox broadcasts the txs in the outbox that aren't safe again. The lock is held so a
+//   command doesn't spend the UTXOs released by an abandoned tx at the same time. Messages in
+//   abandoned txs are marked failed so they can be sent again.
+func (n *Node) retryOutbox(ctx context.Context) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	cancelled, err := n.wallet.RetryOutbox(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	n.processLock.Lock()
+	defer n.processLock.Unlock()
+
+	for _, txid := range cancelled {
+		if _, err := n.rs.CancelTx(ctx, txid); err != nil {
+			logger.Error(ctx, "Failed to cancel relationship tx %s : %s", txid.String(), err)
+		}
+	}
+
+	return n.Save(ctx)
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
@@ -11,16 +13,18 @@ import (
 
 // EnvironmentConfig is used to hold all runtime configuration.
 type EnvironmentConfig struct {
-	Key         string `envconfig:"XKEY" json:"XKEY"`
-	Entity      string `envconfig:"ENTITY" json:"ENTITY"`
-	CommandPath string `default:"./tmp/command" envconfig:"COMMAND_PATH" json:"COMMAND_PATH"`
-	Bitcoin     struct {
-		Network    string  `default:"mainnet" envconfig:"BITCOIN_CHAIN" json:"BITCOIN_CHAIN"`
-		IsTest     bool    `default:"true" envconfig:"IS_TEST" json:"IS_TEST"`
-		DustLimit  uint64  `default:"576" envconfig:"DUST_LIMIT" json:"DUST_LIMIT"` // 576 for P2PK
-		FeeRate    float32 `default:"1.0" envconfig:"FEE_RATE" json:"FEE_RATE"`
-		AddressGap int     `default:"5" envconfig:"ADDRESS_GAP" json:"ADDRESS_GAP"`
-		WalletPath string  `default:"m/7400'/0'/0'/0" envconfig:"WALLET_PATH" json:"WALLET_PATH"`
+	Key            string `envconfig:"XKEY" json:"XKEY"`
+	Entity         string `envconfig:"ENTITY" json:"ENTITY"`
+	CommandPath    string `default:"./tmp/command" envconfig:"COMMAND_PATH" json:"COMMAND_PATH"`
+	AttachmentPath string `default:"./tmp/attachments" envconfig:"ATTACHMENT_PATH" json:"ATTACHMENT_PATH"`
+	Bitcoin        struct {
+		Network             string  `default:"mainnet" envconfig:"BITCOIN_CHAIN" json:"BITCOIN_CHAIN"`
+		IsTest              bool    `default:"true" envconfig:"IS_TEST" json:"IS_TEST"`
+		DustLimit           uint64  `default:"576" envconfig:"DUST_LIMIT" json:"DUST_LIMIT"` // 576 for P2PK
+		FeeRate             float32 `default:"1.0" envconfig:"FEE_RATE" json:"FEE_RATE"`
+		AddressGap          int     `default:"5" envconfig:"ADDRESS_GAP" json:"ADDRESS_GAP"`
+		WalletPath          string  `default:"m/7400'/0'/0'/0" envconfig:"WALLET_PATH" json:"WALLET_PATH"`
+		RelationshipFunding string  `default:"relationship" envconfig:"RELATIONSHIP_FUNDING" json:"RELATIONSHIP_FUNDING"`
 	}
 	SpyNode struct {
 		Address        string `default:"127.0.0.1:8333" envconfig:"NODE_ADDRESS"`
@@ -57,6 +61,23 @@ type EnvironmentConfig struct {
 	Identity struct {
 		URL string `envconfig:"IDENTITY_URL" json:"IDENTITY_URL"`
 	}
+	Receipts struct {
+		Send bool `default:"false" envconfig:"SEND_RECEIPTS" json:"SEND_RECEIPTS"`
+	}
+	Prefund struct {
+		Count     int    `default:"0" envconfig:"PREFUND_COUNT" json:"PREFUND_COUNT"`
+		Threshold int    `default:"2" envconfig:"PREFUND_THRESHOLD" json:"PREFUND_THRESHOLD"`
+		Value     uint64 `default:"2000" envconfig:"PREFUND_VALUE" json:"PREFUND_VALUE"`
+	}
+	Recovery struct {
+		Enabled bool `default:"false" envconfig:"RECOVER" json:"RECOVER"`
+	}
+	Policy struct {
+		AutoAccept      bool     `default:"false" envconfig:"POLICY_AUTO_ACCEPT" json:"POLICY_AUTO_ACCEPT"`
+		Allow           []string `envconfig:"POLICY_ALLOW" json:"POLICY_ALLOW"`
+		AllowIdentities []string `envconfig:"POLICY_ALLOW_IDENTITIES" json:"POLICY_ALLOW_IDENTITIES"`
+		Block           []string `envconfig:"POLICY_BLOCK" json:"POLICY_BLOCK"`
+	}
 }
 
 // SafeConfig masks sensitive config values
@@ -90,6 +111,20 @@ func Environment() (*EnvironmentConfig, error) {
 	return &cfg, nil
 }
 
+// Relationship funding policies specify when UTXOs on relationship derived keys that are no longer
+//   used by the relationship can fund other txs. Spending them with other UTXOs links the keys on
+//   chain.
+const (
+	// RelationshipFundingNone only spends them with the sweep command.
+	RelationshipFundingNone = uint8(0)
+
+	// RelationshipFundingSame only spends them in txs for the same relationship.
+	RelationshipFundingSame = uint8(1)
+
+	// RelationshipFundingAny spends them in any tx.
+	RelationshipFundingAny = uint8(2)
+)
+
 // Config is used to reference configuration values during operation.
 type Config struct {
 	Entity actions.EntityField
@@ -101,7 +136,39 @@ type Config struct {
 	AddressGap int
 	WalletPath string
 
+	// RelationshipFunding is the policy for using UTXOs on relationship derived keys as funding.
+	RelationshipFunding uint8
+
 	CommandPath string
+
+	// AttachmentPath is the local directory that received attachments are saved in.
+	AttachmentPath string
+
+	// IdentityURL is the URL of the identity oracle used to verify proofs of identity.
+	IdentityURL string
+
+	// SendReceipts sends a delivered receipt for each message received in relationships that use
+	//   indirect encryption.
+	SendReceipts bool
+
+	// PrefundCount is the number of our next keys in each relationship that are funded in advance
+	//   so messages don't need a separate funding tx. They are refilled when fewer than
+	//   PrefundThreshold are funded. Each key is funded with PrefundValue satoshis. Zero disables
+	//   pre-funding.
+	PrefundCount     int
+	PrefundThreshold int
+	PrefundValue     uint64
+
+	// Recover rebuilds the wallet and relationships from the chain, starting at RecoverHash,
+	//   instead of loading them from storage. RecoverHash is the START_HASH the spynode starts at.
+	Recover     bool
+	RecoverHash bitcoin.Hash32
+
+	// Initial policy for relationships initiated with us. Keys are the base keys of initiators.
+	PolicyAutoAccept      bool
+	PolicyAllow           []bitcoin.PublicKey
+	PolicyAllowIdentities []string
+	PolicyBlock           []bitcoin.PublicKey
 }
 
 func (c EnvironmentConfig) Config() (*Config, error) {
@@ -112,6 +179,19 @@ func (c EnvironmentConfig) Config() (*Config, error) {
 		AddressGap:  c.Bitcoin.AddressGap,
 		WalletPath:  c.Bitcoin.WalletPath,
 		CommandPath: c.CommandPath,
+		IdentityURL: c.Identity.URL,
+
+		AttachmentPath: c.AttachmentPath,
+		SendReceipts:   c.Receipts.Send,
+
+		PrefundCount:     c.Prefund.Count,
+		PrefundThreshold: c.Prefund.Threshold,
+		PrefundValue:     c.Prefund.Value,
+
+		Recover: c.Recovery.Enabled,
+
+		PolicyAutoAccept:      c.Policy.AutoAccept,
+		PolicyAllowIdentities: c.Policy.AllowIdentities,
 	}
 
 	if len(c.Entity) > 0 {
@@ -121,10 +201,61 @@ func (c EnvironmentConfig) Config() (*Config, error) {
 		}
 	}
 
+	if result.Recover {
+		if len(c.SpyNode.StartHash) == 0 {
+			return nil, errors.New("START_HASH required to recover")
+		}
+
+		hash, err := bitcoin.NewHash32FromStr(c.SpyNode.StartHash)
+		if err != nil {
+			return nil, errors.Wrap(err, "start hash")
+		}
+		result.RecoverHash = *hash
+	}
+
+	switch strings.ToLower(c.Bitcoin.RelationshipFunding) {
+	case "none":
+		result.RelationshipFunding = RelationshipFundingNone
+	case "relationship":
+		result.RelationshipFunding = RelationshipFundingSame
+	case "any":
+		result.RelationshipFunding = RelationshipFundingAny
+	default:
+		return nil, fmt.Errorf("Invalid relationship funding policy : %s",
+			c.Bitcoin.RelationshipFunding)
+	}
+
 	result.Net = bitcoin.NetworkFromString(c.Bitcoin.Network)
 	if result.Net == bitcoin.InvalidNet {
 		return nil, errors.New("Invalid bitcoin network")
 	}
 
+	for _, address := range c.Policy.Allow {
+		publicKey, err := PublicKeyFromAddress(address)
+		if err != nil {
+			return nil, errors.Wrap(err, "policy allow")
+		}
+		result.PolicyAllow = append(result.PolicyAllow, publicKey)
+	}
+
+	for _, address := range c.Policy.Block {
+		publicKey, err := PublicKeyFromAddress(address)
+		if err != nil {
+			return nil, errors.Wrap(err, "policy block")
+		}
+		result.PolicyBlock = append(result.PolicyBlock, publicKey)
+	}
+
 	return result, nil
 }
+
+// PublicKeyFromAddress returns the public key of a P2PK address, like the relationship addresses
+//   from the receive command.
+func PublicKeyFromAddress(address string) (bitcoin.PublicKey, error) {
+	ad, err := bitcoin.DecodeAddress(address)
+	if err != nil {
+		return bitcoin.PublicKey{}, errors.Wrap(err, "decode address")
+	}
+
+	return bitcoin.NewRawAddressFromAddress(ad).GetPublicKey()
+}
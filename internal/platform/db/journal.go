@@ -0,0 +1,201 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	journalKey = "journal"
+)
+
+var (
+	// ErrInvalidJournal is returned when the journal was not completely written.
+	ErrInvalidJournal = errors.New("Invalid journal")
+)
+
+// Writer is implemented by DB and Journal so state can be saved directly or through a journal.
+type Writer interface {
+	Put(ctx context.Context, key string, body []byte) error
+}
+
+// Journal collects writes so they can be committed to the DB together. The writes are first saved
+// as one journal entry, so if the process stops before they are all applied they can be
+// reapplied by RecoverJournal and the DB is never left with only some of them.
+type Journal struct {
+	entries []journalEntry
+}
+
+type journalEntry struct {
+	key  string
+	body []byte
+}
+
+// NewJournal returns an empty journal.
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// Put adds a write to the journal. It isn't written to the DB until the journal is committed.
+func (j *Journal) Put(ctx context.Context, key string, body []byte) error {
+	j.entries = append(j.entries, journalEntry{key: key, body: body})
+	return nil
+}
+
+// Commit writes the journal, then applies its writes to the DB, then removes the journal.
+func (db *DB) Commit(ctx context.Context, j *Journal) error {
+	b, err := j.serialize()
+	if err != nil {
+		return errors.Wrap(err, "serialize journal")
+	}
+
+	if err := db.Put(ctx, journalKey, b); err != nil {
+		return errors.Wrap(err, "put journal")
+	}
+
+	if err := db.apply(ctx, j); err != nil {
+		return errors.Wrap(err, "apply journal")
+	}
+
+	return nil
+}
+
+// RecoverJournal reapplies the writes from a journal that was committed, but not completely
+// applied. A journal that was not completely written is discarded because none of its writes were
+// applied. Returns true if a journal was reapplied.
+func (db *DB) RecoverJournal(ctx context.Context) (bool, error) {
+	b, err := db.Fetch(ctx, journalKey)
+	if err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "fetch journal")
+	}
+
+	j, err := deserializeJournal(b)
+	if err != nil {
+		if errors.Cause(err) == ErrInvalidJournal {
+			if err := db.Remove(ctx, journalKey); err != nil {
+				return false, errors.Wrap(err, "remove journal")
+			}
+			return false, nil
+		}
+		return false, errors.Wrap(err, "deserialize journal")
+	}
+
+	if err := db.apply(ctx, j); err != nil {
+		return false, errors.Wrap(err, "apply journal")
+	}
+
+	return true, nil
+}
+
+// apply writes each of the journal's writes to the DB and then removes the journal.
+func (db *DB) apply(ctx context.Context, j *Journal) error {
+	for _, entry := range j.entries {
+		if err := db.Put(ctx, entry.key, entry.body); err != nil {
+			return errors.Wrapf(err, "put %s", entry.key)
+		}
+	}
+
+	if err := db.Remove(ctx, journalKey); err != nil {
+		return errors.Wrap(err, "remove journal")
+	}
+
+	return nil
+}
+
+// serialize returns the journal's writes followed by a hash of them, so a journal that wasn't
+// completely written can be detected.
+func (j *Journal) serialize() ([]byte, error) {
+	var buf bytes.Buffer
+
+	// Version
+	if err := binary.Write(&buf, binary.LittleEndian, uint8(0)); err != nil {
+		return nil, errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(j.entries))); err != nil {
+		return nil, errors.Wrap(err, "entries size")
+	}
+
+	for _, entry := range j.entries {
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(entry.key))); err != nil {
+			return nil, errors.Wrap(err, "key size")
+		}
+		if _, err := buf.Write([]byte(entry.key)); err != nil {
+			return nil, errors.Wrap(err, "key")
+		}
+
+		if err := binary.Write(&buf, binary.LittleEndian, uint32(len(entry.body))); err != nil {
+			return nil, errors.Wrap(err, "body size")
+		}
+		if _, err := buf.Write(entry.body); err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+	}
+
+	hash := sha256.Sum256(buf.Bytes())
+	if _, err := buf.Write(hash[:]); err != nil {
+		return nil, errors.Wrap(err, "hash")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func deserializeJournal(b []byte) (*Journal, error) {
+	if len(b) < sha256.Size {
+		return nil, ErrInvalidJournal
+	}
+
+	data := b[:len(b)-sha256.Size]
+	hash := sha256.Sum256(data)
+	if !bytes.Equal(hash[:], b[len(b)-sha256.Size:]) {
+		return nil, ErrInvalidJournal
+	}
+
+	buf := bytes.NewReader(data)
+
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return nil, errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return nil, fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return nil, errors.Wrap(err, "entries size")
+	}
+
+	result := &Journal{}
+	for i := uint32(0); i < count; i++ {
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return nil, errors.Wrap(err, "key size")
+		}
+		key := make([]byte, size)
+		if _, err := buf.Read(key); err != nil {
+			return nil, errors.Wrap(err, "key")
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return nil, errors.Wrap(err, "body size")
+		}
+		body := make([]byte, size)
+		if _, err := buf.Read(body); err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+
+		result.entries = append(result.entries, journalEntry{key: string(key), body: body})
+	}
+
+	return result, nil
+}
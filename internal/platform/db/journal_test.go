@@ -0,0 +1,150 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJournalCommit(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	j := NewJournal()
+	j.Put(ctx, "wallet", []byte("wallet state"))
+	j.Put(ctx, "relationships", []byte("relationships state"))
+
+	if err := db.Commit(ctx, j); err != nil {
+		t.Fatalf("Failed to commit journal : %s", err)
+	}
+
+	checkEntry(t, ctx, db, "wallet", []byte("wallet state"))
+	checkEntry(t, ctx, db, "relationships", []byte("relationships state"))
+
+	if _, err := db.Fetch(ctx, journalKey); err != ErrNotFound {
+		t.Fatalf("Journal not removed : %v", err)
+	}
+
+	recovered, err := db.RecoverJournal(ctx)
+	if err != nil {
+		t.Fatalf("Failed to recover journal : %s", err)
+	}
+
+	if recovered {
+		t.Fatalf("Committed journal should not be recovered")
+	}
+}
+
+func TestJournalInterruptedApply(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	if err := db.Put(ctx, "wallet", []byte("old wallet state")); err != nil {
+		t.Fatalf("Failed to put wallet : %s", err)
+	}
+	if err := db.Put(ctx, "relationships", []byte("old relationships state")); err != nil {
+		t.Fatalf("Failed to put relationships : %s", err)
+	}
+
+	j := NewJournal()
+	j.Put(ctx, "wallet", []byte("wallet state"))
+	j.Put(ctx, "relationships", []byte("relationships state"))
+
+	// Write the journal and only the first of its writes, like the process stopped during the
+	//   commit.
+	b, err := j.serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize journal : %s", err)
+	}
+
+	if err := db.Put(ctx, journalKey, b); err != nil {
+		t.Fatalf("Failed to put journal : %s", err)
+	}
+	if err := db.Put(ctx, "wallet", []byte("wallet state")); err != nil {
+		t.Fatalf("Failed to put wallet : %s", err)
+	}
+
+	recovered, err := db.RecoverJournal(ctx)
+	if err != nil {
+		t.Fatalf("Failed to recover journal : %s", err)
+	}
+
+	if !recovered {
+		t.Fatalf("Journal not recovered")
+	}
+
+	checkEntry(t, ctx, db, "wallet", []byte("wallet state"))
+	checkEntry(t, ctx, db, "relationships", []byte("relationships state"))
+
+	if _, err := db.Fetch(ctx, journalKey); err != ErrNotFound {
+		t.Fatalf("Journal not removed : %v", err)
+	}
+}
+
+func TestJournalInterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	if err := db.Put(ctx, "wallet", []byte("old wallet state")); err != nil {
+		t.Fatalf("Failed to put wallet : %s", err)
+	}
+
+	j := NewJournal()
+	j.Put(ctx, "wallet", []byte("wallet state"))
+
+	b, err := j.serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize journal : %s", err)
+	}
+
+	// Only part of the journal was written, so none of its writes were applied.
+	if err := db.Put(ctx, journalKey, b[:len(b)-10]); err != nil {
+		t.Fatalf("Failed to put journal : %s", err)
+	}
+
+	recovered, err := db.RecoverJournal(ctx)
+	if err != nil {
+		t.Fatalf("Failed to recover journal : %s", err)
+	}
+
+	if recovered {
+		t.Fatalf("Incomplete journal should not be recovered")
+	}
+
+	checkEntry(t, ctx, db, "wallet", []byte("old wallet state"))
+
+	if _, err := db.Fetch(ctx, journalKey); err != ErrNotFound {
+		t.Fatalf("Incomplete journal not removed : %v", err)
+	}
+}
+
+func newTestDB(t *testing.T) *DB {
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Failed to create storage directory : %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	db, err := New(&StorageConfig{
+		Bucket: "standalone",
+		Root:   root,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create db : %s", err)
+	}
+
+	return db
+}
+
+func checkEntry(t *testing.T, ctx context.Context, db *DB, key string, want []byte) {
+	got, err := db.Fetch(ctx, key)
+	if err != nil {
+		t.Fatalf("Failed to fetch %s : %s", key, err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Wrong %s : got \"%s\", want \"%s\"", key, got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+
+	"github.com/pkg/errors"
+)
+
+// MockOracleBlockHeight is the block height included in the mock oracle's signatures.
+const MockOracleBlockHeight = uint32(100)
+
+// MockOracle is a local HTTP stand-in for an identity oracle. It serves the oracle's public key so
+//   that signatures made with Key can be verified. Other endpoints, like the sign requests, can be
+//   added with HandleFunc.
+type MockOracle struct {
+	Key bitcoin.Key
+
+	mux    *http.ServeMux
+	server *httptest.Server
+}
+
+func NewMockOracle() (*MockOracle, error) {
+	key, err := bitcoin.GenerateKey(bitcoin.MainNet)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate key")
+	}
+
+	result := &MockOracle{
+		Key: key,
+	}
+
+	result.mux = http.NewServeMux()
+	result.mux.HandleFunc("/oracle/id", result.handleId)
+	result.server = httptest.NewServer(result.mux)
+
+	return result, nil
+}
+
+// URL returns the URL of the oracle to put in the IdentityURL config value.
+func (o *MockOracle) URL() string {
+	return o.server.URL
+}
+
+// Sign returns the oracle's signature of the hash.
+func (o *MockOracle) Sign(hash []byte) ([]byte, error) {
+	sig, err := o.Key.Sign(hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign")
+	}
+
+	return sig.Bytes(), nil
+}
+
+// HandleFunc adds a handler for an oracle endpoint.
+func (o *MockOracle) HandleFunc(path string, handler func(http.ResponseWriter, *http.Request)) {
+	o.mux.HandleFunc(path, handler)
+}
+
+// WriteSignature responds with the oracle's signature of the hash at MockOracleBlockHeight.
+func (o *MockOracle) WriteSignature(w http.ResponseWriter, sigHash []byte) {
+	signature, err := o.Sign(sigHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		BlockHeight uint32 `json:"block_height"`
+		Signature   []byte `json:"signature"`
+	}{
+		BlockHeight: MockOracleBlockHeight,
+		Signature:   signature,
+	})
+}
+
+func (o *MockOracle) Close() {
+	o.server.Close()
+}
+
+func (o *MockOracle) handleId(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, struct {
+		PublicKey string `json:"public_key"`
+	}{
+		PublicKey: o.Key.PublicKey().String(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,596 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/inspector"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+// AmendRelationship creates and broadcasts a RelationshipAmendment message that adds and/or drops
+//   members of the relationship specified.
+// When members are dropped the amendment contains a new seed that resets the hash chain of every
+//   member so dropped members can't follow future keys. Otherwise the seed doesn't change and new
+//   members join at the current position of the hash chains.
+// The amendment is directly encrypted to the base keys of all members after the amendment,
+//   including our own, so new members can determine the full membership from the tx.
+// dropMembers are indexes into r.Members.
+func (rs *Relationships) AmendRelationship(ctx context.Context, r *Relationship,
+	addMembers []bitcoin.PublicKey, dropMembers []uint32) (*messages.RelationshipAmendment, error) {
+
+	logger.Info(ctx, "Creating amendment for relationship : %s", r.TxId.String())
+
+	if r.Closed {
+		return nil, ErrClosed
+	}
+
+	if !r.Accepted {
+		return nil, errors.New("Relationship not accepted")
+	}
+
+	if len(addMembers) == 0 && len(dropMembers) == 0 {
+		return nil, errors.New("No members to add or drop")
+	}
+
+	for _, index := range dropMembers {
+		if int(index) >= len(r.Members) {
+			return nil, fmt.Errorf("Member index out of range : %d/%d", index, len(r.Members))
+		}
+	}
+
+	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "get key")
+	}
+
+	// Put our base key first so it is always a receiver.
+	baseKeys := []bitcoin.PublicKey{baseKey.PublicKey()}
+	for i, m := range r.Members {
+		if containsIndex(dropMembers, uint32(i)) {
+			continue
+		}
+		baseKeys = append(baseKeys, m.BaseKey)
+	}
+	for _, publicKey := range addMembers {
+		for _, existing := range baseKeys {
+			if existing.Equal(publicKey) {
+				return nil, errors.New("Member already in relationship")
+			}
+		}
+		baseKeys = append(baseKeys, publicKey)
+	}
+
+	if len(baseKeys) < 2 {
+		return nil, errors.New("Relationship must have at least one other member")
+	}
+
+	amendment := &messages.RelationshipAmendment{
+		Seed:              r.Seed,
+		AddMemberIndexes:  len(addMembers) > 0,
+		DropMemberIndexes: len(dropMembers) > 0,
+	}
+
+	if r.EncryptionType != 0 {
+		amendment.BaseEncryptionSecret = r.EncryptionKey.Bytes()
+	}
+
+	if len(dropMembers) > 0 {
+		seedValue, err := bitcoin.GenerateSeedValue()
+		if err != nil {
+			return nil, errors.Wrap(err, "seed value")
+		}
+		amendment.Seed = seedValue.Bytes()
+
+		if r.EncryptionType != 0 {
+			secretValue, err := bitcoin.GenerateSeedValue()
+			if err != nil {
+				return nil, errors.Wrap(err, "encryption secret")
+			}
+
+			amendment.BaseEncryptionSecret = secretValue.Bytes()
+		}
+	}
+
+	position, err := r.amendmentPosition(amendment, baseKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "position")
+	}
+
+	var amendmentBuf bytes.Buffer
+	if err := amendment.Serialize(&amendmentBuf); err != nil {
+		return nil, errors.Wrap(err, "serialize amendment")
+	}
+
+	var positionBuf bytes.Buffer
+	if err := position.Serialize(&positionBuf); err != nil {
+		return nil, errors.Wrap(err, "serialize position")
+	}
+
+	payload, err := appendField(amendmentBuf.Bytes(), amendmentPositionField, positionBuf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "append position")
+	}
+
+	if _, err := rs.sendMessageToReceivers(ctx, r, baseKeys, messages.CodeRelationshipAmendment,
+		payload); err != nil {
+		return nil, errors.Wrap(err, "send message")
+	}
+
+	members := make([]*Member, 0, len(baseKeys)-1)
+	for i, publicKey := range baseKeys[1:] {
+		members = append(members, r.amendedMember(publicKey, position.Members[i+1]))
+	}
+
+	if err := rs.applyAmendment(ctx, r, amendment, members); err != nil {
+		return nil, errors.Wrap(err, "apply amendment")
+	}
+
+	logger.Info(ctx, "Amended relationship : %s", r.TxId.String())
+
+	return amendment, nil
+}
+
+// ProcessRelationshipAmendment updates the members and hash chains of a relationship based on an
+//   amendment. If we are being added to the relationship, then a new relationship is created at
+//   the position in the amendment, identified by the txid of the original relationship.
+// Returns true if a new relationship was created.
+func (rs *Relationships) ProcessRelationshipAmendment(ctx context.Context,
+	itx *inspector.Transaction, message *actions.Message, amendment *messages.RelationshipAmendment,
+	flag []byte) (bool, error) {
+
+	logger.Info(ctx, "Processing amendment for relationship : %s", itx.Hash.String())
+
+	if len(message.SenderIndexes) > 1 {
+		return false, fmt.Errorf("More than one sender not supported : %d", len(message.SenderIndexes))
+	}
+
+	if len(message.SenderIndexes) == 0 { // No sender indexes means use the first input
+		message.SenderIndexes = append(message.SenderIndexes, 0)
+	}
+
+	if int(message.SenderIndexes[0]) >= len(itx.MsgTx.TxIn) {
+		return false, fmt.Errorf("Sender index out of range : %d/%d", message.SenderIndexes[0],
+			len(itx.MsgTx.TxIn))
+	}
+
+	pk, err := bitcoin.PublicKeyFromUnlockingScript(itx.MsgTx.TxIn[message.SenderIndexes[0]].SignatureScript)
+	if err != nil {
+		return false, errors.Wrap(err, "sender parse script")
+	}
+
+	senderKey, err := bitcoin.PublicKeyFromBytes(pk)
+	if err != nil {
+		return false, errors.Wrap(err, "sender public key")
+	}
+
+	declined, err := isDecline(message.MessagePayload)
+	if err != nil {
+		return false, errors.Wrap(err, "decline")
+	}
+
+	if declined {
+		r, areSender, memberIndexes, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
+		if err != nil {
+			if errors.Cause(err) == ErrClosed {
+				return false, nil
+			}
+			return false, errors.Wrap(err, "get relationship")
+		}
+		if r == nil {
+			return false, ErrNotFound
+		}
+
+		if err := rs.applyDecline(ctx, r, areSender, memberIndexes); err != nil {
+			return false, errors.Wrap(err, "apply decline")
+		}
+
+		return areSender && r.EncryptionType == 1, nil
+	}
+
+	// The receivers are the base keys of all members after the amendment.
+	baseKeys := make([]bitcoin.PublicKey, 0, len(message.ReceiverIndexes))
+	for _, receiverIndex := range message.ReceiverIndexes {
+		if int(receiverIndex) >= len(itx.Outputs) {
+			return false, fmt.Errorf("Receiver index out of range : %d/%d", receiverIndex,
+				len(itx.Outputs))
+		}
+
+		publicKey, err := itx.Outputs[receiverIndex].Address.GetPublicKey()
+		if err != nil {
+			return false, errors.Wrap(err, "get public key")
+		}
+
+		baseKeys = append(baseKeys, publicKey)
+	}
+
+	position, err := findAmendmentPosition(message.MessagePayload)
+	if err != nil {
+		return false, errors.Wrap(err, "find position")
+	}
+	if position == nil || len(position.Members) != len(baseKeys) {
+		return false, errors.New("Missing amendment position")
+	}
+
+	senderAddress, err := senderKey.RawAddress()
+	if err != nil {
+		return false, errors.Wrap(err, "sender address")
+	}
+
+	ad, err := rs.wallet.FindAddress(ctx, senderAddress)
+	if err != nil {
+		return false, errors.Wrap(err, "find sender address")
+	}
+
+	if ad == nil && !rs.isMemberKey(senderKey) {
+		// The sender isn't a member of any of our relationships, so we must be a new member.
+		return true, rs.addAmendedRelationship(ctx, amendment, position, flag, baseKeys, senderKey)
+	}
+
+	// Get relationship
+	r, areSender, _, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		if errors.Cause(err) == ErrClosed {
+			return false, nil // we already left
+		}
+		return false, errors.Wrap(err, "get relationship")
+	}
+	if r == nil {
+		return false, ErrNotFound
+	}
+
+	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return false, errors.Wrap(err, "get key")
+	}
+
+	isMember := false
+	members := make([]*Member, 0, len(baseKeys))
+	for i, publicKey := range baseKeys {
+		if publicKey.Equal(baseKey.PublicKey()) {
+			isMember = true
+			continue // us
+		}
+		members = append(members, r.amendedMember(publicKey, position.Members[i]))
+	}
+
+	if !isMember || len(members) == 0 {
+		// We were dropped, or the last other member left.
+		if err := rs.closeRelationship(ctx, r); err != nil {
+			return false, errors.Wrap(err, "close")
+		}
+		return false, nil
+	}
+
+	if err := rs.applyAmendment(ctx, r, amendment, members); err != nil {
+		return false, errors.Wrap(err, "apply amendment")
+	}
+
+	logger.Info(ctx, "Relationship amended to %d members : %s", len(members), r.TxId.String())
+
+	return areSender && r.EncryptionType == 1, nil
+}
+
+// addAmendedRelationship creates a new relationship from an amendment that added us as a member.
+//   Our hash chain and the hash chains of the other members start at their positions in the
+//   amendment, then the sender's key is used.
+func (rs *Relationships) addAmendedRelationship(ctx context.Context,
+	amendment *messages.RelationshipAmendment, position *amendmentPosition, flag []byte,
+	baseKeys []bitcoin.PublicKey, senderKey bitcoin.PublicKey) error {
+
+	// Check for pre-existing
+	if rs.FindRelationshipForTxId(ctx, position.TxId) != nil {
+		return nil // already exists
+	}
+
+	r := &Relationship{
+		TxId: position.TxId,
+		Seed: amendment.Seed,
+		Flag: flag,
+	}
+
+	keyFound := false
+	var members []*Member
+	for i, publicKey := range baseKeys {
+		if !keyFound {
+			ra, err := bitcoin.NewRawAddressPublicKey(publicKey)
+			if err != nil {
+				return errors.Wrap(err, "receiver address")
+			}
+
+			ad, err := rs.wallet.FindAddress(ctx, ra)
+			if err != nil {
+				return errors.Wrap(err, "receiver address")
+			}
+
+			if ad != nil && ad.KeyHash == nil {
+				logger.Info(ctx, "We are new member")
+				if ad.KeyType != wallet.KeyTypeRelateIn {
+					return fmt.Errorf("Wrong key type for relationship member : %s",
+						wallet.KeyTypeName[ad.KeyType])
+				}
+				r.KeyType = ad.KeyType
+				r.KeyIndex = ad.KeyIndex
+				r.NextHash = position.Members[i].Hash
+				r.NextIndex = position.Members[i].Index
+				r.NextKey, err = bitcoin.NextPublicKey(ad.PublicKey, r.NextHash)
+				if err != nil {
+					return errors.Wrap(err, "next key")
+				}
+				keyFound = true
+				continue
+			}
+		}
+
+		m := r.amendedMember(publicKey, position.Members[i])
+		m.UseKey(senderKey)
+		members = append(members, m)
+	}
+
+	if !keyFound {
+		return errors.New("Not a member of relationship")
+	}
+
+	if len(amendment.BaseEncryptionSecret) > 0 {
+		r.EncryptionType = 1
+		encryptionKey, err := bitcoin.NewHash32(amendment.BaseEncryptionSecret)
+		if err != nil {
+			return errors.Wrap(err, "encryption secret")
+		}
+		r.EncryptionKey = *encryptionKey
+	}
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
+	r.Members = members
+
+	rs.lock.Lock()
+	rs.Relationships = append(rs.Relationships, r)
+	rs.lock.Unlock()
+
+	logger.Info(ctx, "New relationship from amendment : %s", r.TxId.String())
+
+	return nil
+}
+
+// applyAmendment replaces the members of the relationship. When the amendment contains a new seed
+//   all hash chains are reset to it.
+func (rs *Relationships) applyAmendment(ctx context.Context, r *Relationship,
+	amendment *messages.RelationshipAmendment, members []*Member) error {
+
+	if bytes.Equal(r.Seed, amendment.Seed) {
+		r.Members = members
+		return nil
+	}
+
+	hash, err := bitcoin.NewHash32(bitcoin.Sha256(amendment.Seed))
+	if err != nil {
+		return errors.Wrap(err, "seed hash")
+	}
+
+	if len(amendment.BaseEncryptionSecret) > 0 {
+		encryptionKey, err := bitcoin.NewHash32(amendment.BaseEncryptionSecret)
+		if err != nil {
+			return errors.Wrap(err, "encryption secret")
+		}
+		r.EncryptionKey = *encryptionKey
+	}
+
+	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	r.Seed = amendment.Seed
+	r.NextHash = *hash
+	r.NextIndex = 1
+	r.NextKey, err = bitcoin.NextPublicKey(baseKey.PublicKey(), r.NextHash)
+	if err != nil {
+		return errors.Wrap(err, "next key")
+	}
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
+	for _, m := range members {
+		m.NextHash = *hash
+		m.NextIndex = 1
+		m.NextKey, err = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+		if err != nil {
+			return errors.Wrap(err, "member next key")
+		}
+	}
+
+	r.Members = members
+	return nil
+}
+
+// amendedMember returns the existing member with the base key, or a new member at the position if
+//   it isn't already a member.
+func (r *Relationship) amendedMember(publicKey bitcoin.PublicKey, position *memberPosition) *Member {
+	for _, m := range r.Members {
+		if m.BaseKey.Equal(publicKey) {
+			return m
+		}
+	}
+
+	m := &Member{
+		BaseKey:   publicKey,
+		NextHash:  position.Hash,
+		NextIndex: position.Index,
+	}
+	m.NextKey, _ = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+	return m
+}
+
+// amendmentPosition returns the position of each of the base keys in the hash chains after the
+//   amendment. Our base key is first. New members start at our position.
+func (r *Relationship) amendmentPosition(amendment *messages.RelationshipAmendment,
+	baseKeys []bitcoin.PublicKey) (*amendmentPosition, error) {
+
+	result := &amendmentPosition{TxId: r.TxId}
+
+	if !bytes.Equal(r.Seed, amendment.Seed) {
+		// All hash chains are reset to the new seed.
+		hash, err := bitcoin.NewHash32(bitcoin.Sha256(amendment.Seed))
+		if err != nil {
+			return nil, errors.Wrap(err, "seed hash")
+		}
+
+		for range baseKeys {
+			result.Members = append(result.Members, &memberPosition{Hash: *hash, Index: 1})
+		}
+		return result, nil
+	}
+
+	result.Members = append(result.Members, &memberPosition{Hash: r.NextHash, Index: r.NextIndex})
+	for _, publicKey := range baseKeys[1:] {
+		position := &memberPosition{Hash: r.NextHash, Index: r.NextIndex}
+		for _, m := range r.Members {
+			if m.BaseKey.Equal(publicKey) {
+				position = &memberPosition{Hash: m.NextHash, Index: m.NextIndex}
+				break
+			}
+		}
+		result.Members = append(result.Members, position)
+	}
+
+	return result, nil
+}
+
+// isMemberKey returns true if the public key is an expected key of a member of any relationship.
+func (rs *Relationships) isMemberKey(publicKey bitcoin.PublicKey) bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	for _, r := range rs.Relationships {
+		if r.Closed {
+			continue
+		}
+
+		for _, m := range r.Members {
+			if m.IsExpectedKey(publicKey) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// amendmentPosition is the position of the relationship's hash chains after an amendment, so that
+//   new members can continue the relationship instead of starting a new one.
+type amendmentPosition struct {
+	// Txid of the tx that initiated the relationship.
+	TxId bitcoin.Hash32
+
+	// Position of each member in the same order as the receivers of the amendment.
+	Members []*memberPosition
+}
+
+type memberPosition struct {
+	Hash  bitcoin.Hash32
+	Index uint64
+}
+
+func (p amendmentPosition) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := p.TxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(p.Members))); err != nil {
+		return errors.Wrap(err, "member count")
+	}
+
+	for _, m := range p.Members {
+		if err := m.Hash.Serialize(buf); err != nil {
+			return errors.Wrap(err, "hash")
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, m.Index); err != nil {
+			return errors.Wrap(err, "index")
+		}
+	}
+
+	return nil
+}
+
+func (p *amendmentPosition) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := p.TxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "member count")
+	}
+
+	p.Members = make([]*memberPosition, count)
+	for i := range p.Members {
+		m := &memberPosition{}
+		if err := m.Hash.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "hash")
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &m.Index); err != nil {
+			return errors.Wrap(err, "index")
+		}
+
+		p.Members[i] = m
+	}
+
+	return nil
+}
+
+// findAmendmentPosition returns the position in the amendment payload, or nil if there isn't one.
+func findAmendmentPosition(payload []byte) (*amendmentPosition, error) {
+	b, err := findField(payload, amendmentPositionField)
+	if err != nil {
+		return nil, errors.Wrap(err, "find field")
+	}
+	if b == nil {
+		return nil, nil
+	}
+
+	position := &amendmentPosition{}
+	if err := position.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, errors.Wrap(err, "deserialize")
+	}
+	return position, nil
+}
+
+func containsIndex(indexes []uint32, index uint32) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestAmendAddMember(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	newWallet, newBroadcastTx, newRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	newAddress, err := newWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	logger.Info(ctx, "Send amendment *************************************************************")
+
+	sendR := sendRS.Relationships[0]
+	nextHash := sendR.NextHash
+	nextIndex := sendR.NextIndex
+
+	amendment, err := sendRS.AmendRelationship(ctx, sendRS.Relationships[0],
+		[]bitcoin.PublicKey{newAddress.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to amend relationship : %s", err)
+	}
+
+	if len(sendRS.Relationships[0].Members) != 2 {
+		t.Fatalf("Wrong send member count : got %d, want %d", len(sendRS.Relationships[0].Members), 2)
+	}
+
+	// Give the amendment to the new member before it is removed by the existing member's decrypt.
+	newBroadcastTx.Msgs = append(newBroadcastTx.Msgs, sendBroadcastTx.Msgs...)
+
+	logger.Info(ctx, "Process amendment as existing member ***************************************")
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	if message.MessageCode != messages.CodeRelationshipAmendment {
+		t.Fatalf("Wrong message code : got %d, want %d", message.MessageCode,
+			messages.CodeRelationshipAmendment)
+	}
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ra, ok := p.(*messages.RelationshipAmendment)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := receiveRS.ProcessRelationshipAmendment(ctx, itx, message, ra, flag); err != nil {
+		t.Fatalf("Failed to process amendment : %s", err)
+	}
+
+	r := receiveRS.Relationships[0]
+	if len(r.Members) != 2 {
+		t.Fatalf("Wrong receive member count : got %d, want %d", len(r.Members), 2)
+	}
+
+	if !bytes.Equal(r.Seed, amendment.Seed) {
+		t.Fatalf("Wrong receive seed : \n  got  %x\n  want %x", r.Seed, amendment.Seed)
+	}
+
+	if !r.Members[1].BaseKey.Equal(newAddress.PublicKey) {
+		t.Fatalf("Wrong new member key : got %s, want %s", r.Members[1].BaseKey.String(),
+			newAddress.PublicKey.String())
+	}
+
+	logger.Info(ctx, "Process amendment as new member ********************************************")
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, newRS, newBroadcastTx)
+
+	created, err := newRS.ProcessRelationshipAmendment(ctx, itx, message, ra, flag)
+	if err != nil {
+		t.Fatalf("Failed to process amendment : %s", err)
+	}
+
+	if !created {
+		t.Fatalf("Relationship not created for new member")
+	}
+
+	if len(newRS.Relationships) != 1 {
+		t.Fatalf("Wrong new relationship count : got %d, want %d", len(newRS.Relationships), 1)
+	}
+
+	r = newRS.Relationships[0]
+	if len(r.Members) != 2 {
+		t.Fatalf("Wrong new member count : got %d, want %d", len(r.Members), 2)
+	}
+
+	if !bytes.Equal(r.Seed, amendment.Seed) {
+		t.Fatalf("Wrong new member seed : \n  got  %x\n  want %x", r.Seed, amendment.Seed)
+	}
+
+	// The new member joins the existing relationship at the current position of the hash chains.
+	if !r.TxId.Equal(&sendR.TxId) {
+		t.Fatalf("Wrong new member relationship : got %s, want %s", r.TxId.String(),
+			sendR.TxId.String())
+	}
+
+	if r.NextIndex != nextIndex || !r.NextHash.Equal(&nextHash) {
+		t.Fatalf("Wrong new member position : got %d, want %d", r.NextIndex, nextIndex)
+	}
+
+	if r.Members[0].NextIndex != sendR.NextIndex || !r.Members[0].NextHash.Equal(&sendR.NextHash) {
+		t.Fatalf("Wrong sender position : got %d, want %d", r.Members[0].NextIndex,
+			sendR.NextIndex)
+	}
+}
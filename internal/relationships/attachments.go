@@ -0,0 +1,146 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+// SendAttachments creates and broadcasts a private message with attachments within the
+//   relationship specified, or within a thread of it when thread is not nil. When hashOnly is true
+//   the contents of the attachments are removed from the message. Only their hashes are committed in the tx so
+//   the files can be exchanged separately and verified against it.
+func (rs *Relationships) SendAttachments(ctx context.Context, r *Relationship,
+	thread *bitcoin.Hash32, message *messages.PrivateMessage, hashOnly bool) error {
+
+	hashes := attachmentHashes(message)
+
+	if hashOnly {
+		attachments := message.Attachments
+		message.Attachments = nil
+		for _, attachment := range attachments {
+			message.Attachments = append(message.Attachments, &messages.DocumentField{
+				Name: attachment.Name,
+				Type: attachment.Type,
+			})
+		}
+	}
+
+	messagePayload, err := message.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "Serialize message")
+	}
+
+	messagePayload, err = appendField(messagePayload, attachmentHashesField, hashes)
+	if err != nil {
+		return errors.Wrap(err, "append attachment hashes")
+	}
+
+	if thread != nil {
+		return rs.sendThreadPayload(ctx, r, *thread, message.Code(), messagePayload)
+	}
+
+	return rs.sendMessagePayload(ctx, r, message.Code(), messagePayload)
+}
+
+// appendAttachmentHashes appends the hashes of the attachments of a private message to its
+//   payload so the receivers can verify them. Other messages are returned unchanged.
+func appendAttachmentHashes(message messages.Message, payload []byte) ([]byte, error) {
+	privateMessage, ok := message.(*messages.PrivateMessage)
+	if !ok || len(privateMessage.Attachments) == 0 {
+		return payload, nil
+	}
+
+	return appendField(payload, attachmentHashesField, attachmentHashes(privateMessage))
+}
+
+// attachmentHashes returns the SHA256 hashes of the contents of the attachments concatenated.
+func attachmentHashes(privateMessage *messages.PrivateMessage) []byte {
+	var hashes []byte
+	for _, attachment := range privateMessage.Attachments {
+		hash := sha256.Sum256(attachment.Contents)
+		hashes = append(hashes, hash[:]...)
+	}
+	return hashes
+}
+
+// saveAttachments verifies the attachments of a received private message against the hashes in
+//   its payload and writes them to the attachment path. File names are prefixed with the txid and
+//   the attachment's index so they are unique. Attachments that don't match their hash are not
+//   saved. For attachments sent by hash only, the committed hash is written to a ".sha256" file, in
+//   the format of sha256sum, so the file can be verified when it is received separately.
+func (rs *Relationships) saveAttachments(ctx context.Context, txid bitcoin.Hash32,
+	privateMessage *messages.PrivateMessage, payload []byte) error {
+
+	if len(privateMessage.Attachments) == 0 || len(rs.cfg.AttachmentPath) == 0 {
+		return nil
+	}
+
+	hashes, err := findField(payload, attachmentHashesField)
+	if err != nil {
+		return errors.Wrap(err, "find hashes")
+	}
+
+	if len(hashes) != len(privateMessage.Attachments)*sha256.Size {
+		return fmt.Errorf("Wrong attachment hashes size : got %d, want %d", len(hashes),
+			len(privateMessage.Attachments)*sha256.Size)
+	}
+
+	if err := os.MkdirAll(rs.cfg.AttachmentPath, 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+
+	for i, attachment := range privateMessage.Attachments {
+		name := attachmentFileName(attachment, i)
+		path := filepath.Join(rs.cfg.AttachmentPath, fmt.Sprintf("%s_%d_%s", txid.String(), i,
+			name))
+		committed := hashes[i*sha256.Size : (i+1)*sha256.Size]
+
+		if len(attachment.Contents) == 0 {
+			if err := ioutil.WriteFile(path+".sha256",
+				[]byte(fmt.Sprintf("%x  %s\n", committed, name)), 0644); err != nil {
+				return errors.Wrap(err, "write attachment hash")
+			}
+
+			logger.Info(ctx, "Saved hash of attachment %s (%s) : %x", name, attachment.Type,
+				committed)
+			continue
+		}
+
+		hash := sha256.Sum256(attachment.Contents)
+		if !bytes.Equal(hash[:], committed) {
+			logger.Warn(ctx, "Attachment hash doesn't match : %s", name)
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, attachment.Contents, 0644); err != nil {
+			return errors.Wrap(err, "write attachment")
+		}
+
+		logger.Info(ctx, "Saved attachment %s (%s, %d bytes) : %s", name, attachment.Type,
+			len(attachment.Contents), path)
+	}
+
+	return nil
+}
+
+// attachmentFileName returns the name to save an attachment with. Only the base of the name is
+//   used so attachments can't be written outside of the directory.
+func attachmentFileName(attachment *messages.DocumentField, index int) string {
+	name := filepath.Base(attachment.Name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Sprintf("attachment_%d", index)
+	}
+	return name
+}
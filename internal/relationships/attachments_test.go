@@ -0,0 +1,153 @@
+package relationships
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestAttachments(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	path, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("Failed to create attachment directory : %s", err)
+	}
+	defer os.RemoveAll(path)
+
+	cfg.AttachmentPath = path
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	logger.Info(ctx, "Sending attachments ********************************************************")
+
+	contents := []byte("%PDF-1.4 sample signed document")
+
+	sendPrivateMessage := &messages.PrivateMessage{
+		Attachments: []*messages.DocumentField{
+			{
+				Name:     "../contract.pdf",
+				Type:     "application/pdf",
+				Contents: contents,
+			},
+		},
+	}
+
+	if err := sendRS.SendMessage(ctx, sendRS.Relationships[0], sendPrivateMessage); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if len(privateMessage.Attachments) != 1 {
+		t.Fatalf("Wrong attachment count : got %d, want %d", len(privateMessage.Attachments), 1)
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message : %s", err)
+	}
+
+	// Name is reduced to its base and prefixed with the txid and index.
+	filePath := filepath.Join(path, itx.Hash.String()+"_0_contract.pdf")
+	saved, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read saved attachment : %s", err)
+	}
+
+	if !bytes.Equal(saved, contents) {
+		t.Fatalf("Wrong attachment contents : \n  got  %x\n  want %x", saved, contents)
+	}
+
+	// Modified attachments are not saved.
+	if err := os.Remove(filePath); err != nil {
+		t.Fatalf("Failed to remove saved attachment : %s", err)
+	}
+
+	privateMessage.Attachments[0].Contents = []byte("%PDF-1.4 modified document")
+
+	if err := receiveRS.saveAttachments(ctx, *itx.Hash, privateMessage,
+		message.MessagePayload); err != nil {
+		t.Fatalf("Failed to save attachments : %s", err)
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("Modified attachment should not be saved")
+	}
+
+	logger.Info(ctx, "Sending attachment hashes ***************************************************")
+
+	hashOnlyMessage := &messages.PrivateMessage{
+		Attachments: []*messages.DocumentField{
+			{
+				Name:     "contract.pdf",
+				Type:     "application/pdf",
+				Contents: contents,
+			},
+		},
+	}
+
+	if err := sendRS.SendAttachments(ctx, sendRS.Relationships[0], nil, hashOnlyMessage,
+		true); err != nil {
+		t.Fatalf("Failed to send attachments : %s", err)
+	}
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok = p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if len(privateMessage.Attachments) != 1 || len(privateMessage.Attachments[0].Contents) != 0 {
+		t.Fatalf("Attachment contents should not be sent")
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message : %s", err)
+	}
+
+	hashPath := filepath.Join(path, itx.Hash.String()+"_0_contract.pdf.sha256")
+	savedHash, err := ioutil.ReadFile(hashPath)
+	if err != nil {
+		t.Fatalf("Failed to read saved attachment hash : %s", err)
+	}
+
+	hash := sha256.Sum256(contents)
+	wantHash := fmt.Sprintf("%x  contract.pdf\n", hash[:])
+	if string(savedHash) != wantHash {
+		t.Fatalf("Wrong attachment hash : \n  got  %s\n  want %s", savedHash, wantHash)
+	}
+}
@@ -0,0 +1,103 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+// CloseRelationship leaves the relationship specified. A RelationshipAmendment is sent to the other
+//   members that drops us, with a new seed so the remaining members can continue without us. When
+//   only one other member remains the relationship is closed for them too.
+// The relationship is kept, marked as closed, so its history can still be read, but its keys are no
+//   longer monitored.
+func (rs *Relationships) CloseRelationship(ctx context.Context, r *Relationship) error {
+	logger.Info(ctx, "Closing relationship : %s", r.TxId.String())
+
+	if r.Closed {
+		return ErrClosed
+	}
+
+	if !r.Accepted {
+		return errors.New("Relationship not accepted")
+	}
+
+	baseKeys := make([]bitcoin.PublicKey, 0, len(r.Members))
+	for _, m := range r.Members {
+		baseKeys = append(baseKeys, m.BaseKey)
+	}
+
+	if len(baseKeys) == 0 {
+		return rs.closeRelationship(ctx, r)
+	}
+
+	seedValue, err := bitcoin.GenerateSeedValue()
+	if err != nil {
+		return errors.Wrap(err, "seed value")
+	}
+
+	amendment := &messages.RelationshipAmendment{
+		Seed:              seedValue.Bytes(),
+		DropMemberIndexes: true,
+	}
+
+	if r.EncryptionType != 0 {
+		secretValue, err := bitcoin.GenerateSeedValue()
+		if err != nil {
+			return errors.Wrap(err, "encryption secret")
+		}
+
+		amendment.BaseEncryptionSecret = secretValue.Bytes()
+	}
+
+	position, err := r.amendmentPosition(amendment, baseKeys)
+	if err != nil {
+		return errors.Wrap(err, "position")
+	}
+
+	var amendmentBuf bytes.Buffer
+	if err := amendment.Serialize(&amendmentBuf); err != nil {
+		return errors.Wrap(err, "serialize amendment")
+	}
+
+	var positionBuf bytes.Buffer
+	if err := position.Serialize(&positionBuf); err != nil {
+		return errors.Wrap(err, "serialize position")
+	}
+
+	payload, err := appendField(amendmentBuf.Bytes(), amendmentPositionField, positionBuf.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "append position")
+	}
+
+	if _, err := rs.sendMessageToReceivers(ctx, r, baseKeys, messages.CodeRelationshipAmendment,
+		payload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	return rs.closeRelationship(ctx, r)
+}
+
+// closeRelationship marks the relationship and its members as closed and stops monitoring the
+//   relationship's keys.
+func (rs *Relationships) closeRelationship(ctx context.Context, r *Relationship) error {
+	if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "remove keys")
+	}
+
+	r.Closed = true
+	for _, m := range r.Members {
+		m.Closed = true
+		m.lookahead = nil
+		m.skipped = nil
+	}
+
+	logger.Info(ctx, "Closed relationship : %s", r.TxId.String())
+	return nil
+}
@@ -0,0 +1,213 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+func TestCloseRelationship(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	logger.Info(ctx, "Closing relationship *******************************************************")
+
+	sendR := sendRS.Relationships[0]
+
+	// The first key in the hash chain was already used by the initiation, so it is no longer in
+	//   the lookahead window, but it is still monitored until the relationship is closed.
+	baseKey, err := sendWallet.GetKey(ctx, sendR.KeyType, sendR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get base key : %s", err)
+	}
+
+	firstHash, _ := bitcoin.NewHash32(bitcoin.Sha256(sendR.Seed))
+	firstKey, err := bitcoin.NextPublicKey(baseKey.PublicKey(), *firstHash)
+	if err != nil {
+		t.Fatalf("Failed to get first key : %s", err)
+	}
+
+	firstAddress, err := firstKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get first address : %s", err)
+	}
+
+	firstHashes, err := firstAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get first address hashes : %s", err)
+	}
+
+	if monitored, _ := sendWallet.AreHashesMonitored(firstHashes); !monitored {
+		t.Fatalf("First key of relationship not monitored")
+	}
+
+	if err := sendRS.CloseRelationship(ctx, sendR); err != nil {
+		t.Fatalf("Failed to close relationship : %s", err)
+	}
+
+	if !sendR.Closed {
+		t.Fatalf("Send relationship not closed")
+	}
+
+	nextAddress, err := sendR.NextKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get next address : %s", err)
+	}
+
+	hashes, err := nextAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get next address hashes : %s", err)
+	}
+
+	if monitored, _ := sendWallet.AreHashesMonitored(hashes); monitored {
+		t.Fatalf("Next key of closed relationship still monitored")
+	}
+
+	if monitored, _ := sendWallet.AreHashesMonitored(firstHashes); monitored {
+		t.Fatalf("First key of closed relationship still monitored")
+	}
+
+	logger.Info(ctx, "Process close ***************************************************************")
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	if message.MessageCode != messages.CodeRelationshipAmendment {
+		t.Fatalf("Wrong message code : got %d, want %d", message.MessageCode,
+			messages.CodeRelationshipAmendment)
+	}
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ra, ok := p.(*messages.RelationshipAmendment)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := receiveRS.ProcessRelationshipAmendment(ctx, itx, message, ra, flag); err != nil {
+		t.Fatalf("Failed to process amendment : %s", err)
+	}
+
+	receiveR := receiveRS.Relationships[0]
+	if !receiveR.Closed {
+		t.Fatalf("Receive relationship not closed")
+	}
+
+	if len(receiveR.Members) != 1 || !receiveR.Members[0].Closed {
+		t.Fatalf("Receive relationship member not closed")
+	}
+
+	err = receiveRS.SendMessage(ctx, receiveR, &messages.PrivateMessage{
+		Subject: "Sample message after close",
+	})
+	if errors.Cause(err) != ErrClosed {
+		t.Fatalf("Wrong send message error : got %v, want %v", err, ErrClosed)
+	}
+
+	// Closed relationships are still saved, with their history.
+	var buf bytes.Buffer
+	if err := receiveR.Serialize(&buf); err != nil {
+		t.Fatalf("Failed to serialize relationship : %s", err)
+	}
+
+	var read Relationship
+	if err := read.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Failed to deserialize relationship : %s", err)
+	}
+
+	if !read.Closed || !read.Members[0].Closed {
+		t.Fatalf("Closed not deserialized")
+	}
+}
+
+func TestCloseRelationshipKeepsOtherKeys(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	logger.Info(ctx, "Initiate other relationship ************************************************")
+
+	receiveR := receiveRS.Relationships[0]
+
+	// Initiate another relationship to the same receive key.
+	baseKey, err := receiveWallet.GetKey(ctx, receiveR.KeyType, receiveR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get base key : %s", err)
+	}
+
+	_, otherBroadcastTx, otherRS := newTestRelationships(t, ctx, cfg)
+
+	if _, _, err := otherRS.InitiateRelationship(ctx, []bitcoin.PublicKey{baseKey.PublicKey()},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, otherBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if len(receiveRS.Relationships) != 2 {
+		t.Fatalf("Wrong receive relationship count : %d", len(receiveRS.Relationships))
+	}
+
+	otherR := receiveRS.Relationships[1]
+	if otherR.KeyType != receiveR.KeyType || otherR.KeyIndex != receiveR.KeyIndex {
+		t.Fatalf("Relationships don't share a base key")
+	}
+
+	logger.Info(ctx, "Closing relationship *******************************************************")
+
+	if err := receiveRS.CloseRelationship(ctx, receiveR); err != nil {
+		t.Fatalf("Failed to close relationship : %s", err)
+	}
+
+	nextAddress, err := otherR.NextKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get next address : %s", err)
+	}
+
+	hashes, err := nextAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get next address hashes : %s", err)
+	}
+
+	if monitored, _ := receiveWallet.AreHashesMonitored(hashes); !monitored {
+		t.Fatalf("Next key of other relationship not monitored")
+	}
+}
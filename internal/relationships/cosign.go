@@ -0,0 +1,559 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tokenized/envelope/pkg/golang/envelope"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+	"github.com/tokenized/smart-contract/pkg/txbuilder"
+	"github.com/tokenized/smart-contract/pkg/wire"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+	"github.com/tokenized/specification/dist/golang/messages"
+	"github.com/tokenized/specification/dist/golang/protocol"
+
+	"github.com/pkg/errors"
+)
+
+// CoSignedMessage is a relationship message tx with inputs from more than one member. It is passed
+//   between the members, who each sign their own inputs, and is broadcast with its funding tx when
+//   all inputs are signed.
+type CoSignedMessage struct {
+	Tx *wire.MsgTx
+
+	// The signed tx that funds the inputs of the message tx. It isn't broadcast until the message
+	//   tx is complete.
+	FundingTx *wire.MsgTx
+
+	// The outputs being spent by the inputs of the tx. Needed to sign the inputs.
+	Inputs []*CoSignedInput
+}
+
+type CoSignedInput struct {
+	LockingScript []byte
+	Value         uint64
+}
+
+// CreateCoSignedMessage creates a message within the relationship that is sent from our next key
+//   and the next keys of the members specified. Outputs are funded to each member's next key so
+//   they only need to sign. Our input is signed and the result needs to be passed to the other
+//   members to sign with SignCoSignedMessage.
+// Nothing is broadcast until all members have signed, so the hashes are moved past the keys used
+//   when the tx is seen, like for any other message.
+func (rs *Relationships) CreateCoSignedMessage(ctx context.Context, r *Relationship,
+	message messages.Message, memberIndexes []uint32) (*CoSignedMessage, error) {
+
+	logger.Info(ctx, "Creating co-signed message for relationship : %s", r.TxId.String())
+
+	if r.Closed {
+		return nil, ErrClosed
+	}
+
+	if !r.Accepted {
+		return nil, errors.New("Relationship not accepted")
+	}
+
+	if len(memberIndexes) == 0 {
+		return nil, errors.New("No co-signers")
+	}
+
+	coSigners := make([]*Member, 0, len(memberIndexes))
+	for i, index := range memberIndexes {
+		if int(index) >= len(r.Members) {
+			return nil, fmt.Errorf("Member index out of range : %d/%d", index, len(r.Members))
+		}
+		if containsIndex(memberIndexes[:i], index) {
+			return nil, fmt.Errorf("Duplicate member index : %d", index)
+		}
+		coSigners = append(coSigners, r.Members[index])
+	}
+
+	messagePayload, err := message.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "Serialize message")
+	}
+
+	messagePayload, err = appendAttachmentHashes(message, messagePayload)
+	if err != nil {
+		return nil, errors.Wrap(err, "append attachment hashes")
+	}
+
+	var receivers []bitcoin.PublicKey
+	if r.EncryptionType == 0 { // direct encryption
+		for _, m := range r.Members {
+			receivers = append(receivers, m.NextKey)
+		}
+	}
+
+	tx := txbuilder.NewTxBuilder(rs.cfg.DustLimit, rs.cfg.FeeRate)
+
+	if err := rs.addMessageOutputs(ctx, r, tx, receivers, len(coSigners), message.Code(),
+		messagePayload); err != nil {
+		return nil, errors.Wrap(err, "add message outputs")
+	}
+
+	// Fund our next key and the co-signers' next keys. There is no change output on the message
+	//   tx since it is signed by more than one party, so our funding output covers the fee for all
+	//   of the inputs.
+	fundTx := txbuilder.NewTxBuilder(rs.cfg.DustLimit, rs.cfg.FeeRate)
+
+	changeAddress, err := rs.wallet.GetUnusedAddress(ctx, wallet.KeyTypeInternal)
+	if err != nil {
+		return nil, errors.Wrap(err, "get change address")
+	}
+
+	if err := fundTx.SetChangeAddress(changeAddress.Address, ""); err != nil {
+		return nil, errors.Wrap(err, "set change address")
+	}
+
+	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "get key")
+	}
+
+	nextKey, err := bitcoin.NextKey(baseKey, r.NextHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "next key")
+	}
+
+	nextAddress, err := nextKey.RawAddress()
+	if err != nil {
+		return nil, errors.Wrap(err, "next address")
+	}
+
+	inputFee := uint64(float32(txbuilder.MaximumP2PKHInputSize) * rs.cfg.FeeRate)
+	fundingAmount := tx.EstimatedFee() + inputFee*uint64(len(coSigners)+1)
+	for _, output := range tx.MsgTx.TxOut {
+		fundingAmount += output.Value
+	}
+	if fundingAmount < rs.cfg.DustLimit {
+		fundingAmount = rs.cfg.DustLimit
+	}
+
+	if err := fundTx.AddPaymentOutput(nextAddress, fundingAmount, false); err != nil {
+		return nil, errors.Wrap(err, "add funding output")
+	}
+
+	for _, m := range coSigners {
+		ra, err := m.NextKey.RawAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, "co-signer address")
+		}
+
+		logger.Info(ctx, "Adding co-signer : %s",
+			bitcoin.NewAddressFromRawAddress(ra, rs.cfg.Net).String())
+
+		if err := fundTx.AddDustOutput(ra, false); err != nil {
+			return nil, errors.Wrap(err, "add co-signer output")
+		}
+	}
+
+	if err := rs.wallet.SignBitcoinFunding(ctx, fundTx); err != nil {
+		return nil, errors.Wrap(err, "fund co-signers")
+	}
+
+	result := &CoSignedMessage{
+		Tx:        tx.MsgTx,
+		FundingTx: fundTx.MsgTx,
+	}
+	fundTxId := *fundTx.MsgTx.TxHash()
+	for i := 0; i <= len(coSigners); i++ {
+		output := fundTx.MsgTx.TxOut[i]
+		if err := tx.AddInput(wire.OutPoint{Hash: fundTxId, Index: uint32(i)}, output.PkScript,
+			output.Value); err != nil {
+			return nil, errors.Wrap(err, "add input")
+		}
+
+		result.Inputs = append(result.Inputs, &CoSignedInput{
+			LockingScript: output.PkScript,
+			Value:         output.Value,
+		})
+	}
+
+	if err := result.signInput(0, nextKey); err != nil {
+		return nil, errors.Wrap(err, "sign")
+	}
+
+	logger.Info(ctx, "Created co-signed message : %s", result.Tx.TxHash().String())
+	return result, nil
+}
+
+// SignCoSignedMessage signs any inputs of the co-signed message that are from our keys in the
+//   relationship the message is in. The tx must contain a message that we can decrypt and our
+//   inputs must only spend outputs of the funding tx, so we don't sign anything else. When all
+//   inputs are signed the funding tx and the tx are broadcast.
+// Returns true if all inputs are signed.
+func (rs *Relationships) SignCoSignedMessage(ctx context.Context,
+	csm *CoSignedMessage) (bool, error) {
+
+	if len(csm.Inputs) != len(csm.Tx.TxIn) {
+		return false, fmt.Errorf("Wrong input count : %d/%d", len(csm.Inputs), len(csm.Tx.TxIn))
+	}
+
+	if csm.FundingTx == nil {
+		return false, errors.New("Missing funding tx")
+	}
+
+	r, message, err := rs.checkCoSignedMessage(ctx, csm)
+	if err != nil {
+		return false, errors.Wrap(err, "check message")
+	}
+
+	signed := 0
+	for index, input := range csm.Inputs {
+		if len(csm.Tx.TxIn[index].SignatureScript) > 0 {
+			continue // already signed
+		}
+
+		ra, err := bitcoin.RawAddressFromLockingScript(input.LockingScript)
+		if err != nil {
+			continue
+		}
+
+		ad, err := rs.wallet.FindAddress(ctx, ra)
+		if err != nil {
+			return false, errors.Wrap(err, "find address")
+		}
+
+		if ad == nil || ad.KeyHash == nil || ad.KeyType != r.KeyType || ad.KeyIndex != r.KeyIndex {
+			continue // not one of our keys in the relationship
+		}
+
+		if err := csm.checkFundingInput(index); err != nil {
+			return false, errors.Wrap(err, "check input")
+		}
+
+		key, err := rs.wallet.GetKey(ctx, ad.KeyType, ad.KeyIndex)
+		if err != nil {
+			return false, errors.Wrap(err, "get key")
+		}
+
+		key, err = bitcoin.NextKey(key, *ad.KeyHash)
+		if err != nil {
+			return false, errors.Wrap(err, "next key")
+		}
+
+		logger.Info(ctx, "Signing co-signed message input %d : %s", index,
+			bitcoin.NewAddressFromRawAddress(ra, rs.cfg.Net).String())
+
+		if err := csm.signInput(index, key); err != nil {
+			return false, errors.Wrap(err, "sign")
+		}
+		signed++
+	}
+
+	if csm.IsComplete() {
+		// The hash positions are recorded first since processing the broadcast tx moves them.
+		sent := r.coSignedSent(csm, message)
+
+		logger.Info(ctx, "Broadcasting co-signed message : %s", csm.Tx.TxHash().String())
+		if err := rs.wallet.BroadcastTxs(ctx, rs.broadcastTx, csm.FundingTx,
+			csm.Tx); err != nil {
+			return true, errors.Wrap(err, "broadcast")
+		}
+
+		rs.addSent(ctx, sent)
+		return true, nil
+	}
+
+	if signed == 0 {
+		return false, errors.New("No inputs to sign")
+	}
+
+	return false, nil
+}
+
+// checkCoSignedMessage returns the relationship of the message in the co-signed tx and the
+//   decrypted message. The relationship is found from the tx's flag, or from our input when it
+//   doesn't have one. The message must decrypt with the keys of the relationship.
+func (rs *Relationships) checkCoSignedMessage(ctx context.Context,
+	csm *CoSignedMessage) (*Relationship, *actions.Message, error) {
+
+	var flag []byte
+	for _, output := range csm.Tx.TxOut {
+		if f, err := protocol.DeserializeFlagOutputScript(output.PkScript); err == nil {
+			flag = f
+			break
+		}
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	// Relationships without a flag are found from the co-signers' next keys.
+	var r *Relationship
+	if len(flag) > 0 {
+		r = rs.findRelationshipForFlag(ctx, flag)
+	} else {
+		r = rs.findRelationshipForCoSigner(csm)
+	}
+	if r == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	if r.Closed {
+		return nil, nil, ErrClosed
+	}
+
+	for _, output := range csm.Tx.TxOut {
+		env, err := envelope.Deserialize(bytes.NewReader(output.PkScript))
+		if err != nil {
+			continue
+		}
+
+		if !bytes.Equal(env.PayloadProtocol(), protocol.GetProtocolID(rs.cfg.IsTest)) {
+			continue
+		}
+
+		var decrypted []byte
+		if r.EncryptionType == 0 {
+			decrypted, _, err = rs.wallet.DecryptPayloadDirect(ctx, csm.Tx, env)
+		} else {
+			decrypted, err = rs.decryptCoSignedIndirect(ctx, r, csm, env)
+		}
+		if err != nil {
+			logger.Info(ctx, "Failed to decrypt co-signed message : %s", err)
+			continue
+		}
+
+		// Copy so the decrypted data isn't appended into the envelope's payload.
+		payload := append(append([]byte{}, env.Payload()...), decrypted...)
+
+		a, err := actions.Deserialize(env.PayloadIdentifier(), payload)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "deserialize action")
+		}
+
+		message, ok := a.(*actions.Message)
+		if !ok {
+			return nil, nil, fmt.Errorf("Not a message : %s", a.Code())
+		}
+
+		return r, message, nil
+	}
+
+	return nil, nil, errors.New("Message not found")
+}
+
+// findRelationshipForCoSigner returns the open relationship whose next key is funded by an input
+//   of the co-signed tx, or nil if there isn't one. The lock must already be held.
+func (rs *Relationships) findRelationshipForCoSigner(csm *CoSignedMessage) *Relationship {
+	for _, input := range csm.Inputs {
+		ra, err := bitcoin.RawAddressFromLockingScript(input.LockingScript)
+		if err != nil {
+			continue
+		}
+
+		for _, r := range rs.Relationships {
+			if r.Closed {
+				continue
+			}
+
+			if next, err := r.NextKey.RawAddress(); err == nil && next.Equal(ra) {
+				return r
+			}
+		}
+	}
+
+	return nil
+}
+
+// coSignedSent returns what is needed to send the message in the co-signed tx again if it is
+//   cancelled. The hashes of our key and the co-signers' keys are moved when the tx is seen, so
+//   their positions are recorded for each input that is from their next key.
+func (r *Relationship) coSignedSent(csm *CoSignedMessage, message *actions.Message) *SentMessage {
+	result := &SentMessage{
+		TxId:             *csm.Tx.TxHash(),
+		RelationshipTxId: r.TxId,
+		MessageCode:      message.MessageCode,
+		Payload:          message.MessagePayload,
+		Timestamp:        uint64(time.Now().UnixNano()),
+		KeyHash:          r.NextHash,
+		KeyIndex:         r.NextIndex,
+	}
+
+	for _, input := range csm.Inputs {
+		ra, err := bitcoin.RawAddressFromLockingScript(input.LockingScript)
+		if err != nil {
+			continue
+		}
+
+		if next, err := r.NextKey.RawAddress(); err == nil && next.Equal(ra) {
+			result.KeyUsed = true
+			continue
+		}
+
+		for i, m := range r.Members {
+			if next, err := m.NextKey.RawAddress(); err == nil && next.Equal(ra) {
+				result.Members = append(result.Members, &SentMemberHash{
+					MemberIndex: uint32(i),
+					Hash:        m.NextHash,
+					Index:       m.NextIndex,
+				})
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// decryptCoSignedIndirect decrypts the indirectly encrypted payload with the key of the first
+//   input, which was signed when the message was created. The lock must already be held.
+func (rs *Relationships) decryptCoSignedIndirect(ctx context.Context, r *Relationship,
+	csm *CoSignedMessage, env envelope.BaseMessage) ([]byte, error) {
+
+	if len(csm.Tx.TxIn) == 0 {
+		return nil, errors.New("Missing inputs")
+	}
+
+	pk, err := bitcoin.PublicKeyFromUnlockingScript(csm.Tx.TxIn[0].SignatureScript)
+	if err != nil {
+		return nil, errors.Wrap(err, "sender parse script")
+	}
+
+	publicKey, err := bitcoin.PublicKeyFromBytes(pk)
+	if err != nil {
+		return nil, errors.Wrap(err, "sender public key")
+	}
+
+	encryptionKey, err := rs.FindEncryptionKey(ctx, r, publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "find encryption key")
+	}
+
+	return rs.wallet.DecryptPayloadIndirect(ctx, env, encryptionKey)
+}
+
+// checkFundingInput returns an error if the input doesn't spend an output of the funding tx, or if
+//   the locking script and value given for the input don't match the output.
+func (csm CoSignedMessage) checkFundingInput(index int) error {
+	outpoint := csm.Tx.TxIn[index].PreviousOutPoint
+	if !outpoint.Hash.Equal(csm.FundingTx.TxHash()) ||
+		int(outpoint.Index) >= len(csm.FundingTx.TxOut) {
+		return fmt.Errorf("Input %d doesn't spend the funding tx", index)
+	}
+
+	output := csm.FundingTx.TxOut[outpoint.Index]
+	if !bytes.Equal(output.PkScript, csm.Inputs[index].LockingScript) ||
+		output.Value != csm.Inputs[index].Value {
+		return fmt.Errorf("Input %d doesn't match the funding tx", index)
+	}
+
+	return nil
+}
+
+// IsComplete returns true if all of the inputs are signed.
+func (csm CoSignedMessage) IsComplete() bool {
+	for _, input := range csm.Tx.TxIn {
+		if len(input.SignatureScript) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (csm *CoSignedMessage) signInput(index int, key bitcoin.Key) error {
+	script, err := txbuilder.P2PKHUnlockingScript(key, csm.Tx, index,
+		csm.Inputs[index].LockingScript, csm.Inputs[index].Value,
+		txbuilder.SigHashAll+txbuilder.SigHashForkID, &txbuilder.SigHashCache{})
+	if err != nil {
+		return errors.Wrap(err, "unlocking script")
+	}
+
+	csm.Tx.TxIn[index].SignatureScript = script
+	return nil
+}
+
+func (csm CoSignedMessage) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := csm.Tx.Serialize(buf); err != nil {
+		return errors.Wrap(err, "tx")
+	}
+
+	if err := csm.FundingTx.Serialize(buf); err != nil {
+		return errors.Wrap(err, "funding tx")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(csm.Inputs))); err != nil {
+		return errors.Wrap(err, "input count")
+	}
+
+	for _, input := range csm.Inputs {
+		if err := binary.Write(buf, binary.LittleEndian,
+			uint32(len(input.LockingScript))); err != nil {
+			return errors.Wrap(err, "locking script size")
+		}
+
+		if _, err := buf.Write(input.LockingScript); err != nil {
+			return errors.Wrap(err, "locking script")
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, input.Value); err != nil {
+			return errors.Wrap(err, "value")
+		}
+	}
+
+	return nil
+}
+
+func (csm *CoSignedMessage) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	csm.Tx = &wire.MsgTx{}
+	if err := csm.Tx.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "tx")
+	}
+
+	csm.FundingTx = &wire.MsgTx{}
+	if err := csm.FundingTx.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "funding tx")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "input count")
+	}
+
+	csm.Inputs = make([]*CoSignedInput, 0, count)
+	for i := uint32(0); i < count; i++ {
+		input := &CoSignedInput{}
+
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return errors.Wrap(err, "locking script size")
+		}
+
+		input.LockingScript = make([]byte, size)
+		if _, err := io.ReadFull(buf, input.LockingScript); err != nil {
+			return errors.Wrap(err, "locking script")
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &input.Value); err != nil {
+			return errors.Wrap(err, "value")
+		}
+
+		csm.Inputs = append(csm.Inputs, input)
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestCoSignedMessage(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	// Relationships with only two members don't have a flag, so the co-signer finds the
+	//   relationship from its funded next key.
+	if len(receiveRS.Relationships[0].Flag) != 0 {
+		t.Fatalf("Relationship should not have a flag")
+	}
+
+	logger.Info(ctx, "Creating co-signed message *************************************************")
+
+	nextIndex := sendRS.Relationships[0].NextIndex
+
+	csm, err := sendRS.CreateCoSignedMessage(ctx, sendRS.Relationships[0],
+		&messages.PrivateMessage{
+			Subject: "Sample co-signed message",
+		}, []uint32{0})
+	if err != nil {
+		t.Fatalf("Failed to create co-signed message : %s", err)
+	}
+
+	if len(csm.Tx.TxIn) != 2 {
+		t.Fatalf("Wrong input count : got %d, want %d", len(csm.Tx.TxIn), 2)
+	}
+
+	if csm.IsComplete() {
+		t.Fatalf("Co-signed message should not be complete")
+	}
+
+	// Nothing is broadcast or used until the co-signers sign.
+	if len(sendBroadcastTx.Msgs) != 0 {
+		t.Fatalf("Wrong broadcast count : got %d, want %d", len(sendBroadcastTx.Msgs), 0)
+	}
+
+	if sendRS.Relationships[0].NextIndex != nextIndex {
+		t.Fatalf("Wrong next index : got %d, want %d", sendRS.Relationships[0].NextIndex,
+			nextIndex)
+	}
+
+	// Pass to the co-signer through serialization like the client does.
+	var buf bytes.Buffer
+	if err := csm.Serialize(&buf); err != nil {
+		t.Fatalf("Failed to serialize co-signed message : %s", err)
+	}
+
+	var received CoSignedMessage
+	if err := received.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Failed to deserialize co-signed message : %s", err)
+	}
+
+	// An input that doesn't match the funding tx isn't signed.
+	var tampered CoSignedMessage
+	if err := tampered.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Failed to deserialize co-signed message : %s", err)
+	}
+	tampered.Inputs[1].Value++
+
+	if _, err := receiveRS.SignCoSignedMessage(ctx, &tampered); err == nil {
+		t.Fatalf("Tampered co-signed message should not be signed")
+	}
+
+	logger.Info(ctx, "Signing co-signed message **************************************************")
+
+	complete, err := receiveRS.SignCoSignedMessage(ctx, &received)
+	if err != nil {
+		t.Fatalf("Failed to sign co-signed message : %s", err)
+	}
+
+	if !complete {
+		t.Fatalf("Co-signed message should be complete")
+	}
+
+	// The funding tx is broadcast with the message.
+	if len(receiveBroadcastTx.Msgs) != 2 {
+		t.Fatalf("Wrong broadcast count : got %d, want %d", len(receiveBroadcastTx.Msgs), 2)
+	}
+
+	if !receiveBroadcastTx.Msgs[0].TxHash().Equal(received.FundingTx.TxHash()) {
+		t.Fatalf("Funding tx not broadcast first")
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, receiveBroadcastTx)
+
+	if len(message.SenderIndexes) != 2 {
+		t.Fatalf("Wrong sender index count : got %d, want %d", len(message.SenderIndexes), 2)
+	}
+
+	_, areSender, memberIndexes, err := receiveRS.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		t.Fatalf("Failed to get relationship for tx : %s", err)
+	}
+
+	if !areSender {
+		t.Fatalf("Should be sender")
+	}
+
+	if len(memberIndexes) != 1 || memberIndexes[0] != 0 {
+		t.Fatalf("Wrong sender members : %v", memberIndexes)
+	}
+}
+
+func TestCoSignedMessageWithFlag(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	otherWallet, _, _ := newTestRelationships(t, ctx, cfg)
+
+	otherAddress, err := otherWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationships address : %s", err)
+	}
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, &otherAddress.PublicKey)
+
+	if len(receiveRS.Relationships[0].Flag) == 0 {
+		t.Fatalf("Relationship should have a flag")
+	}
+
+	csm, err := sendRS.CreateCoSignedMessage(ctx, sendRS.Relationships[0],
+		&messages.PrivateMessage{
+			Subject: "Sample co-signed message",
+		}, []uint32{0})
+	if err != nil {
+		t.Fatalf("Failed to create co-signed message : %s", err)
+	}
+
+	complete, err := receiveRS.SignCoSignedMessage(ctx, csm)
+	if err != nil {
+		t.Fatalf("Failed to sign co-signed message : %s", err)
+	}
+
+	if !complete {
+		t.Fatalf("Co-signed message should be complete")
+	}
+
+	if len(receiveBroadcastTx.Msgs) != 2 {
+		t.Fatalf("Wrong broadcast count : got %d, want %d", len(receiveBroadcastTx.Msgs), 2)
+	}
+}
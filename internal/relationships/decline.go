@@ -0,0 +1,112 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+// DeclineRelationship creates and broadcasts a decline of the relationship specified, instead of
+//   accepting it. The decline is sent from our next key so it is signed by us.
+// There is no decline message in the specification, so a decline is a RelationshipAmendment that
+//   drops the sender and is marked with the decline field. The other members keep their hash
+//   chains.
+// When stopMonitoring is true the relationship is also closed so its keys are no longer monitored.
+func (rs *Relationships) DeclineRelationship(ctx context.Context, r *Relationship,
+	stopMonitoring bool) error {
+
+	logger.Info(ctx, "Creating decline for relationship : %s", r.TxId.String())
+
+	if r.Closed {
+		return ErrClosed
+	}
+
+	if r.Accepted {
+		return errors.New("Already accepted")
+	}
+
+	if r.Declined {
+		return errors.New("Already declined")
+	}
+
+	decline := &messages.RelationshipAmendment{
+		DropMemberIndexes: true,
+	}
+
+	var declineBuf bytes.Buffer
+	if err := decline.Serialize(&declineBuf); err != nil {
+		return errors.Wrap(err, "serialize decline")
+	}
+
+	payload, err := appendField(declineBuf.Bytes(), declineField, []byte{1})
+	if err != nil {
+		return errors.Wrap(err, "append decline")
+	}
+
+	if err := rs.sendMessage(ctx, r, messages.CodeRelationshipAmendment, payload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	r.Declined = true
+	r.PendingAccepted = false
+
+	if stopMonitoring {
+		if err := rs.closeRelationship(ctx, r); err != nil {
+			return errors.Wrap(err, "close")
+		}
+	}
+
+	logger.Info(ctx, "Declined relationship : %s", r.TxId.String())
+	return nil
+}
+
+// isDecline returns true if the relationship amendment payload is marked as a decline.
+func isDecline(payload []byte) (bool, error) {
+	b, err := findField(payload, declineField)
+	if err != nil {
+		return false, errors.Wrap(err, "find field")
+	}
+
+	return len(b) != 0 && b[0] != 0, nil
+}
+
+// applyDecline records the decline against the members that sent it. When all members have
+//   declined the relationship is closed.
+func (rs *Relationships) applyDecline(ctx context.Context, r *Relationship, areSender bool,
+	memberIndexes []uint32) error {
+
+	if areSender {
+		return nil // already applied when it was sent
+	}
+
+	if len(memberIndexes) == 0 {
+		return ErrSenderNotFound
+	}
+
+	for _, memberIndex := range memberIndexes {
+		m := r.Members[memberIndex]
+
+		ra, err := m.BaseKey.RawAddress()
+		if err == nil {
+			logger.Info(ctx, "Relationship declined by %s : %s",
+				bitcoin.NewAddressFromRawAddress(ra, rs.cfg.Net).String(), r.TxId.String())
+		}
+		m.Declined = true
+		m.Accepted = false
+		m.PendingAccepted = false
+	}
+
+	for _, m := range r.Members {
+		if !m.Declined {
+			return nil
+		}
+	}
+
+	return rs.closeRelationship(ctx, r)
+}
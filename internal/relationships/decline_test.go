@@ -0,0 +1,111 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestDecline(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	_, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiver, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	_, _, err = sendRS.InitiateRelationship(ctx, []bitcoin.PublicKey{receiver.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	logger.Info(ctx, "Send decline ***************************************************************")
+
+	receiveR := receiveRS.Relationships[0]
+	if err := receiveRS.DeclineRelationship(ctx, receiveR, true); err != nil {
+		t.Fatalf("Failed to decline relationship : %s", err)
+	}
+
+	if !receiveR.Declined || !receiveR.Closed {
+		t.Fatalf("Relationship not marked declined and closed")
+	}
+
+	if _, err := receiveRS.AcceptRelationship(ctx, receiveR, nil); err == nil {
+		t.Fatalf("Accept after decline should fail")
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	if message.MessageCode != messages.CodeRelationshipAmendment {
+		t.Fatalf("Wrong message code : got %d, want %d", message.MessageCode,
+			messages.CodeRelationshipAmendment)
+	}
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	decline, ok := p.(*messages.RelationshipAmendment)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if declined, err := isDecline(message.MessagePayload); err != nil || !declined {
+		t.Fatalf("Decline not marked : %v", err)
+	}
+
+	// An amendment without a seed is only a decline when it is marked.
+	amendment := &messages.RelationshipAmendment{DropMemberIndexes: true}
+	var amendmentBuf bytes.Buffer
+	if err := amendment.Serialize(&amendmentBuf); err != nil {
+		t.Fatalf("Failed to serialize amendment : %s", err)
+	}
+
+	if declined, err := isDecline(amendmentBuf.Bytes()); err != nil || declined {
+		t.Fatalf("Unmarked amendment is a decline : %v", err)
+	}
+
+	logger.Info(ctx, "Process decline ************************************************************")
+
+	if _, err := sendRS.ProcessRelationshipAmendment(ctx, itx, message, decline, flag); err != nil {
+		t.Fatalf("Failed to process decline : %s", err)
+	}
+
+	sendR := sendRS.Relationships[0]
+	if !sendR.Members[0].Declined {
+		t.Fatalf("Member not marked declined")
+	}
+
+	if !sendR.Closed {
+		t.Fatalf("Relationship not closed when all members declined")
+	}
+}
@@ -0,0 +1,345 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// ExportVersion is the version of the export file format.
+	ExportVersion = uint8(0)
+
+	exportSaltSize = 16
+
+	// scrypt parameters for deriving the export encryption key from the passphrase.
+	exportScryptN = 32768
+	exportScryptR = 8
+	exportScryptP = 1
+)
+
+var (
+	// ErrInvalidPassphrase means the export couldn't be decrypted with the passphrase.
+	ErrInvalidPassphrase = errors.New("Invalid passphrase")
+)
+
+// Export returns the relationships, including their seeds, flags, encryption keys, members, and
+//   hash chain positions, and their history, encrypted with a key derived from the passphrase.
+//   The format version, salt, and nonce are not encrypted, but the version is authenticated.
+func (rs *Relationships) Export(ctx context.Context, passphrase string) ([]byte, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var data bytes.Buffer
+	if err := rs.Serialize(&data); err != nil {
+		return nil, errors.Wrap(err, "serialize relationships")
+	}
+
+	for _, r := range rs.Relationships {
+		if err := serializeHistory(&data, rs.history[r.TxId]); err != nil {
+			return nil, errors.Wrap(err, "serialize history")
+		}
+	}
+
+	salt := make([]byte, exportSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "salt")
+	}
+
+	aead, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "nonce")
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, ExportVersion); err != nil {
+		return nil, errors.Wrap(err, "version")
+	}
+	buf.Write(salt)
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, data.Bytes(), []byte{ExportVersion}))
+
+	return buf.Bytes(), nil
+}
+
+// Import decrypts an export with the passphrase and merges it into the relationships.
+//   Relationships that don't exist locally are added. Relationships that already exist keep the
+//   furthest hash chain positions and the messages from both histories.
+// Returns the number of relationships added.
+func (rs *Relationships) Import(ctx context.Context, passphrase string, b []byte) (int, error) {
+	if len(b) < 1+exportSaltSize {
+		return 0, errors.New("Export too short")
+	}
+
+	version := b[0]
+	if version != ExportVersion {
+		return 0, fmt.Errorf("Unsupported export version : %d", version)
+	}
+
+	salt := b[1 : 1+exportSaltSize]
+	aead, err := exportCipher(passphrase, salt)
+	if err != nil {
+		return 0, errors.Wrap(err, "cipher")
+	}
+
+	b = b[1+exportSaltSize:]
+	if len(b) < aead.NonceSize() {
+		return 0, errors.New("Export too short")
+	}
+
+	data, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte{version})
+	if err != nil {
+		return 0, ErrInvalidPassphrase
+	}
+
+	buf := bytes.NewReader(data)
+
+	imported := &Relationships{}
+	if err := imported.Deserialize(buf); err != nil {
+		return 0, errors.Wrap(err, "deserialize relationships")
+	}
+
+	history := make(map[int][]*Message)
+	for i := range imported.Relationships {
+		list, err := deserializeHistory(buf)
+		if err != nil {
+			return 0, errors.Wrap(err, "deserialize history")
+		}
+		history[i] = list
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	added := 0
+	for i, ir := range imported.Relationships {
+		var r *Relationship
+		for _, existing := range rs.Relationships {
+			if existing.TxId.Equal(&ir.TxId) {
+				r = existing
+				break
+			}
+		}
+
+		if r == nil {
+			if err := rs.addImportedRelationship(ctx, ir); err != nil {
+				return added, errors.Wrapf(err, "add relationship %s", ir.TxId.String())
+			}
+			added++
+		} else if err := rs.mergeRelationship(ctx, r, ir); err != nil {
+			return added, errors.Wrapf(err, "merge relationship %s", ir.TxId.String())
+		}
+
+		rs.mergeHistory(ir.TxId, history[i])
+	}
+
+	return added, nil
+}
+
+// reindexSentMembers updates the member indexes of the unconfirmed messages we sent in the
+//   relationship to match the new member order. The lock must already be held.
+func (rs *Relationships) reindexSentMembers(r *Relationship, members []*Member) {
+	for _, sent := range rs.sent {
+		if !sent.RelationshipTxId.Equal(&r.TxId) {
+			continue
+		}
+
+		for _, mh := range sent.Members {
+			if int(mh.MemberIndex) >= len(r.Members) {
+				continue
+			}
+
+			baseKey := r.Members[mh.MemberIndex].BaseKey
+			for i, m := range members {
+				if m.BaseKey.Equal(baseKey) {
+					mh.MemberIndex = uint32(i)
+					break
+				}
+			}
+		}
+	}
+}
+
+// exportCipher returns the cipher for an export using a key derived from the passphrase and salt.
+func exportCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, exportScryptN, exportScryptR, exportScryptP,
+		32)
+	if err != nil {
+		return nil, errors.Wrap(err, "derive key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes")
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// addImportedRelationship adds a relationship that doesn't exist locally and starts monitoring
+//   its keys. The lock must already be held.
+func (rs *Relationships) addImportedRelationship(ctx context.Context, r *Relationship) error {
+	logger.Info(ctx, "Importing relationship : %s", r.TxId.String())
+
+	key, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	r.NextKey, err = bitcoin.NextPublicKey(key.PublicKey(), r.NextHash)
+	if err != nil {
+		return errors.Wrap(err, "next key")
+	}
+
+	// Mark the relationship key used so it isn't given out again.
+	if ad := rs.wallet.GetAddress(ctx, r.KeyType, r.KeyIndex); ad != nil {
+		if err := rs.wallet.MarkAddress(ctx, ad); err != nil {
+			return errors.Wrap(err, "mark address")
+		}
+	}
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
+	rs.Relationships = append(rs.Relationships, r)
+	return nil
+}
+
+// mergeRelationship updates a local relationship with an imported copy of it. Hash chain
+//   positions only move forward, members and threads that are only in the import are added, and
+//   the accepted, declined, and closed states are kept if either copy has them. The lock must
+//   already be held.
+func (rs *Relationships) mergeRelationship(ctx context.Context, r, imported *Relationship) error {
+	logger.Info(ctx, "Merging imported relationship : %s", r.TxId.String())
+
+	if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "remove keys")
+	}
+
+	importedNewer := imported.NextIndex > r.NextIndex
+	if importedNewer {
+		r.NextHash = imported.NextHash
+		r.NextIndex = imported.NextIndex
+	}
+
+	r.Accepted = r.Accepted || imported.Accepted
+	r.PendingAccepted = r.PendingAccepted || imported.PendingAccepted
+	r.Closed = r.Closed || imported.Closed
+	r.Declined = r.Declined || imported.Declined
+
+	for _, im := range imported.Members {
+		m := r.findMember(im.BaseKey)
+		if m == nil {
+			continue
+		}
+
+		if im.NextIndex > m.NextIndex {
+			m.NextHash = im.NextHash
+			m.NextIndex = im.NextIndex
+			m.NextKey = im.NextKey
+		}
+
+		m.Accepted = m.Accepted || im.Accepted
+		m.PendingAccepted = m.PendingAccepted || im.PendingAccepted
+		m.Closed = m.Closed || im.Closed
+		m.Declined = m.Declined || im.Declined
+		if len(m.ProofOfIdentity) == 0 {
+			m.ProofOfIdentityType = im.ProofOfIdentityType
+			m.ProofOfIdentity = im.ProofOfIdentity
+			m.Identity = im.Identity
+			m.IdentityStatus = im.IdentityStatus
+		}
+	}
+
+	// Members are matched by public key. Member indexes, that messages and receipts depend on, are
+	//   set by the relationship's txs, so the member order of the copy that is further along the
+	//   relationship's hash chain is kept.
+	if importedNewer {
+		members := make([]*Member, 0, len(imported.Members))
+		for _, im := range imported.Members {
+			if m := r.findMember(im.BaseKey); m != nil {
+				members = append(members, m)
+			} else {
+				members = append(members, im)
+			}
+		}
+		for _, m := range r.Members {
+			if imported.findMember(m.BaseKey) == nil {
+				members = append(members, m)
+			}
+		}
+
+		rs.reindexSentMembers(r, members)
+		r.Members = members
+	} else {
+		for _, im := range imported.Members {
+			if r.findMember(im.BaseKey) == nil {
+				r.Members = append(r.Members, im)
+			}
+		}
+	}
+
+	for _, it := range imported.Threads {
+		if r.FindThread(it.TxId) == nil {
+			r.Threads = append(r.Threads, it)
+		}
+	}
+
+	key, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	r.NextKey, err = bitcoin.NextPublicKey(key.PublicKey(), r.NextHash)
+	if err != nil {
+		return errors.Wrap(err, "next key")
+	}
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
+	return nil
+}
+
+// mergeHistory adds the imported messages that aren't already in the relationship's history and
+//   keeps the history in time order. The lock must already be held.
+func (rs *Relationships) mergeHistory(txid bitcoin.Hash32, imported []*Message) {
+	list := rs.history[txid]
+	for _, im := range imported {
+		found := false
+		for _, m := range list {
+			if m.TxId.Equal(&im.TxId) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			list = append(list, im)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Timestamp < list[j].Timestamp
+	})
+
+	rs.history[txid] = list
+}
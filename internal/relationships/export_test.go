@@ -0,0 +1,205 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+
+	"github.com/pkg/errors"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := sendRS.Relationships[0]
+	sendRS.AddHistory(ctx, r, &Message{
+		TxId:      bitcoin.Hash32{1},
+		Direction: DirectionOutgoing,
+		Timestamp: 1,
+	})
+
+	b, err := sendRS.Export(ctx, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to export : %s", err)
+	}
+
+	importRS, err := NewRelationships(cfg, sendWallet, sendBroadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to create relationships : %s", err)
+	}
+
+	if _, err := importRS.Import(ctx, "wrong", b); errors.Cause(err) != ErrInvalidPassphrase {
+		t.Fatalf("Wrong passphrase error : got %v, want %s", err, ErrInvalidPassphrase)
+	}
+
+	added, err := importRS.Import(ctx, "passphrase", b)
+	if err != nil {
+		t.Fatalf("Failed to import : %s", err)
+	}
+
+	if added != 1 {
+		t.Fatalf("Wrong added count : got %d, want %d", added, 1)
+	}
+
+	ir := importRS.Relationships[0]
+	if !ir.TxId.Equal(&r.TxId) || ir.NextIndex != r.NextIndex || !ir.NextHash.Equal(&r.NextHash) {
+		t.Fatalf("Wrong imported relationship")
+	}
+
+	if !ir.NextKey.Equal(r.NextKey) {
+		t.Fatalf("Wrong imported next key : got %s, want %s", ir.NextKey.String(),
+			r.NextKey.String())
+	}
+
+	if len(ir.Members) != len(r.Members) {
+		t.Fatalf("Wrong imported member count : got %d, want %d", len(ir.Members), len(r.Members))
+	}
+
+	// Merge a later copy with a new message into the existing relationship.
+	sendRS.AddHistory(ctx, r, &Message{
+		TxId:      bitcoin.Hash32{2},
+		Direction: DirectionIncoming,
+		Timestamp: 2,
+	})
+	r.Members[0].NextIndex++
+
+	b, err = sendRS.Export(ctx, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to export : %s", err)
+	}
+
+	added, err = importRS.Import(ctx, "passphrase", b)
+	if err != nil {
+		t.Fatalf("Failed to import : %s", err)
+	}
+
+	if added != 0 {
+		t.Fatalf("Wrong added count : got %d, want %d", added, 0)
+	}
+
+	if ir.Members[0].NextIndex != r.Members[0].NextIndex {
+		t.Fatalf("Wrong merged member next index : got %d, want %d", ir.Members[0].NextIndex,
+			r.Members[0].NextIndex)
+	}
+
+	if len(importRS.GetHistory(ctx, ir)) != 2 {
+		t.Fatalf("Wrong merged history count : got %d, want %d",
+			len(importRS.GetHistory(ctx, ir)), 2)
+	}
+
+	// Merge a copy that is further along the hash chain into one with a member it doesn't have
+	//   first. Members are matched by public key and the newer copy's member order is kept.
+	other, err := bitcoin.GenerateKey(bitcoin.MainNet)
+	if err != nil {
+		t.Fatalf("Failed to generate key : %s", err)
+	}
+
+	ir.Members = append([]*Member{&Member{BaseKey: other.PublicKey()}}, ir.Members...)
+	r.NextHash = bitcoin.NextHash(r.NextHash)
+	r.NextIndex++
+
+	b, err = sendRS.Export(ctx, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to export : %s", err)
+	}
+
+	if _, err := importRS.Import(ctx, "passphrase", b); err != nil {
+		t.Fatalf("Failed to import : %s", err)
+	}
+
+	if len(ir.Members) != 2 {
+		t.Fatalf("Wrong merged member count : got %d, want %d", len(ir.Members), 2)
+	}
+
+	if !ir.Members[0].BaseKey.Equal(r.Members[0].BaseKey) {
+		t.Fatalf("Wrong first merged member : got %s, want %s", ir.Members[0].BaseKey.String(),
+			r.Members[0].BaseKey.String())
+	}
+
+	if !ir.Members[1].BaseKey.Equal(other.PublicKey()) {
+		t.Fatalf("Wrong second merged member : got %s, want %s", ir.Members[1].BaseKey.String(),
+			other.PublicKey().String())
+	}
+}
+
+func TestImportKeepsOtherKeys(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	// Initiate another relationship to the same receive key.
+	receiveR := receiveRS.Relationships[0]
+	receiveKey, err := receiveWallet.GetKey(ctx, receiveR.KeyType, receiveR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get receive key : %s", err)
+	}
+
+	_, otherBroadcastTx, otherRS := newTestRelationships(t, ctx, cfg)
+
+	if _, _, err := otherRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveKey.PublicKey()},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, otherBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	// Merge both relationships back into themselves.
+	b, err := receiveRS.Export(ctx, "passphrase")
+	if err != nil {
+		t.Fatalf("Failed to export : %s", err)
+	}
+
+	if _, err := receiveRS.Import(ctx, "passphrase", b); err != nil {
+		t.Fatalf("Failed to import : %s", err)
+	}
+
+	for i, r := range receiveRS.Relationships {
+		nextAddress, err := r.NextKey.RawAddress()
+		if err != nil {
+			t.Fatalf("Failed to get next address : %s", err)
+		}
+
+		hashes, err := nextAddress.Hashes()
+		if err != nil {
+			t.Fatalf("Failed to get next address hashes : %s", err)
+		}
+
+		if monitored, _ := receiveWallet.AreHashesMonitored(hashes); !monitored {
+			t.Fatalf("Next key of relationship %d not monitored", i)
+		}
+	}
+}
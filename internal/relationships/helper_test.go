@@ -2,6 +2,8 @@ package relationships
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/tokenized/relationship-example/internal/platform/config"
@@ -11,12 +13,35 @@ import (
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 	"github.com/tokenized/smart-contract/pkg/inspector"
 	"github.com/tokenized/smart-contract/pkg/logger"
+	"github.com/tokenized/smart-contract/pkg/wire"
 
 	"github.com/tokenized/specification/dist/golang/actions"
 	"github.com/tokenized/specification/dist/golang/messages"
 	"github.com/tokenized/specification/dist/golang/protocol"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 )
 
+// newTestRelationships creates a mock wallet and broadcaster and the relationships that use them.
+func newTestRelationships(t *testing.T, ctx context.Context,
+	cfg *config.Config) (*wallet.Wallet, *tests.MockBroadcaster, *Relationships) {
+
+	w, err := tests.NewMockWallet(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create mock wallet : %s", err)
+	}
+
+	broadcastTx := tests.NewMockBroadcaster(cfg)
+
+	rs, err := NewRelationships(cfg, w, broadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to create relationships : %s", err)
+	}
+
+	return w, broadcastTx, rs
+}
+
 func decryptMessage(t *testing.T, ctx context.Context, cfg *config.Config, rs *Relationships,
 	broadcastTx *tests.MockBroadcaster) (*inspector.Transaction, *actions.Message, bitcoin.Hash32, []byte) {
 
@@ -172,3 +197,76 @@ func createRelationship(t *testing.T, ctx context.Context, cfg *config.Config,
 		t.Fatalf("Failed to process accept : %s", err)
 	}
 }
+
+// newMockOracle creates a mock identity oracle that signs any paymail handle or entity requested
+//   without checking it.
+func newMockOracle(t *testing.T) *tests.MockOracle {
+	oracle, err := tests.NewMockOracle()
+	if err != nil {
+		t.Fatalf("Failed to create mock oracle : %s", err)
+	}
+
+	oracle.HandleFunc(OracleSignPaymailPath, func(w http.ResponseWriter, r *http.Request) {
+		var request OracleSignPaymailRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		publicKey, err := bitcoin.PublicKeyFromStr(request.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		oracle.WriteSignature(w, PaymailProofSigHash(publicKey, request.UserID, request.Handle,
+			tests.MockOracleBlockHeight))
+	})
+
+	oracle.HandleFunc(OracleSignEntityPath, func(w http.ResponseWriter, r *http.Request) {
+		var request OracleSignEntityRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		publicKey, err := bitcoin.PublicKeyFromStr(request.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entity := &messages.EntityField{}
+		if err := proto.Unmarshal(request.Entity, entity); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sigHash, err := IdentityOracleProofSigHash(publicKey, request.UserID, entity,
+			tests.MockOracleBlockHeight)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		oracle.WriteSignature(w, sigHash)
+	})
+
+	return oracle
+}
+
+// failingBroadcaster fails to broadcast the txs with the specified positions in the order they
+//   are broadcast.
+type failingBroadcaster struct {
+	*tests.MockBroadcaster
+	fail  map[int]bool
+	count int
+}
+
+func (fb *failingBroadcaster) BroadcastTx(ctx context.Context, tx *wire.MsgTx) error {
+	fb.count++
+	if fb.fail[fb.count] {
+		return errors.New("Broadcast failed")
+	}
+	return fb.MockBroadcaster.BroadcastTx(ctx, tx)
+}
@@ -0,0 +1,215 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/envelope/pkg/golang/envelope"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/inspector"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// Protobuf field numbers used to append data to messages that isn't in the specification. They are
+//   outside of the fields used by the specification messages so they are ignored by clients that
+//   don't support them.
+const (
+	// senderHintField contains a SenderHint in the encrypted part of a message.
+	senderHintField = 1000
+
+	// threadField contains the txid of the thread in a private message payload.
+	threadField = 1001
+
+	// threadNameField contains the name of the thread in an initiate thread payload.
+	threadNameField = 1002
+
+	// attachmentHashesField contains the SHA256 hashes of the contents of the attachments in a
+	//   private message payload, in the same order as the attachments.
+	attachmentHashesField = 1003
+
+	// receiptField contains the receipt status in a private message payload that is a receipt for
+	//   the message in its regarding field.
+	receiptField = 1004
+
+	// amendmentPositionField contains the amendmentPosition in a relationship amendment payload.
+	amendmentPositionField = 1005
+
+	// declineField marks a relationship amendment payload as a decline.
+	declineField = 1006
+)
+
+// SenderHint identifies the sender of a relationship message. It is included in the encrypted part
+//   of the message so that only members of the relationship can see it.
+type SenderHint struct {
+	// Index of the tx input that is the sender.
+	SenderIndex uint32
+
+	// Index in the relationship's hash chain of the key the sender used.
+	KeyIndex uint64
+}
+
+func (h SenderHint) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, h.SenderIndex); err != nil {
+		return errors.Wrap(err, "sender index")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, h.KeyIndex); err != nil {
+		return errors.Wrap(err, "key index")
+	}
+
+	return nil
+}
+
+func (h *SenderHint) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &h.SenderIndex); err != nil {
+		return errors.Wrap(err, "sender index")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &h.KeyIndex); err != nil {
+		return errors.Wrap(err, "key index")
+	}
+
+	return nil
+}
+
+// appendSenderHint appends the hint to the serialized private payload as a protobuf field.
+func appendSenderHint(payload []byte, hint SenderHint) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := hint.Serialize(&buf); err != nil {
+		return nil, errors.Wrap(err, "serialize")
+	}
+
+	return appendField(payload, senderHintField, buf.Bytes())
+}
+
+// findSenderHint returns the sender hint in the decrypted payload, or nil if there isn't one.
+func findSenderHint(payload []byte) (*SenderHint, error) {
+	b, err := findField(payload, senderHintField)
+	if err != nil {
+		return nil, errors.Wrap(err, "find field")
+	}
+	if b == nil {
+		return nil, nil
+	}
+
+	hint := &SenderHint{}
+	if err := hint.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, errors.Wrap(err, "deserialize")
+	}
+	return hint, nil
+}
+
+// deserializeAction combines the unencrypted and decrypted parts of the envelope into an action
+//   and saves any sender hint in the decrypted part for when the tx is processed. The lock must
+//   already be held.
+func (rs *Relationships) deserializeAction(ctx context.Context, itx *inspector.Transaction,
+	env envelope.BaseMessage, decrypted []byte) (actions.Action, error) {
+
+	// Copy so the decrypted data isn't appended into the envelope's payload.
+	payload := append(append([]byte{}, env.Payload()...), decrypted...)
+
+	a, err := actions.Deserialize(env.PayloadIdentifier(), payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "deserialize action")
+	}
+
+	hint, err := findSenderHint(decrypted)
+	if err != nil {
+		logger.Warn(ctx, "Invalid sender hint : %s", err)
+	} else if hint != nil {
+		logger.Info(ctx, "Found sender hint : input %d, key index %d", hint.SenderIndex,
+			hint.KeyIndex)
+		rs.hints[*itx.Hash] = hint
+	}
+
+	return a, nil
+}
+
+// takeSenderHint returns and removes the sender hint saved when the tx was decrypted.
+func (rs *Relationships) takeSenderHint(txid bitcoin.Hash32) *SenderHint {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	hint, exists := rs.hints[txid]
+	if !exists {
+		return nil
+	}
+
+	delete(rs.hints, txid)
+	return hint
+}
+
+// appendField appends the value to the serialized protobuf message as a bytes field.
+func appendField(payload []byte, field uint64, value []byte) ([]byte, error) {
+	pb := proto.NewBuffer(payload)
+	if err := pb.EncodeVarint(field<<3 | proto.WireBytes); err != nil {
+		return nil, errors.Wrap(err, "field key")
+	}
+	if err := pb.EncodeRawBytes(value); err != nil {
+		return nil, errors.Wrap(err, "field value")
+	}
+
+	return pb.Bytes(), nil
+}
+
+// findField returns the value of the bytes field in the serialized protobuf message, or nil if the
+//   field isn't there.
+func findField(payload []byte, field uint64) ([]byte, error) {
+	offset := 0
+	for offset < len(payload) {
+		key, n := proto.DecodeVarint(payload[offset:])
+		if n == 0 {
+			return nil, errors.New("Invalid field key")
+		}
+		offset += n
+
+		switch key & 7 {
+		case proto.WireVarint:
+			if _, n = proto.DecodeVarint(payload[offset:]); n == 0 {
+				return nil, errors.New("Invalid varint")
+			}
+			offset += n
+		case proto.WireFixed64:
+			offset += 8
+		case proto.WireFixed32:
+			offset += 4
+		case proto.WireBytes:
+			l, n := proto.DecodeVarint(payload[offset:])
+			if n == 0 || uint64(len(payload)-offset-n) < l {
+				return nil, errors.New("Invalid bytes length")
+			}
+			offset += n
+
+			if key>>3 == field {
+				return payload[offset : offset+int(l)], nil
+			}
+			offset += int(l)
+		default:
+			return nil, fmt.Errorf("Unsupported wire type : %d", key&7)
+		}
+	}
+
+	return nil, nil
+}
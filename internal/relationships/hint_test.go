@@ -0,0 +1,79 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestSenderHint(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	// Skip the sender past the receiver's lookahead window so only the hint can find the key.
+	sendR := sendRS.Relationships[0]
+	for i := 0; i < LookaheadWindow+5; i++ {
+		if err := sendR.IncrementHash(ctx, sendWallet); err != nil {
+			t.Fatalf("Failed to increment hash : %s", err)
+		}
+	}
+	keyIndex := sendR.NextIndex
+
+	logger.Info(ctx, "Sending private message ****************************************************")
+
+	err := sendRS.SendMessage(ctx, sendR, &messages.PrivateMessage{
+		Subject: "Sample hinted message",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	hint, exists := receiveRS.hints[*itx.Hash]
+	if !exists {
+		t.Fatalf("Sender hint not found")
+	}
+
+	if hint.SenderIndex != 0 {
+		t.Fatalf("Wrong hint sender index : got %d, want %d", hint.SenderIndex, 0)
+	}
+
+	if hint.KeyIndex != keyIndex {
+		t.Fatalf("Wrong hint key index : got %d, want %d", hint.KeyIndex, keyIndex)
+	}
+
+	r, areSender, memberIndexes, err := receiveRS.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		t.Fatalf("Failed to get relationship for tx : %s", err)
+	}
+
+	if areSender {
+		t.Fatalf("Should not be sender")
+	}
+
+	if len(memberIndexes) != 1 {
+		t.Fatalf("Wrong sender count : got %d, want %d", len(memberIndexes), 1)
+	}
+	memberIndex := memberIndexes[0]
+
+	if r.Members[memberIndex].NextIndex != keyIndex+1 {
+		t.Fatalf("Wrong member next index : got %d, want %d", r.Members[memberIndex].NextIndex,
+			keyIndex+1)
+	}
+
+	if _, exists := receiveRS.hints[*itx.Hash]; exists {
+		t.Fatalf("Sender hint not removed")
+	}
+}
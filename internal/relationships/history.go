@@ -0,0 +1,223 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/tokenized/relationship-example/internal/platform/db"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	historyKey = "history"
+)
+
+// AddHistory adds a message to the history of the relationship. It is ignored if a message from
+//   the same tx is already in the history. Returns true if the message was added.
+func (rs *Relationships) AddHistory(ctx context.Context, r *Relationship, m *Message) bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	for _, existing := range rs.history[r.TxId] {
+		if existing.TxId.Equal(&m.TxId) {
+			return false // already in history
+		}
+	}
+
+	logger.Info(ctx, "Adding message to history : %s", m.TxId.String())
+	rs.history[r.TxId] = append(rs.history[r.TxId], m)
+	return true
+}
+
+// GetHistory returns the messages in the relationship.
+func (rs *Relationships) GetHistory(ctx context.Context, r *Relationship) []*Message {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	list := rs.history[r.TxId]
+	result := make([]*Message, len(list))
+	copy(result, list)
+	return result
+}
+
+// FindMessage returns the message in the relationship's history from the tx, or nil if it isn't
+//   found.
+func (rs *Relationships) FindMessage(ctx context.Context, r *Relationship,
+	txid bitcoin.Hash32) *Message {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	for _, m := range rs.history[r.TxId] {
+		if m.TxId.Equal(&txid) {
+			return m
+		}
+	}
+	return nil
+}
+
+// GetReplies returns the messages in the relationship that reply to the message from the tx.
+func (rs *Relationships) GetReplies(ctx context.Context, r *Relationship,
+	txid bitcoin.Hash32) []*Message {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var result []*Message
+	for _, m := range rs.history[r.TxId] {
+		if m.ReplyTo.Equal(&txid) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// GetReplyChain returns the chain of messages that the message from the tx replies to, starting
+//   with the first message in the conversation and ending with the message itself. Messages that
+//   aren't in the history end the chain.
+func (rs *Relationships) GetReplyChain(ctx context.Context, r *Relationship,
+	txid bitcoin.Hash32) []*Message {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var result []*Message
+	zero := bitcoin.Hash32{}
+	for !txid.Equal(&zero) {
+		var found *Message
+		for _, m := range rs.history[r.TxId] {
+			if m.TxId.Equal(&txid) {
+				found = m
+				break
+			}
+		}
+		if found == nil || containsMessage(result, found) {
+			break
+		}
+
+		result = append([]*Message{found}, result...)
+		txid = found.ReplyTo
+	}
+	return result
+}
+
+// MarkConfirmed marks any messages contained in the tx as confirmed. A message we sent in the tx
+//   is no longer kept to be sent again.
+func (rs *Relationships) MarkConfirmed(ctx context.Context, txid bitcoin.Hash32) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.removeSent(txid)
+
+	for _, list := range rs.history {
+		for _, m := range list {
+			if m.TxId.Equal(&txid) && !m.Confirmed {
+				logger.Info(ctx, "Message confirmed : %s", txid.String())
+				m.Confirmed = true
+			}
+		}
+	}
+}
+
+// addPrivateMessageHistory adds a private message contained in a tx to the relationship history.
+//   Incoming messages are attributed to the first member that sent them. thread is zero when the
+//   message isn't in a thread. The message it replies to comes from the regarding field. Returns
+//   true if the message wasn't already in the history.
+func (rs *Relationships) addPrivateMessageHistory(ctx context.Context, r *Relationship,
+	txid bitcoin.Hash32, areSender bool, memberIndexes []uint32, thread bitcoin.Hash32,
+	privateMessage *messages.PrivateMessage) (bool, error) {
+
+	payload, err := privateMessage.Bytes()
+	if err != nil {
+		return false, errors.Wrap(err, "serialize private message")
+	}
+
+	m := &Message{
+		TxId:        txid,
+		MessageCode: messages.CodePrivateMessage,
+		Payload:     payload,
+		Timestamp:   privateMessage.Timestamp,
+		Thread:      thread,
+	}
+
+	if privateMessage.Regarding != nil && len(privateMessage.Regarding.TxId) > 0 {
+		replyTo, err := bitcoin.NewHash32(privateMessage.Regarding.TxId)
+		if err != nil {
+			logger.Warn(ctx, "Invalid regarding txid : %s", err)
+		} else {
+			m.ReplyTo = *replyTo
+			logger.Info(ctx, "Message %s replies to : %s", txid.String(), replyTo.String())
+		}
+	}
+
+	if areSender {
+		m.Direction = DirectionOutgoing
+	} else {
+		m.Direction = DirectionIncoming
+		if len(memberIndexes) > 0 {
+			m.MemberIndex = memberIndexes[0]
+		}
+	}
+
+	if m.Timestamp == 0 {
+		m.Timestamp = uint64(time.Now().UnixNano())
+	}
+
+	return rs.AddHistory(ctx, r, m), nil
+}
+
+func containsMessage(list []*Message, m *Message) bool {
+	for _, l := range list {
+		if l == m {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHistory loads the history for each relationship. The lock must already be held.
+func (rs *Relationships) loadHistory(ctx context.Context, dbConn *db.DB) error {
+	rs.history = make(map[bitcoin.Hash32][]*Message)
+
+	for _, r := range rs.Relationships {
+		b, err := dbConn.Fetch(ctx, historyKey+"/"+r.TxId.String())
+		if err != nil {
+			if err == db.ErrNotFound {
+				continue
+			}
+			return errors.Wrap(err, "fetch history")
+		}
+
+		list, err := deserializeHistory(bytes.NewReader(b))
+		if err != nil {
+			return errors.Wrap(err, "deserialize history")
+		}
+
+		rs.history[r.TxId] = list
+	}
+
+	return nil
+}
+
+// saveHistory saves the history for each relationship. The lock must already be held.
+func (rs *Relationships) saveHistory(ctx context.Context, dbConn db.Writer) error {
+	for txid, list := range rs.history {
+		var buf bytes.Buffer
+		if err := serializeHistory(&buf, list); err != nil {
+			return errors.Wrap(err, "serialize history")
+		}
+
+		if err := dbConn.Put(ctx, historyKey+"/"+txid.String(), buf.Bytes()); err != nil {
+			return errors.Wrap(err, "put history")
+		}
+	}
+
+	return nil
+}
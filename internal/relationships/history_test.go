@@ -0,0 +1,197 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	sendPrivateMessage := &messages.PrivateMessage{
+		Timestamp: 1234,
+		PrivateMessage: &messages.DocumentField{
+			Type:     "text/plain",
+			Contents: []byte("Sample message"),
+		},
+	}
+
+	err := sendRS.SendMessage(ctx, sendRS.Relationships[0], sendPrivateMessage)
+	if err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message : %s", err)
+	}
+
+	r := receiveRS.Relationships[0]
+	history := receiveRS.GetHistory(ctx, r)
+	if len(history) != 1 {
+		t.Fatalf("Wrong history count : got %d, want %d", len(history), 1)
+	}
+
+	if history[0].Direction != DirectionIncoming {
+		t.Fatalf("Wrong direction : got %d, want %d", history[0].Direction, DirectionIncoming)
+	}
+
+	if history[0].Timestamp != 1234 {
+		t.Fatalf("Wrong timestamp : got %d, want %d", history[0].Timestamp, 1234)
+	}
+
+	if history[0].Confirmed {
+		t.Fatalf("Message should not be confirmed")
+	}
+
+	receiveRS.MarkConfirmed(ctx, *itx.Hash)
+
+	var buf bytes.Buffer
+	if err := serializeHistory(&buf, receiveRS.GetHistory(ctx, r)); err != nil {
+		t.Fatalf("Failed to serialize history : %s", err)
+	}
+
+	read, err := deserializeHistory(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to deserialize history : %s", err)
+	}
+
+	if len(read) != 1 {
+		t.Fatalf("Wrong deserialized history count : got %d, want %d", len(read), 1)
+	}
+
+	if !read[0].Confirmed {
+		t.Fatalf("Message should be confirmed")
+	}
+
+	if !read[0].TxId.Equal(itx.Hash) {
+		t.Fatalf("Wrong txid : got %s, want %s", read[0].TxId.String(), itx.Hash.String())
+	}
+
+	if !bytes.Equal(read[0].Payload, history[0].Payload) {
+		t.Fatalf("Wrong payload : \n  got  %x\n  want %x", read[0].Payload, history[0].Payload)
+	}
+}
+
+func TestReplies(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	var txids []bitcoin.Hash32
+	for i := 0; i < 2; i++ {
+		sendPrivateMessage := &messages.PrivateMessage{
+			Subject: "Sample subject",
+		}
+
+		if i > 0 {
+			sendPrivateMessage.Regarding = &messages.OutpointField{
+				TxId: txids[i-1].Bytes(),
+			}
+		}
+
+		if err := sendRS.SendMessage(ctx, sendRS.Relationships[0],
+			sendPrivateMessage); err != nil {
+			t.Fatalf("Failed to send message : %s", err)
+		}
+
+		itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+		p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+		if err != nil {
+			t.Fatalf("Failed to deserialize message payload : %s", err)
+		}
+
+		privateMessage, ok := p.(*messages.PrivateMessage)
+		if !ok {
+			t.Fatalf("Wrong message type")
+		}
+
+		if privateMessage.Subject != "Sample subject" {
+			t.Fatalf("Wrong subject : got \"%s\", want \"%s\"", privateMessage.Subject,
+				"Sample subject")
+		}
+
+		if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+			flag); err != nil {
+			t.Fatalf("Failed to process message : %s", err)
+		}
+
+		txids = append(txids, *itx.Hash)
+	}
+
+	r := receiveRS.Relationships[0]
+
+	reply := receiveRS.FindMessage(ctx, r, txids[1])
+	if reply == nil {
+		t.Fatalf("Reply not found")
+	}
+
+	if !reply.ReplyTo.Equal(&txids[0]) {
+		t.Fatalf("Wrong reply to : got %s, want %s", reply.ReplyTo.String(), txids[0].String())
+	}
+
+	replies := receiveRS.GetReplies(ctx, r, txids[0])
+	if len(replies) != 1 {
+		t.Fatalf("Wrong reply count : got %d, want %d", len(replies), 1)
+	}
+
+	chain := receiveRS.GetReplyChain(ctx, r, txids[1])
+	if len(chain) != 2 {
+		t.Fatalf("Wrong reply chain length : got %d, want %d", len(chain), 2)
+	}
+
+	for i, m := range chain {
+		if !m.TxId.Equal(&txids[i]) {
+			t.Fatalf("Wrong reply chain txid %d : got %s, want %s", i, m.TxId.String(),
+				txids[i].String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := serializeHistory(&buf, receiveRS.GetHistory(ctx, r)); err != nil {
+		t.Fatalf("Failed to serialize history : %s", err)
+	}
+
+	read, err := deserializeHistory(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to deserialize history : %s", err)
+	}
+
+	if !read[1].ReplyTo.Equal(&txids[0]) {
+		t.Fatalf("Wrong deserialized reply to : got %s, want %s", read[1].ReplyTo.String(),
+			txids[0].String())
+	}
+}
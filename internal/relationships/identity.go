@@ -0,0 +1,296 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+const (
+	ProofOfIdentityTypeNone    = uint32(0)
+	ProofOfIdentityTypePaymail = uint32(1)
+	ProofOfIdentityTypeOracle  = uint32(2)
+)
+
+const (
+	// IdentityStatusNone means no proof of identity was provided.
+	IdentityStatusNone = uint8(0)
+
+	// IdentityStatusUnverified means a proof of identity was provided, but it couldn't be checked.
+	//   For example when it isn't signed, there is no verifier for the proof type, or the oracle is
+	//   unavailable.
+	IdentityStatusUnverified = uint8(1)
+
+	// IdentityStatusVerified means the proof of identity was checked and is valid.
+	IdentityStatusVerified = uint8(2)
+
+	// IdentityStatusInvalid means the proof of identity was checked and is not valid.
+	IdentityStatusInvalid = uint8(3)
+)
+
+var (
+	ErrInvalidIdentity  = errors.New("Invalid Identity")
+	ErrMissingSignature = errors.New("Missing Signature")
+	ErrUnknownOracle    = errors.New("Unknown Oracle")
+)
+
+var IdentityStatusName = map[uint8]string{
+	IdentityStatusNone:       "None",
+	IdentityStatusUnverified: "Unverified",
+	IdentityStatusVerified:   "Verified",
+	IdentityStatusInvalid:    "Invalid",
+}
+
+// IdentityVerifier verifies a proof of identity provided by a relationship member.
+type IdentityVerifier interface {
+	// Verify checks that the serialized proof of identity is valid for the public key and returns
+	//   the identity that it proves. It returns ErrInvalidIdentity when the proof is not valid.
+	Verify(ctx context.Context, publicKey bitcoin.PublicKey, proofOfIdentity []byte) (string, error)
+}
+
+// PaymailVerifier verifies messages.PaymailProofField proofs of identity using an oracle's
+//   signature.
+type PaymailVerifier struct {
+	oracle *OracleClient
+}
+
+func NewPaymailVerifier(oracle *OracleClient) *PaymailVerifier {
+	return &PaymailVerifier{oracle: oracle}
+}
+
+// Verify implements IdentityVerifier. The identity returned is the paymail handle.
+func (v *PaymailVerifier) Verify(ctx context.Context, publicKey bitcoin.PublicKey,
+	proofOfIdentity []byte) (string, error) {
+
+	proof := &messages.PaymailProofField{}
+	if err := proto.Unmarshal(proofOfIdentity, proof); err != nil {
+		return "", errors.Wrap(ErrInvalidIdentity, err.Error())
+	}
+
+	if len(proof.Handle) == 0 {
+		return "", errors.Wrap(ErrInvalidIdentity, "missing handle")
+	}
+
+	sigHash := PaymailProofSigHash(publicKey, proof.UserID, proof.Handle,
+		oracleBlockHeight(proof.OracleSignature))
+	if err := v.oracle.VerifySignature(ctx, proof.OracleSignature, sigHash); err != nil {
+		return "", err
+	}
+
+	return proof.Handle, nil
+}
+
+// OracleVerifier verifies messages.IdentityOracleProofField proofs of identity using an oracle's
+//   signature.
+type OracleVerifier struct {
+	oracle *OracleClient
+}
+
+func NewOracleVerifier(oracle *OracleClient) *OracleVerifier {
+	return &OracleVerifier{oracle: oracle}
+}
+
+// Verify implements IdentityVerifier. The identity returned is the entity name.
+func (v *OracleVerifier) Verify(ctx context.Context, publicKey bitcoin.PublicKey,
+	proofOfIdentity []byte) (string, error) {
+
+	proof := &messages.IdentityOracleProofField{}
+	if err := proto.Unmarshal(proofOfIdentity, proof); err != nil {
+		return "", errors.Wrap(ErrInvalidIdentity, err.Error())
+	}
+
+	if proof.Entity == nil || len(proof.Entity.Name) == 0 {
+		return "", errors.Wrap(ErrInvalidIdentity, "missing entity")
+	}
+
+	sigHash, err := IdentityOracleProofSigHash(publicKey, proof.UserID, proof.Entity,
+		oracleBlockHeight(proof.OracleSignature))
+	if err != nil {
+		return "", errors.Wrap(err, "sig hash")
+	}
+
+	if err := v.oracle.VerifySignature(ctx, proof.OracleSignature, sigHash); err != nil {
+		return "", err
+	}
+
+	return proof.Entity.Name, nil
+}
+
+// PaymailProofSigHash returns the hash that an oracle signs to prove a paymail handle is associated
+//   with a public key.
+func PaymailProofSigHash(publicKey bitcoin.PublicKey, userID []byte, handle string,
+	blockHeight uint32) []byte {
+
+	var buf bytes.Buffer
+	buf.Write(publicKey.Bytes())
+	buf.Write(userID)
+	buf.Write([]byte(handle))
+	binary.Write(&buf, binary.LittleEndian, blockHeight)
+
+	return bitcoin.DoubleSha256(buf.Bytes())
+}
+
+// IdentityOracleProofSigHash returns the hash that an oracle signs to prove an entity is associated
+//   with a public key.
+func IdentityOracleProofSigHash(publicKey bitcoin.PublicKey, userID []byte,
+	entity *messages.EntityField, blockHeight uint32) ([]byte, error) {
+
+	entityBytes, err := proto.Marshal(entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal entity")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(publicKey.Bytes())
+	buf.Write(userID)
+	buf.Write(entityBytes)
+	binary.Write(&buf, binary.LittleEndian, blockHeight)
+
+	return bitcoin.DoubleSha256(buf.Bytes()), nil
+}
+
+func oracleBlockHeight(signature *messages.OracleSignatureField) uint32 {
+	if signature == nil {
+		return 0
+	}
+	return signature.BlockHeight
+}
+
+// SetIdentityVerifier sets the verifier used for a proof of identity type.
+func (rs *Relationships) SetIdentityVerifier(proofType uint32, verifier IdentityVerifier) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.verifiers[proofType] = verifier
+}
+
+// DefaultProofOfIdentity returns an unsigned proof of identity for the entity in the config, or nil
+//   if no entity is configured.
+func (rs *Relationships) DefaultProofOfIdentity() (proto.Message, error) {
+	if len(rs.cfg.Entity.Name) == 0 {
+		return nil, nil
+	}
+
+	// Convert from the action entity field to the message entity field.
+	b, err := proto.Marshal(&rs.cfg.Entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal entity")
+	}
+
+	entity := &messages.EntityField{}
+	if err := proto.Unmarshal(b, entity); err != nil {
+		return nil, errors.Wrap(err, "unmarshal entity")
+	}
+
+	return &messages.IdentityOracleProofField{
+		Entity: entity,
+	}, nil
+}
+
+// verifySenderIdentity saves and verifies the proof of identity in a message from the member. The
+//   proof of identity can only be attributed when there is one sender. A message without a proof
+//   keeps the proof from an earlier message, like a pending accept.
+func (rs *Relationships) verifySenderIdentity(ctx context.Context, m *Member,
+	message *actions.Message, proofType uint32, proofOfIdentity []byte) {
+
+	if len(message.SenderIndexes) != 1 || len(proofOfIdentity) == 0 {
+		return
+	}
+
+	rs.verifyIdentity(ctx, m, proofType, proofOfIdentity)
+}
+
+// verifyIdentity saves the proof of identity to the member and verifies it.
+func (rs *Relationships) verifyIdentity(ctx context.Context, m *Member, proofType uint32,
+	proofOfIdentity []byte) {
+
+	m.ProofOfIdentityType = proofType
+	m.ProofOfIdentity = proofOfIdentity
+	m.Identity = ""
+
+	if proofType == ProofOfIdentityTypeNone && len(proofOfIdentity) == 0 {
+		m.IdentityStatus = IdentityStatusNone
+		return
+	}
+
+	rs.lock.Lock()
+	verifier, exists := rs.verifiers[proofType]
+	rs.lock.Unlock()
+
+	if !exists {
+		logger.Info(ctx, "No identity verifier for proof type %d", proofType)
+		m.IdentityStatus = IdentityStatusUnverified
+		return
+	}
+
+	name, err := verifier.Verify(ctx, m.BaseKey, proofOfIdentity)
+	if err != nil {
+		if errors.Cause(err) == ErrInvalidIdentity {
+			logger.Warn(ctx, "Invalid proof of identity : %s", err)
+			m.IdentityStatus = IdentityStatusInvalid
+			return
+		}
+
+		logger.Warn(ctx, "Failed to verify proof of identity : %s", err)
+		m.IdentityStatus = IdentityStatusUnverified
+		return
+	}
+
+	logger.Info(ctx, "Verified identity : %s", name)
+	m.Identity = name
+	m.IdentityStatus = IdentityStatusVerified
+}
+
+// createProofOfIdentity returns the proof of identity type and serialized proof to embed in a
+//   relationship message. If the proof isn't signed and an identity oracle is configured, then the
+//   oracle's signature for the public key is added.
+// proofOfIdentity needs to be nil, or a proof of identity message like
+//   messages.IdentityOracleProofField or messages.PaymailProofField
+func (rs *Relationships) createProofOfIdentity(ctx context.Context, publicKey bitcoin.PublicKey,
+	proofOfIdentity proto.Message) (uint32, []byte, error) {
+
+	if proofOfIdentity == nil {
+		return ProofOfIdentityTypeNone, nil, nil
+	}
+
+	var proofType uint32
+	var err error
+	switch proof := proofOfIdentity.(type) {
+	case *messages.IdentityOracleProofField:
+		proofType = ProofOfIdentityTypeOracle
+		if proof.OracleSignature == nil && rs.oracle != nil && proof.Entity != nil {
+			proof.OracleSignature, err = rs.oracle.SignEntity(ctx, publicKey, proof.UserID,
+				proof.Entity)
+			if err != nil {
+				return 0, nil, errors.Wrap(err, "sign entity")
+			}
+		}
+	case *messages.PaymailProofField:
+		proofType = ProofOfIdentityTypePaymail
+		if proof.OracleSignature == nil && rs.oracle != nil {
+			proof.OracleSignature, err = rs.oracle.SignPaymail(ctx, publicKey, proof.UserID,
+				proof.Handle)
+			if err != nil {
+				return 0, nil, errors.Wrap(err, "sign paymail")
+			}
+		}
+	default:
+		return 0, nil, errors.New("Unsupported proof of identity type")
+	}
+
+	b, err := proto.Marshal(proofOfIdentity)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "marshal proof of identity")
+	}
+
+	return proofType, b, nil
+}
@@ -0,0 +1,204 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestVerifyIdentity(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	oracle := newMockOracle(t)
+	defer oracle.Close()
+
+	cfg.IdentityURL = oracle.URL()
+
+	_, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiver, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	_, _, err = sendRS.InitiateRelationship(ctx, []bitcoin.PublicKey{receiver.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if receiveRS.Relationships[0].Members[0].IdentityStatus != IdentityStatusNone {
+		t.Fatalf("Wrong initiator identity status : got %s, want %s",
+			IdentityStatusName[receiveRS.Relationships[0].Members[0].IdentityStatus],
+			IdentityStatusName[IdentityStatusNone])
+	}
+
+	logger.Info(ctx, "Send pending accept with valid proof ***************************************")
+
+	handle := "test@tokenized.com"
+	signature, err := oracle.Sign(PaymailProofSigHash(receiver.PublicKey, nil, handle, 100))
+	if err != nil {
+		t.Fatalf("Failed to sign proof : %s", err)
+	}
+
+	poi := &messages.PaymailProofField{
+		Handle: handle,
+		OracleSignature: &messages.OracleSignatureField{
+			OracleURL:   oracle.URL(),
+			BlockHeight: 100,
+			Signature:   signature,
+		},
+	}
+
+	if _, err := receiveRS.SendPendingAccept(ctx, receiveRS.Relationships[0], poi); err != nil {
+		t.Fatalf("Failed to send pending accept : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	par, ok := p.(*messages.PendingAcceptRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := sendRS.ProcessPendingAcceptRelationship(ctx, itx, message, par, flag); err != nil {
+		t.Fatalf("Failed to process pending accept : %s", err)
+	}
+
+	member := sendRS.Relationships[0].Members[0]
+	if member.IdentityStatus != IdentityStatusVerified {
+		t.Fatalf("Wrong identity status : got %s, want %s",
+			IdentityStatusName[member.IdentityStatus], IdentityStatusName[IdentityStatusVerified])
+	}
+
+	if member.Identity != handle {
+		t.Fatalf("Wrong identity : got %s, want %s", member.Identity, handle)
+	}
+
+	logger.Info(ctx, "Send accept with invalid proof *********************************************")
+
+	// Signed for a different handle
+	poi.Handle = "other@tokenized.com"
+
+	if _, err := receiveRS.AcceptRelationship(ctx, receiveRS.Relationships[0], poi); err != nil {
+		t.Fatalf("Failed to accept relationship : %s", err)
+	}
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	accept, ok := p.(*messages.AcceptRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := sendRS.ProcessAcceptRelationship(ctx, itx, message, accept, flag); err != nil {
+		t.Fatalf("Failed to process accept : %s", err)
+	}
+
+	if member.IdentityStatus != IdentityStatusInvalid {
+		t.Fatalf("Wrong identity status : got %s, want %s",
+			IdentityStatusName[member.IdentityStatus], IdentityStatusName[IdentityStatusInvalid])
+	}
+
+	if len(member.Identity) != 0 {
+		t.Fatalf("Identity should be empty : %s", member.Identity)
+	}
+}
+
+func TestDefaultIdentity(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	oracle := newMockOracle(t)
+	defer oracle.Close()
+
+	cfg.IdentityURL = oracle.URL()
+
+	_, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, _, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiver, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	poi, err := sendRS.DefaultProofOfIdentity()
+	if err != nil {
+		t.Fatalf("Failed to get default proof of identity : %s", err)
+	}
+
+	if poi == nil {
+		t.Fatalf("Missing default proof of identity")
+	}
+
+	_, _, err = sendRS.InitiateRelationship(ctx, []bitcoin.PublicKey{receiver.PublicKey}, poi)
+	if err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if ir.ProofOfIdentityType != ProofOfIdentityTypeOracle {
+		t.Fatalf("Wrong POI type : got %d, want %d", ir.ProofOfIdentityType,
+			ProofOfIdentityTypeOracle)
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	member := receiveRS.Relationships[0].Members[0]
+	if member.IdentityStatus != IdentityStatusVerified {
+		t.Fatalf("Wrong identity status : got %s, want %s",
+			IdentityStatusName[member.IdentityStatus], IdentityStatusName[IdentityStatusVerified])
+	}
+
+	if member.Identity != cfg.Entity.Name {
+		t.Fatalf("Wrong identity : got %s, want %s", member.Identity, cfg.Entity.Name)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/tokenized/envelope/pkg/golang/envelope/v0"
 
@@ -105,20 +106,10 @@ func (rs *Relationships) InitiateRelationship(ctx context.Context,
 		// ChannelParties       []*ChannelPartyField
 	}
 
-	if proofOfIdentity != nil {
-		switch proofOfIdentity.(type) {
-		case *messages.IdentityOracleProofField:
-			initiate.ProofOfIdentityType = 2
-		case *messages.PaymailProofField:
-			initiate.ProofOfIdentityType = 1
-		default:
-			return bitcoin.Hash32{}, nil, errors.New("Unsupported proof of identity type")
-		}
-
-		initiate.ProofOfIdentity, err = proto.Marshal(proofOfIdentity)
-		if err != nil {
-			return bitcoin.Hash32{}, nil, errors.Wrap(err, "marshal proof of identity")
-		}
+	initiate.ProofOfIdentityType, initiate.ProofOfIdentity, err = rs.createProofOfIdentity(ctx,
+		senderKey.PublicKey(), proofOfIdentity)
+	if err != nil {
+		return bitcoin.Hash32{}, nil, errors.Wrap(err, "proof of identity")
 	}
 
 	if len(receivers) > 1 {
@@ -219,9 +210,18 @@ func (rs *Relationships) InitiateRelationship(ctx context.Context,
 	rs.Relationships = append(rs.Relationships, r)
 	rs.lock.Unlock()
 
-	if err := rs.wallet.AddIndependentKey(ctx, r.NextKey, r.KeyType, r.KeyIndex,
-		r.NextHash); err != nil {
-		return bitcoin.Hash32{}, nil, errors.Wrap(err, "add independent key")
+	// The initiation is sent from the base key, so there is no hash to move back if it is
+	//   cancelled.
+	rs.addSent(ctx, &SentMessage{
+		TxId:             r.TxId,
+		RelationshipTxId: r.TxId,
+		MessageCode:      messages.CodeInitiateRelationship,
+		Payload:          initiateBuf.Bytes(),
+		Timestamp:        uint64(time.Now().UnixNano()),
+	})
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return bitcoin.Hash32{}, nil, errors.Wrap(err, "add lookahead keys")
 	}
 
 	logger.Info(ctx, "Initiated relationship : %s", r.TxId.String())
@@ -244,6 +244,7 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 
 	hash, _ := bitcoin.NewHash32(bitcoin.Sha256(initiate.Seed))
 	keyFound := false
+	var initiator *Member // nil when we are the sender
 
 	r := &Relationship{
 		TxId:           *itx.Hash,
@@ -258,14 +259,8 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 		r.EncryptionKey = encryptionKey
 	}
 
-	if len(message.SenderIndexes) > 1 {
-		return fmt.Errorf("More than one sender not supported : %d", len(message.SenderIndexes))
-	}
-
 	// TODO Other Fields --ce
 	// initiate.Type
-	// initiate.ProofOfIdentityType
-	// initiate.ProofOfIdentity
 	// initiate.ChannelParties
 
 	if len(message.SenderIndexes) == 0 { // No sender indexes means use the first input
@@ -311,16 +306,16 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 				r.KeyIndex = ad.KeyIndex
 				keyFound = true
 
+				// Mark the key used so more keys are derived when recovering from the chain.
+				if err := rs.wallet.MarkAddress(ctx, ad); err != nil {
+					return errors.Wrap(err, "mark address")
+				}
+
 				r.NextKey, err = bitcoin.NextPublicKey(ad.PublicKey, r.NextHash)
 				if err != nil {
 					return errors.Wrap(err, "next key")
 				}
 
-				if err := rs.wallet.AddIndependentKey(ctx, r.NextKey, r.KeyType, r.KeyIndex,
-					r.NextHash); err != nil {
-					return errors.Wrap(err, "add independent key")
-				}
-
 				continue
 			}
 		}
@@ -338,12 +333,20 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 		logger.Info(ctx, "Adding member : %s",
 			bitcoin.NewAddressFromRawAddress(ra, rs.cfg.Net).String())
 
-		r.Members = append(r.Members, &Member{
+		m := &Member{
 			BaseKey:   publicKey,
 			NextHash:  *hash,
 			NextIndex: 1,
 			NextKey:   nextKey,
-		})
+		}
+
+		// The proof of identity in the initiate is for the first sender.
+		if senderIndex == message.SenderIndexes[0] {
+			rs.verifyIdentity(ctx, m, initiate.ProofOfIdentityType, initiate.ProofOfIdentity)
+			initiator = m
+		}
+
+		r.Members = append(r.Members, m)
 	}
 
 	if len(message.ReceiverIndexes) == 0 { // No receiver indexes means use the first input
@@ -382,16 +385,15 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 				r.KeyIndex = ad.KeyIndex
 				keyFound = true
 
+				if err := rs.wallet.MarkAddress(ctx, ad); err != nil {
+					return errors.Wrap(err, "mark address")
+				}
+
 				r.NextKey, err = bitcoin.NextPublicKey(publicKey, r.NextHash)
 				if err != nil {
 					return errors.Wrap(err, "next key")
 				}
 
-				if err := rs.wallet.AddIndependentKey(ctx, r.NextKey, r.KeyType, r.KeyIndex,
-					r.NextHash); err != nil {
-					return errors.Wrap(err, "add independent key")
-				}
-
 				continue
 			}
 		}
@@ -420,11 +422,32 @@ func (rs *Relationships) ProcessInitiateRelationship(ctx context.Context,
 		return errors.New("Not a member of relationship")
 	}
 
+	action := PolicyReview
+	if initiator != nil {
+		action = rs.GetPolicy(ctx).Evaluate(initiator)
+		logger.Info(ctx, "Policy for relationship %s : %s", r.TxId.String(), PolicyName[action])
+	}
+
+	if action == PolicyIgnore {
+		// Our keys for the relationship were never added, so the wallet is unchanged.
+		return nil
+	}
+
+	if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
 	rs.lock.Lock()
 	rs.Relationships = append(rs.Relationships, r)
 	rs.lock.Unlock()
 
 	logger.Info(ctx, "New relationship : %s", r.TxId.String())
 
+	if action == PolicyAccept && !rs.IsRecovering() {
+		if err := rs.autoAccept(ctx, r); err != nil {
+			return errors.Wrap(err, "auto accept")
+		}
+	}
+
 	return nil
 }
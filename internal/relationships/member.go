@@ -5,18 +5,121 @@ import (
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 )
 
+// derivedKey is a key derived from a base key and a hash in a relationship's hash chain.
+type derivedKey struct {
+	hash      bitcoin.Hash32
+	index     uint64
+	publicKey bitcoin.PublicKey
+}
+
 func (m *Member) IncrementHash() {
 	m.NextHash = bitcoin.NextHash(m.NextHash)
 	m.NextIndex++
 
 	m.NextKey, _ = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+
+	if len(m.lookahead) > 0 && m.lookahead[0].index < m.NextIndex {
+		m.lookahead = m.lookahead[1:]
+	}
+}
+
+// UseKey moves the member's hash position past the public key if it is one of the member's
+//   expected keys. Keys that are jumped over are remembered so that messages using them can still
+//   be found when they are seen late.
+// Returns true if the key is an expected key of the member.
+func (m *Member) UseKey(publicKey bitcoin.PublicKey) bool {
+	for i, k := range m.skipped {
+		if k.publicKey.Equal(publicKey) {
+			m.skipped = append(m.skipped[:i], m.skipped[i+1:]...)
+			return true
+		}
+	}
+
+	m.fillLookahead()
+
+	for _, k := range m.lookahead {
+		if k.publicKey.Equal(publicKey) {
+			m.UseIndex(k.index, k.hash)
+			return true
+		}
+	}
+
+	return false
 }
 
+// UseIndex moves the member's hash position past the key with the index, which is derived from the
+//   hash. Unlike UseKey the index can be past the lookahead window, like when it is from a sender
+//   hint. Only the most recent keys jumped over are remembered.
+func (m *Member) UseIndex(index uint64, hash bitcoin.Hash32) {
+	for i, k := range m.skipped {
+		if k.index == index {
+			m.skipped = append(m.skipped[:i], m.skipped[i+1:]...)
+			return
+		}
+	}
+
+	if index < m.NextIndex {
+		return // already used
+	}
+
+	m.fillLookahead()
+
+	var lookahead []*derivedKey
+	for _, k := range m.lookahead {
+		if k.index < index {
+			m.skipped = append(m.skipped, k)
+		} else if k.index > index {
+			lookahead = append(lookahead, k)
+		}
+	}
+
+	if len(m.lookahead) > 0 {
+		// Derive the keys jumped over that are past the lookahead window.
+		last := m.lookahead[len(m.lookahead)-1]
+		h := bitcoin.NextHash(last.hash)
+		for i := last.index + 1; i < index; i++ {
+			if i+MaxSkippedKeys >= index {
+				if pk, err := bitcoin.NextPublicKey(m.BaseKey, h); err == nil {
+					m.skipped = append(m.skipped, &derivedKey{
+						hash:      h,
+						index:     i,
+						publicKey: pk,
+					})
+				}
+			}
+			h = bitcoin.NextHash(h)
+		}
+	}
+
+	if len(m.skipped) > MaxSkippedKeys {
+		m.skipped = m.skipped[len(m.skipped)-MaxSkippedKeys:]
+	}
+
+	m.lookahead = lookahead
+	m.NextHash = bitcoin.NextHash(hash)
+	m.NextIndex = index + 1
+	m.NextKey, _ = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+	m.fillLookahead()
+}
+
+// IsExpectedKey returns true if the public key is in the member's lookahead window or is a key
+//   that was skipped over.
+func (m *Member) IsExpectedKey(publicKey bitcoin.PublicKey) bool {
+	_, _, found := m.findExpectedKey(publicKey)
+	return found
+}
+
+// FindKey returns the hash and index used to derive the public key. The lookahead window and
+//   skipped keys are checked first, then the past keys are derived from the seed.
 func (m *Member) FindKey(publicKey bitcoin.PublicKey, seed []byte) (bitcoin.Hash32, uint64, error) {
+	if h, i, found := m.findExpectedKey(publicKey); found {
+		return h, i, nil
+	}
+
 	hp, _ := bitcoin.NewHash32(bitcoin.Sha256(seed))
 	h := *hp
 
-	for i := uint64(1); i < m.NextIndex+10; i++ {
+	for i := uint64(1); i < m.NextIndex; i++ {
 		npk, err := bitcoin.NextPublicKey(m.BaseKey, h)
 		if err != nil {
 			return bitcoin.Hash32{}, 0, errors.Wrap(err, "next public key")
@@ -30,3 +133,56 @@ func (m *Member) FindKey(publicKey bitcoin.PublicKey, seed []byte) (bitcoin.Hash
 
 	return bitcoin.Hash32{}, 0, ErrKeyNotFound
 }
+
+func (m *Member) findExpectedKey(publicKey bitcoin.PublicKey) (bitcoin.Hash32, uint64, bool) {
+	m.fillLookahead()
+
+	for _, k := range m.lookahead {
+		if k.publicKey.Equal(publicKey) {
+			return k.hash, k.index, true
+		}
+	}
+
+	for _, k := range m.skipped {
+		if k.publicKey.Equal(publicKey) {
+			return k.hash, k.index, true
+		}
+	}
+
+	return bitcoin.Hash32{}, 0, false
+}
+
+// fillLookahead derives the keys in the lookahead window starting at the member's next hash. The
+//   window is rebuilt when the next hash no longer matches, like after an amendment.
+func (m *Member) fillLookahead() {
+	if len(m.lookahead) > 0 && !m.lookahead[0].hash.Equal(&m.NextHash) {
+		m.lookahead = nil
+	}
+
+	var h bitcoin.Hash32
+	var index uint64
+	if len(m.lookahead) == 0 {
+		h = m.NextHash
+		index = m.NextIndex
+	} else {
+		last := m.lookahead[len(m.lookahead)-1]
+		h = bitcoin.NextHash(last.hash)
+		index = last.index + 1
+	}
+
+	for len(m.lookahead) < LookaheadWindow {
+		pk, err := bitcoin.NextPublicKey(m.BaseKey, h)
+		if err != nil {
+			return
+		}
+
+		m.lookahead = append(m.lookahead, &derivedKey{
+			hash:      h,
+			index:     index,
+			publicKey: pk,
+		})
+
+		h = bitcoin.NextHash(h)
+		index++
+	}
+}
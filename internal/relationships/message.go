@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
+	"time"
 
 	"github.com/tokenized/envelope/pkg/golang/envelope/v0"
 
@@ -23,32 +23,138 @@ import (
 	"github.com/pkg/errors"
 )
 
+// SendMessage creates and broadcasts a message within the relationship specified.
 func (rs *Relationships) SendMessage(ctx context.Context, r *Relationship, message messages.Message) error {
+	messagePayload, err := message.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "Serialize message")
+	}
+
+	messagePayload, err = appendAttachmentHashes(message, messagePayload)
+	if err != nil {
+		return errors.Wrap(err, "append attachment hashes")
+	}
+
+	return rs.sendMessagePayload(ctx, r, message.Code(), messagePayload)
+}
+
+// sendMessagePayload checks that messages can be sent in the relationship and sends the payload.
+func (rs *Relationships) sendMessagePayload(ctx context.Context, r *Relationship,
+	messageCode uint32, messagePayload []byte) error {
+
 	logger.Info(ctx, "Creating message for relationship : %s", r.TxId.String())
 
+	if r.Closed {
+		return ErrClosed
+	}
+
 	if !r.Accepted {
 		return errors.New("Relationship not accepted")
 	}
 
-	tx := txbuilder.NewTxBuilder(rs.cfg.DustLimit, rs.cfg.FeeRate)
+	if err := rs.sendMessage(ctx, r, messageCode, messagePayload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
 
-	senderIndex := uint32(0)
+	return nil
+}
 
-	// Public message fields
-	publicMessage := &actions.Message{
-		SenderIndexes: []uint32{senderIndex},
+// sendMessage builds, funds, and broadcasts a tx containing the encrypted message payload from
+//   our next key in the relationship. It then increments the hash for the key used.
+func (rs *Relationships) sendMessage(ctx context.Context, r *Relationship, messageCode uint32,
+	messagePayload []byte) error {
+
+	// Member keys aren't used by receiving a message, so they keep receiving messages until the
+	//   member sends from them.
+	var receivers []bitcoin.PublicKey
+	if r.EncryptionType == 0 { // direct encryption
+		for _, m := range r.Members {
+			receivers = append(receivers, m.NextKey)
+		}
+	}
+
+	if _, err := rs.sendMessageToReceivers(ctx, r, receivers, messageCode,
+		messagePayload); err != nil {
+		return err
 	}
 
+	return nil
+}
+
+// sendMessageToReceivers builds, funds, and broadcasts a tx containing the message payload from
+//   our next key in the relationship. When receivers are specified the payload is directly
+//   encrypted to them and they are given outputs, otherwise it is indirectly encrypted with the
+//   relationship's encryption key. It then increments our hash. The message is remembered until
+//   its tx is confirmed so it can be sent again, and our hash moved back, if the tx is cancelled.
+// Returns the txid of the message tx.
+func (rs *Relationships) sendMessageToReceivers(ctx context.Context, r *Relationship,
+	receivers []bitcoin.PublicKey, messageCode uint32, messagePayload []byte) (*bitcoin.Hash32,
+	error) {
+
+	sent := &SentMessage{
+		RelationshipTxId: r.TxId,
+		MessageCode:      messageCode,
+		Payload:          messagePayload,
+		Timestamp:        uint64(time.Now().UnixNano()),
+		KeyUsed:          true,
+		KeyHash:          r.NextHash,
+		KeyIndex:         r.NextIndex,
+	}
+
+	tx := txbuilder.NewTxBuilder(rs.cfg.DustLimit, rs.cfg.FeeRate)
+
 	changeAddress, err := rs.wallet.GetUnusedAddress(ctx, wallet.KeyTypeInternal)
 	if err != nil {
-		return errors.Wrap(err, "get change address")
+		return nil, errors.Wrap(err, "get change address")
 	}
 
 	logger.Info(ctx, "Using change address %d : %s", changeAddress.KeyIndex,
 		bitcoin.NewAddressFromRawAddress(changeAddress.Address, rs.cfg.Net).String())
 
 	if err := tx.SetChangeAddress(changeAddress.Address, ""); err != nil {
-		return errors.Wrap(err, "set change address")
+		return nil, errors.Wrap(err, "set change address")
+	}
+
+	if err := rs.addMessageOutputs(ctx, r, tx, receivers, 0, messageCode,
+		messagePayload); err != nil {
+		return nil, errors.Wrap(err, "add message outputs")
+	}
+
+	// Dust received on our next key in a message from another member authorizes the message. It
+	//   is topped up from bitcoin funding in the same tx when it doesn't cover it, so a reply
+	//   doesn't need a funding tx.
+	logger.Info(ctx, "Adding key funding")
+	if err := rs.wallet.AddKeyFunding(ctx, r.KeyType, r.KeyIndex, r.NextHash, tx, rs.broadcastTx); err != nil {
+		return nil, errors.Wrap(err, "add key funding")
+	}
+
+	// Increment hashes
+	if err := r.IncrementHash(ctx, rs.wallet); err != nil {
+		return nil, errors.Wrap(err, "increment hash")
+	}
+
+	sent.TxId = *tx.MsgTx.TxHash()
+	rs.addSent(ctx, sent)
+
+	return tx.MsgTx.TxHash(), nil
+}
+
+// addMessageOutputs adds the receiver, flag, and message outputs for a message from our next key
+//   in the relationship to the tx. Our input is expected to be the first input, followed by one
+//   input for each co-signer.
+func (rs *Relationships) addMessageOutputs(ctx context.Context, r *Relationship,
+	tx *txbuilder.TxBuilder, receivers []bitcoin.PublicKey, coSignerCount int,
+	messageCode uint32, messagePayload []byte) error {
+
+	senderIndex := uint32(0)
+
+	// Public message fields
+	publicMessage := &actions.Message{
+		SenderIndexes: []uint32{senderIndex},
+	}
+
+	for i := 0; i < coSignerCount; i++ {
+		publicMessage.SenderIndexes = append(publicMessage.SenderIndexes, uint32(i+1))
 	}
 
 	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
@@ -67,24 +173,19 @@ func (rs *Relationships) SendMessage(ctx context.Context, r *Relationship, messa
 	logger.Info(ctx, "Sending message from address : %s",
 		bitcoin.NewAddressFromRawAddress(nextAddress, rs.cfg.Net).String())
 
-	receivers := make([]bitcoin.PublicKey, 0, len(r.Members))
-	if r.EncryptionType == 0 { // direct encryption
-		for _, m := range r.Members {
-			receivers = append(receivers, m.NextKey)
-
-			// Add output to member
-			receiverAddress, err := bitcoin.NewRawAddressPublicKey(m.NextKey)
-			if err != nil {
-				return errors.Wrap(err, "receiver address")
-			}
-			logger.Info(ctx, "Sending message to address : %s",
-				bitcoin.NewAddressFromRawAddress(receiverAddress, rs.cfg.Net).String())
+	for _, receiver := range receivers {
+		// Add output to receiver
+		receiverAddress, err := bitcoin.NewRawAddressPublicKey(receiver)
+		if err != nil {
+			return errors.Wrap(err, "receiver address")
+		}
+		logger.Info(ctx, "Sending message to address : %s",
+			bitcoin.NewAddressFromRawAddress(receiverAddress, rs.cfg.Net).String())
 
-			publicMessage.ReceiverIndexes = append(publicMessage.ReceiverIndexes,
-				uint32(len(tx.Outputs)))
-			if err := tx.AddDustOutput(receiverAddress, false); err != nil {
-				return errors.Wrap(err, "add receiver")
-			}
+		publicMessage.ReceiverIndexes = append(publicMessage.ReceiverIndexes,
+			uint32(len(tx.Outputs)))
+		if err := tx.AddDustOutput(receiverAddress, false); err != nil {
+			return errors.Wrap(err, "add receiver")
 		}
 	}
 
@@ -100,13 +201,8 @@ func (rs *Relationships) SendMessage(ctx context.Context, r *Relationship, messa
 		return errors.New("Unsupported envelope version")
 	}
 
-	messagePayload, err := message.Bytes()
-	if err != nil {
-		return errors.Wrap(err, "Serialize message")
-	}
-
 	privateMessage := &actions.Message{
-		MessageCode:    message.Code(),
+		MessageCode:    messageCode,
 		MessagePayload: messagePayload,
 	}
 
@@ -115,7 +211,16 @@ func (rs *Relationships) SendMessage(ctx context.Context, r *Relationship, messa
 		return errors.Wrap(err, "serialize private")
 	}
 
-	if r.EncryptionType == 0 { // direct encryption
+	// Tell the receivers which input is the sender and which of our keys it uses.
+	privatePayload, err = appendSenderHint(privatePayload, SenderHint{
+		SenderIndex: senderIndex,
+		KeyIndex:    r.NextIndex,
+	})
+	if err != nil {
+		return errors.Wrap(err, "append sender hint")
+	}
+
+	if len(receivers) > 0 { // direct encryption
 		if _, err := env0.AddEncryptedPayloadDirect(privatePayload, tx.MsgTx, senderIndex, nextKey,
 			receivers); err != nil {
 			return errors.Wrap(err, "add direct encrypted payload")
@@ -146,22 +251,6 @@ func (rs *Relationships) SendMessage(ctx context.Context, r *Relationship, messa
 		return errors.Wrap(err, "add message op return")
 	}
 
-	logger.Info(ctx, "Adding key funding")
-	if err := rs.wallet.AddKeyFunding(ctx, r.KeyType, r.KeyIndex, r.NextHash, tx, rs.broadcastTx); err != nil {
-		return errors.Wrap(err, "add key funding")
-	}
-
-	// Increment hashes
-	if err := r.IncrementHash(ctx, rs.wallet); err != nil {
-		return errors.Wrap(err, "increment hash")
-	}
-	if r.EncryptionType == 0 {
-		// Member keys were included in this tx, so increment them too
-		for _, m := range r.Members {
-			m.IncrementHash()
-		}
-	}
-
 	return nil
 }
 
@@ -171,7 +260,7 @@ func (rs *Relationships) ProcessPrivateMessage(ctx context.Context, itx *inspect
 	logger.Info(ctx, "Processing private message for relationship")
 
 	// Get relationship
-	r, areSender, memberIndex, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
+	r, areSender, memberIndexes, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
 	if err != nil {
 		return false, errors.Wrap(err, "get relationship")
 	}
@@ -179,13 +268,15 @@ func (rs *Relationships) ProcessPrivateMessage(ctx context.Context, itx *inspect
 		return false, ErrNotFound
 	}
 
-	if len(message.SenderIndexes) > 1 {
-		return false, fmt.Errorf("More than one sender not supported : %d", len(message.SenderIndexes))
+	if !areSender && len(memberIndexes) == 0 {
+		return false, ErrSenderNotFound
 	}
 
 	if areSender {
 		logger.Info(ctx, "We are sender")
-	} else {
+	}
+
+	for _, memberIndex := range memberIndexes {
 		ra, err := r.Members[memberIndex].BaseKey.RawAddress()
 		if err == nil {
 			logger.Info(ctx, "Message from %s",
@@ -193,9 +284,47 @@ func (rs *Relationships) ProcessPrivateMessage(ctx context.Context, itx *inspect
 		}
 	}
 
+	status, err := receiptStatus(message.MessagePayload)
+	if err != nil {
+		logger.Warn(ctx, "Invalid receipt status : %s", err)
+	}
+
+	if status != 0 {
+		if err := rs.processReceipt(ctx, r, areSender, memberIndexes, privateMessage,
+			status); err != nil {
+			return false, errors.Wrap(err, "process receipt")
+		}
+
+		return areSender && r.EncryptionType == 1, nil
+	}
+
 	if js, err := json.MarshalIndent(privateMessage, "", "    "); err == nil {
 		logger.Info(ctx, "Message contents : \n%s\n", js)
 	}
 
+	thread, err := messageThread(message.MessagePayload)
+	if err != nil {
+		logger.Warn(ctx, "Invalid message thread : %s", err)
+	}
+
+	added, err := rs.addPrivateMessageHistory(ctx, r, *itx.Hash, areSender, memberIndexes, thread,
+		privateMessage)
+	if err != nil {
+		return false, errors.Wrap(err, "add history")
+	}
+
+	if !areSender {
+		if err := rs.saveAttachments(ctx, *itx.Hash, privateMessage,
+			message.MessagePayload); err != nil {
+			logger.Warn(ctx, "Failed to save attachments : %s", err)
+		}
+
+		if added && rs.cfg.SendReceipts && r.EncryptionType != 0 && !rs.IsRecovering() {
+			if err := rs.sendReceipt(ctx, r, *itx.Hash, ReceiptDelivered); err != nil {
+				logger.Warn(ctx, "Failed to send delivered receipt : %s", err)
+			}
+		}
+	}
+
 	return areSender && r.EncryptionType == 1, nil
 }
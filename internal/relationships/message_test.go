@@ -0,0 +1,278 @@
+package relationships
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+)
+
+func TestReplyFromDust(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := receiveRS.Relationships[0]
+	nextIndex := r.NextIndex
+
+	if err := sendRS.SendMessage(ctx, sendRS.Relationships[0],
+		&messages.PrivateMessage{Subject: "Question"}); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	messageTx := sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1]
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	if _, _, _, err := receiveRS.GetRelationshipForTx(ctx, itx, message, flag); err != nil {
+		t.Fatalf("Failed to get relationship : %s", err)
+	}
+
+	// Receiving the message doesn't use our next key.
+	if r.NextIndex != nextIndex {
+		t.Fatalf("Next key should not be used : got index %d, want %d", r.NextIndex, nextIndex)
+	}
+
+	// Receive the dust output on our next key.
+	if err := receiveWallet.ProcessUTXOs(ctx, messageTx, true); err != nil {
+		t.Fatalf("Failed to process utxos : %s", err)
+	}
+
+	if err := receiveWallet.FinalizeUTXOs(ctx, messageTx); err != nil {
+		t.Fatalf("Failed to finalize utxos : %s", err)
+	}
+
+	dust, err := receiveWallet.GetKeyHashUTXOs(ctx, r.KeyType, r.KeyIndex, r.NextHash)
+	if err != nil {
+		t.Fatalf("Failed to get next key utxos : %s", err)
+	}
+
+	if len(dust) != 1 {
+		t.Fatalf("Wrong received dust count : got %d, want %d", len(dust), 1)
+	}
+
+	receiveBroadcastTx.Msgs = nil
+	if err := receiveRS.SendMessage(ctx, r, &messages.PrivateMessage{Subject: "Answer"}); err != nil {
+		t.Fatalf("Failed to send reply : %s", err)
+	}
+
+	// The dust authorizes the reply and is topped up in the same tx.
+	if len(receiveBroadcastTx.Msgs) != 1 {
+		t.Fatalf("Wrong reply tx count : got %d, want %d", len(receiveBroadcastTx.Msgs), 1)
+	}
+
+	replyTx := receiveBroadcastTx.Msgs[0]
+	if !replyTx.TxIn[0].PreviousOutPoint.Hash.Equal(&dust[0].UTXO.Hash) ||
+		replyTx.TxIn[0].PreviousOutPoint.Index != dust[0].UTXO.Index {
+		t.Fatalf("Reply not authorized by received dust")
+	}
+
+	if len(replyTx.TxIn) < 2 {
+		t.Fatalf("Reply dust not topped up")
+	}
+
+	if r.NextIndex != nextIndex+1 {
+		t.Fatalf("Wrong next index : got %d, want %d", r.NextIndex, nextIndex+1)
+	}
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	_, areSender, memberIndexes, err := sendRS.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		t.Fatalf("Failed to get relationship : %s", err)
+	}
+
+	if areSender || len(memberIndexes) != 1 {
+		t.Fatalf("Reply sender not found")
+	}
+}
+
+func TestFundingBroadcastFailure(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, err := tests.NewMockWallet(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create mock wallet : %s", err)
+	}
+
+	sendBroadcastTx := &failingBroadcaster{
+		MockBroadcaster: tests.NewMockBroadcaster(cfg),
+		fail:            map[int]bool{1: true, 3: true},
+	}
+
+	sendRS, err := NewRelationships(cfg, sendWallet, sendBroadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to create relationships : %s", err)
+	}
+
+	receiveWallet, err := tests.NewMockWallet(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create mock wallet : %s", err)
+	}
+
+	receiveAddress, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationships address : %s", err)
+	}
+
+	utxos, err := sendWallet.GetBitcoinUTXOs(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get bitcoin utxos : %s", err)
+	}
+	utxoCount := len(utxos)
+
+	// The funding tx fails, so nothing is broadcast and the funding is released.
+	poi := &messages.IdentityOracleProofField{}
+	if _, _, err := sendRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveAddress.PublicKey}, poi); err == nil {
+		t.Fatalf("Initiate should fail when the funding tx fails")
+	}
+
+	if len(sendBroadcastTx.Msgs) != 0 {
+		t.Fatalf("Wrong broadcast count : got %d, want %d", len(sendBroadcastTx.Msgs), 0)
+	}
+
+	if len(sendWallet.GetOutbox(ctx)) != 0 {
+		t.Fatalf("Wrong outbox size : got %d, want %d", len(sendWallet.GetOutbox(ctx)), 0)
+	}
+
+	utxos, err = sendWallet.GetBitcoinUTXOs(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get bitcoin utxos : %s", err)
+	}
+
+	if len(utxos) != utxoCount {
+		t.Fatalf("Wrong bitcoin utxo count : got %d, want %d", len(utxos), utxoCount)
+	}
+
+	// The funding tx goes out, but the initiation fails, so it is left in the outbox and reported
+	//   as queued instead of sent.
+	start := uint64(time.Now().UnixNano())
+	if _, _, err := sendRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveAddress.PublicKey}, poi); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	if len(sendBroadcastTx.Msgs) != 1 {
+		t.Fatalf("Wrong broadcast count : got %d, want %d", len(sendBroadcastTx.Msgs), 1)
+	}
+
+	outbox := sendWallet.GetOutbox(ctx)
+	if len(outbox) != 2 {
+		t.Fatalf("Wrong outbox size : got %d, want %d", len(outbox), 2)
+	}
+
+	fundingTxId := outbox[0].Tx.TxHash()
+	messageTxId := outbox[1].Tx.TxHash()
+
+	if outbox[0].State != wallet.OutboxBroadcast {
+		t.Fatalf("Wrong funding tx state : got %s, want %s",
+			wallet.OutboxStateName[outbox[0].State], wallet.OutboxStateName[wallet.OutboxBroadcast])
+	}
+
+	if outbox[0].Funds == nil || !outbox[0].Funds.Equal(messageTxId) {
+		t.Fatalf("Funding tx should fund the initiation")
+	}
+
+	queued := sendWallet.QueuedTxs(start)
+	if len(queued) != 1 || !queued[0].Equal(messageTxId) {
+		t.Fatalf("Initiation should be queued : got %d queued txs", len(queued))
+	}
+
+	if outbox[1].State != wallet.OutboxBuilt {
+		t.Fatalf("Wrong initiation state : got %s, want %s",
+			wallet.OutboxStateName[outbox[1].State], wallet.OutboxStateName[wallet.OutboxBuilt])
+	}
+
+	if _, err := sendWallet.RetryOutbox(ctx, sendBroadcastTx); err != nil {
+		t.Fatalf("Failed to retry outbox : %s", err)
+	}
+
+	outbox = sendWallet.GetOutbox(ctx)
+	if outbox[1].State != wallet.OutboxBroadcast {
+		t.Fatalf("Wrong initiation state : got %s, want %s",
+			wallet.OutboxStateName[outbox[1].State], wallet.OutboxStateName[wallet.OutboxBroadcast])
+	}
+
+	if len(sendWallet.QueuedTxs(start)) != 0 {
+		t.Fatalf("Initiation should not be queued after it is broadcast")
+	}
+
+	messageTx := sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1]
+	if !messageTx.TxHash().Equal(messageTxId) {
+		t.Fatalf("Initiation not broadcast")
+	}
+
+	if !messageTx.TxIn[0].PreviousOutPoint.Hash.Equal(fundingTxId) {
+		t.Fatalf("Initiation should spend the funding tx")
+	}
+
+	// Txs are broadcast again until they are safe.
+	if sendWallet.OutboxPending() != 2 {
+		t.Fatalf("Wrong pending count : got %d, want %d", sendWallet.OutboxPending(), 2)
+	}
+
+	sendWallet.SetOutboxState(ctx, *fundingTxId, wallet.OutboxSafe)
+
+	if sendWallet.OutboxPending() != 1 {
+		t.Fatalf("Wrong pending count : got %d, want %d", sendWallet.OutboxPending(), 1)
+	}
+
+	state, inOutbox := sendWallet.OutboxState(*messageTxId)
+	if !inOutbox || state != wallet.OutboxBroadcast {
+		t.Fatalf("Wrong initiation state : got %s, want %s", wallet.OutboxStateName[state],
+			wallet.OutboxStateName[wallet.OutboxBroadcast])
+	}
+
+	// A tx that still isn't safe after the maximum broadcasts is cancelled on the next retry.
+	for {
+		outbox = sendWallet.GetOutbox(ctx)
+		if outbox[1].Attempts >= wallet.MaxBroadcastAttempts {
+			break
+		}
+
+		cancelled, err := sendWallet.RetryOutbox(ctx, sendBroadcastTx)
+		if err != nil {
+			t.Fatalf("Failed to retry outbox : %s", err)
+		}
+
+		if len(cancelled) != 0 {
+			t.Fatalf("Wrong cancelled count : got %d, want %d", len(cancelled), 0)
+		}
+	}
+
+	cancelled, err := sendWallet.RetryOutbox(ctx, sendBroadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to retry outbox : %s", err)
+	}
+
+	if len(cancelled) != 1 || !cancelled[0].Equal(messageTxId) {
+		t.Fatalf("Initiation should be cancelled : got %d cancelled txs", len(cancelled))
+	}
+
+	state, _ = sendWallet.OutboxState(*messageTxId)
+	if state != wallet.OutboxCancelled {
+		t.Fatalf("Wrong initiation state : got %s, want %s", wallet.OutboxStateName[state],
+			wallet.OutboxStateName[wallet.OutboxCancelled])
+	}
+
+	if sendWallet.OutboxPending() != 0 {
+		t.Fatalf("Wrong pending count : got %d, want %d", sendWallet.OutboxPending(), 0)
+	}
+
+	// A cancelled tx doesn't change state again.
+	if sendWallet.SetOutboxState(ctx, *messageTxId, wallet.OutboxConfirmed) {
+		t.Fatalf("Cancelled tx state should not change")
+	}
+}
@@ -25,8 +25,24 @@ type Relationship struct {
 	Accepted       bool
 	Members        []*Member
 
+	// PendingAccepted is true when we have sent a pending accept, but not a full accept.
+	PendingAccepted bool
+
+	// Closed is true when we have left the relationship or all other members have left. No more
+	//   messages are sent or received, but the history is kept.
+	Closed bool
+
+	// Declined is true when we have declined the relationship instead of accepting it.
+	Declined bool
+
+	// Threads are the sub-conversations started within the relationship.
+	Threads []*Thread
+
 	// Not serialized
 	NextKey bitcoin.PublicKey
+
+	// Hashes in the lookahead window whose keys have been added to the wallet.
+	lookahead []bitcoin.Hash32
 }
 
 // Member represents a member of a relationship.
@@ -40,13 +56,37 @@ type Member struct {
 
 	Accepted bool
 
+	// PendingAccepted is true when the member has sent a pending accept, but not a full accept.
+	PendingAccepted bool
+
+	// Proof of identity provided by the member in a pending accept or accept.
+	ProofOfIdentityType uint32
+	ProofOfIdentity     []byte
+
+	// Identity proven by the proof of identity, like a paymail handle or entity name, and the
+	//   result of verifying it.
+	Identity       string
+	IdentityStatus uint8
+
+	// Closed is true when the relationship has been closed and the member's keys are no longer
+	//   followed.
+	Closed bool
+
+	// Declined is true when the member has declined the relationship instead of accepting it.
+	Declined bool
+
 	// Not serialized
 	NextKey bitcoin.PublicKey
+
+	// Keys derived for the lookahead window starting at NextIndex, and keys before NextIndex that
+	//   were jumped over when a later key was seen first.
+	lookahead []*derivedKey
+	skipped   []*derivedKey
 }
 
 func (m Member) Serialize(buf *bytes.Buffer) error {
 	// Version
-	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, uint8(4)); err != nil {
 		return errors.Wrap(err, "version")
 	}
 
@@ -66,6 +106,40 @@ func (m Member) Serialize(buf *bytes.Buffer) error {
 		return errors.Wrap(err, "accepted")
 	}
 
+	if err := binary.Write(buf, binary.LittleEndian, m.PendingAccepted); err != nil {
+		return errors.Wrap(err, "pending accepted")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.ProofOfIdentityType); err != nil {
+		return errors.Wrap(err, "proof of identity type")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(m.ProofOfIdentity))); err != nil {
+		return errors.Wrap(err, "proof of identity size")
+	}
+	if _, err := buf.Write(m.ProofOfIdentity); err != nil {
+		return errors.Wrap(err, "proof of identity")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(m.Identity))); err != nil {
+		return errors.Wrap(err, "identity size")
+	}
+	if _, err := buf.Write([]byte(m.Identity)); err != nil {
+		return errors.Wrap(err, "identity")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.IdentityStatus); err != nil {
+		return errors.Wrap(err, "identity status")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Closed); err != nil {
+		return errors.Wrap(err, "closed")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Declined); err != nil {
+		return errors.Wrap(err, "declined")
+	}
+
 	return nil
 }
 
@@ -75,7 +149,7 @@ func (m *Member) Deserialize(buf *bytes.Reader) error {
 		return errors.Wrap(err, "version")
 	}
 
-	if version != 0 {
+	if version > 4 {
 		return fmt.Errorf("Unsupported version : %d", version)
 	}
 
@@ -95,6 +169,53 @@ func (m *Member) Deserialize(buf *bytes.Reader) error {
 		return errors.Wrap(err, "accepted")
 	}
 
+	if version >= 1 {
+		if err := binary.Read(buf, binary.LittleEndian, &m.PendingAccepted); err != nil {
+			return errors.Wrap(err, "pending accepted")
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &m.ProofOfIdentityType); err != nil {
+			return errors.Wrap(err, "proof of identity type")
+		}
+
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return errors.Wrap(err, "proof of identity size")
+		}
+		m.ProofOfIdentity = make([]byte, size)
+		if _, err := buf.Read(m.ProofOfIdentity); err != nil {
+			return errors.Wrap(err, "proof of identity")
+		}
+	}
+
+	if version >= 2 {
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return errors.Wrap(err, "identity size")
+		}
+		identity := make([]byte, size)
+		if _, err := buf.Read(identity); err != nil {
+			return errors.Wrap(err, "identity")
+		}
+		m.Identity = string(identity)
+
+		if err := binary.Read(buf, binary.LittleEndian, &m.IdentityStatus); err != nil {
+			return errors.Wrap(err, "identity status")
+		}
+	}
+
+	if version >= 3 {
+		if err := binary.Read(buf, binary.LittleEndian, &m.Closed); err != nil {
+			return errors.Wrap(err, "closed")
+		}
+	}
+
+	if version >= 4 {
+		if err := binary.Read(buf, binary.LittleEndian, &m.Declined); err != nil {
+			return errors.Wrap(err, "declined")
+		}
+	}
+
 	var err error
 	m.NextKey, err = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
 	if err != nil {
@@ -106,7 +227,7 @@ func (m *Member) Deserialize(buf *bytes.Reader) error {
 
 func (r Relationship) Serialize(buf *bytes.Buffer) error {
 	// Version
-	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, uint8(4)); err != nil {
 		return errors.Wrap(err, "version")
 	}
 
@@ -165,6 +286,27 @@ func (r Relationship) Serialize(buf *bytes.Buffer) error {
 		}
 	}
 
+	if err := binary.Write(buf, binary.LittleEndian, r.PendingAccepted); err != nil {
+		return errors.Wrap(err, "pending accepted")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, r.Closed); err != nil {
+		return errors.Wrap(err, "closed")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, r.Declined); err != nil {
+		return errors.Wrap(err, "declined")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(r.Threads))); err != nil {
+		return errors.Wrap(err, "threads size")
+	}
+	for _, t := range r.Threads {
+		if err := t.Serialize(buf); err != nil {
+			return errors.Wrap(err, "thread")
+		}
+	}
+
 	return nil
 }
 
@@ -174,7 +316,7 @@ func (r *Relationship) Deserialize(buf *bytes.Reader) error {
 		return errors.Wrap(err, "version")
 	}
 
-	if version != 0 {
+	if version > 4 {
 		return fmt.Errorf("Unsupported version : %d", version)
 	}
 
@@ -241,6 +383,40 @@ func (r *Relationship) Deserialize(buf *bytes.Reader) error {
 		r.Members = append(r.Members, &m)
 	}
 
+	if version >= 1 {
+		if err := binary.Read(buf, binary.LittleEndian, &r.PendingAccepted); err != nil {
+			return errors.Wrap(err, "pending accepted")
+		}
+	}
+
+	if version >= 2 {
+		if err := binary.Read(buf, binary.LittleEndian, &r.Closed); err != nil {
+			return errors.Wrap(err, "closed")
+		}
+	}
+
+	if version >= 3 {
+		if err := binary.Read(buf, binary.LittleEndian, &r.Declined); err != nil {
+			return errors.Wrap(err, "declined")
+		}
+	}
+
+	if version >= 4 {
+		var count uint32
+		if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+			return errors.Wrap(err, "threads size")
+		}
+		r.Threads = make([]*Thread, 0, count)
+		for i := uint32(0); i < count; i++ {
+			var t Thread
+			if err := t.Deserialize(buf); err != nil {
+				return errors.Wrap(err, "thread")
+			}
+
+			r.Threads = append(r.Threads, &t)
+		}
+	}
+
 	return nil
 }
 
@@ -288,3 +464,376 @@ func (rs *Relationships) Deserialize(buf *bytes.Reader) error {
 
 	return nil
 }
+
+// Thread is a sub-conversation within a relationship. It is identified by the txid of the
+//   InitiateThread message that started it.
+type Thread struct {
+	TxId bitcoin.Hash32
+	Name string
+	Flag []byte
+	Seed []byte
+}
+
+func (t Thread) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := t.TxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(t.Name))); err != nil {
+		return errors.Wrap(err, "name size")
+	}
+	if _, err := buf.Write([]byte(t.Name)); err != nil {
+		return errors.Wrap(err, "name")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(t.Flag))); err != nil {
+		return errors.Wrap(err, "flag size")
+	}
+	if _, err := buf.Write(t.Flag); err != nil {
+		return errors.Wrap(err, "flag")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(t.Seed))); err != nil {
+		return errors.Wrap(err, "seed size")
+	}
+	if _, err := buf.Write(t.Seed); err != nil {
+		return errors.Wrap(err, "seed")
+	}
+
+	return nil
+}
+
+func (t *Thread) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := t.TxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	var nameSize uint32
+	if err := binary.Read(buf, binary.LittleEndian, &nameSize); err != nil {
+		return errors.Wrap(err, "name size")
+	}
+	name := make([]byte, nameSize)
+	if _, err := buf.Read(name); err != nil {
+		return errors.Wrap(err, "name")
+	}
+	t.Name = string(name)
+
+	var size uint16
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return errors.Wrap(err, "flag size")
+	}
+	t.Flag = make([]byte, size)
+	if _, err := buf.Read(t.Flag); err != nil {
+		return errors.Wrap(err, "flag")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return errors.Wrap(err, "seed size")
+	}
+	t.Seed = make([]byte, size)
+	if _, err := buf.Read(t.Seed); err != nil {
+		return errors.Wrap(err, "seed")
+	}
+
+	return nil
+}
+
+const (
+	DirectionIncoming = uint8(0)
+	DirectionOutgoing = uint8(1)
+
+	// ReceiptDelivered means the member's daemon processed and stored the message.
+	ReceiptDelivered = uint8(1)
+
+	// ReceiptRead means the member read the message.
+	ReceiptRead = uint8(2)
+)
+
+// Message is a message within a relationship, saved in the relationship's history.
+type Message struct {
+	TxId bitcoin.Hash32
+
+	// Direction is DirectionIncoming for messages from other members and DirectionOutgoing for
+	//   messages we sent.
+	Direction uint8
+
+	// MemberIndex is the index of the member that sent an incoming message.
+	MemberIndex uint32
+
+	// Timestamp is the time of the message in nanoseconds since the unix epoch. It is the time in
+	//   the message when provided, otherwise the time the message was seen.
+	Timestamp uint64
+
+	Confirmed bool
+
+	// MessageCode and Payload are the decrypted message.
+	MessageCode uint32
+	Payload     []byte
+
+	// Thread is the txid of the thread the message is in. It is zero when the message isn't in a
+	//   thread.
+	Thread bitcoin.Hash32
+
+	// ReplyTo is the txid of the message this message replies to. It is zero when the message isn't
+	//   a reply.
+	ReplyTo bitcoin.Hash32
+
+	// Receipts contains the delivered and read state of each member for messages we sent.
+	Receipts []*Receipt
+
+	// Failed is true when the tx of a message we sent was cancelled, like when it was double
+	//   spent, so the message wasn't delivered.
+	Failed bool
+}
+
+// Receipt is the delivered or read state of a message for a member.
+type Receipt struct {
+	MemberIndex uint32
+	Status      uint8 // ReceiptDelivered or ReceiptRead
+	Timestamp   uint64
+}
+
+func (m Message) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(4)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := m.TxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Direction); err != nil {
+		return errors.Wrap(err, "direction")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.MemberIndex); err != nil {
+		return errors.Wrap(err, "member index")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Confirmed); err != nil {
+		return errors.Wrap(err, "confirmed")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.MessageCode); err != nil {
+		return errors.Wrap(err, "message code")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(m.Payload))); err != nil {
+		return errors.Wrap(err, "payload size")
+	}
+	if _, err := buf.Write(m.Payload); err != nil {
+		return errors.Wrap(err, "payload")
+	}
+
+	if err := m.Thread.Serialize(buf); err != nil {
+		return errors.Wrap(err, "thread")
+	}
+
+	if err := m.ReplyTo.Serialize(buf); err != nil {
+		return errors.Wrap(err, "reply to")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(m.Receipts))); err != nil {
+		return errors.Wrap(err, "receipts size")
+	}
+	for i, receipt := range m.Receipts {
+		if err := receipt.Serialize(buf); err != nil {
+			return errors.Wrapf(err, "receipt %d", i)
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, m.Failed); err != nil {
+		return errors.Wrap(err, "failed")
+	}
+
+	return nil
+}
+
+func (m *Message) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version > 4 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := m.TxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &m.Direction); err != nil {
+		return errors.Wrap(err, "direction")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &m.MemberIndex); err != nil {
+		return errors.Wrap(err, "member index")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &m.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &m.Confirmed); err != nil {
+		return errors.Wrap(err, "confirmed")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &m.MessageCode); err != nil {
+		return errors.Wrap(err, "message code")
+	}
+
+	var size uint32
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return errors.Wrap(err, "payload size")
+	}
+	m.Payload = make([]byte, size)
+	if _, err := buf.Read(m.Payload); err != nil {
+		return errors.Wrap(err, "payload")
+	}
+
+	if version >= 1 {
+		if err := m.Thread.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "thread")
+		}
+	}
+
+	if version >= 2 {
+		if err := m.ReplyTo.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "reply to")
+		}
+	}
+
+	if version >= 3 {
+		var count uint32
+		if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+			return errors.Wrap(err, "receipts size")
+		}
+		m.Receipts = make([]*Receipt, 0, count)
+		for i := uint32(0); i < count; i++ {
+			var receipt Receipt
+			if err := receipt.Deserialize(buf); err != nil {
+				return errors.Wrapf(err, "receipt %d", i)
+			}
+			m.Receipts = append(m.Receipts, &receipt)
+		}
+	}
+
+	if version >= 4 {
+		if err := binary.Read(buf, binary.LittleEndian, &m.Failed); err != nil {
+			return errors.Wrap(err, "failed")
+		}
+	}
+
+	return nil
+}
+
+func (r Receipt) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, r.MemberIndex); err != nil {
+		return errors.Wrap(err, "member index")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return errors.Wrap(err, "status")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, r.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	return nil
+}
+
+func (r *Receipt) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &r.MemberIndex); err != nil {
+		return errors.Wrap(err, "member index")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &r.Status); err != nil {
+		return errors.Wrap(err, "status")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &r.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	return nil
+}
+
+func serializeHistory(buf *bytes.Buffer, list []*Message) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint64(len(list))); err != nil {
+		return errors.Wrap(err, "messages size")
+	}
+	for _, m := range list {
+		if err := m.Serialize(buf); err != nil {
+			return errors.Wrap(err, "message")
+		}
+	}
+
+	return nil
+}
+
+func deserializeHistory(buf *bytes.Reader) ([]*Message, error) {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return nil, errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return nil, fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	var count uint64
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return nil, errors.Wrap(err, "messages size")
+	}
+	result := make([]*Message, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var m Message
+		if err := m.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "message")
+		}
+
+		result = append(result, &m)
+	}
+
+	return result, nil
+}
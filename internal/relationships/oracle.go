@@ -0,0 +1,219 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+const (
+	// OracleIdPath is the path, relative to the oracle's URL, that returns the oracle's public key.
+	OracleIdPath = "/oracle/id"
+
+	// OracleSignPaymailPath is the path, relative to the oracle's URL, that returns an oracle
+	//   signature for a paymail handle and public key.
+	OracleSignPaymailPath = "/identity/paymail"
+
+	// OracleSignEntityPath is the path, relative to the oracle's URL, that returns an oracle
+	//   signature for an entity and public key.
+	OracleSignEntityPath = "/identity/entity"
+)
+
+// OracleIdResponse is the response from an identity oracle's id endpoint.
+type OracleIdResponse struct {
+	PublicKey string `json:"public_key"`
+}
+
+// OracleSignPaymailRequest requests an oracle signature proving that a paymail handle is
+//   associated with a public key.
+type OracleSignPaymailRequest struct {
+	PublicKey string `json:"public_key"`
+	UserID    []byte `json:"user_id,omitempty"`
+	Handle    string `json:"handle"`
+}
+
+// OracleSignEntityRequest requests an oracle signature proving that an entity is associated with a
+//   public key. Entity is the protobuf encoded messages.EntityField.
+type OracleSignEntityRequest struct {
+	PublicKey string `json:"public_key"`
+	UserID    []byte `json:"user_id,omitempty"`
+	Entity    []byte `json:"entity"`
+}
+
+// OracleSignatureResponse is the response to a sign request.
+type OracleSignatureResponse struct {
+	BlockHeight uint32 `json:"block_height"`
+	Signature   []byte `json:"signature"`
+}
+
+// OracleClient communicates with an identity oracle to get signatures for proofs of identity and
+//   to retrieve the oracle's public key so the signatures in proofs of identity can be verified.
+type OracleClient struct {
+	URL string
+
+	client    *http.Client
+	publicKey *bitcoin.PublicKey
+	lock      sync.Mutex
+}
+
+func NewOracleClient(url string) *OracleClient {
+	return &OracleClient{
+		URL:    strings.TrimRight(url, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetPublicKey returns the oracle's public key, requesting it from the oracle the first time.
+func (o *OracleClient) GetPublicKey(ctx context.Context) (bitcoin.PublicKey, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.publicKey != nil {
+		return *o.publicKey, nil
+	}
+
+	logger.Info(ctx, "Requesting identity oracle public key : %s", o.URL)
+
+	var response OracleIdResponse
+	if err := o.request(http.MethodGet, OracleIdPath, nil, &response); err != nil {
+		return bitcoin.PublicKey{}, errors.Wrap(err, "request")
+	}
+
+	publicKey, err := bitcoin.PublicKeyFromStr(response.PublicKey)
+	if err != nil {
+		return bitcoin.PublicKey{}, errors.Wrap(err, "public key")
+	}
+
+	o.publicKey = &publicKey
+	return publicKey, nil
+}
+
+// SignPaymail requests the oracle's signature proving the paymail handle is associated with the
+//   public key.
+func (o *OracleClient) SignPaymail(ctx context.Context, publicKey bitcoin.PublicKey,
+	userID []byte, handle string) (*messages.OracleSignatureField, error) {
+
+	logger.Info(ctx, "Requesting identity oracle signature for paymail : %s", handle)
+
+	request := &OracleSignPaymailRequest{
+		PublicKey: publicKey.String(),
+		UserID:    userID,
+		Handle:    handle,
+	}
+
+	var response OracleSignatureResponse
+	if err := o.request(http.MethodPost, OracleSignPaymailPath, request, &response); err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+
+	return o.signatureField(response), nil
+}
+
+// SignEntity requests the oracle's signature proving the entity is associated with the public key.
+func (o *OracleClient) SignEntity(ctx context.Context, publicKey bitcoin.PublicKey,
+	userID []byte, entity *messages.EntityField) (*messages.OracleSignatureField, error) {
+
+	logger.Info(ctx, "Requesting identity oracle signature for entity : %s", entity.Name)
+
+	entityBytes, err := proto.Marshal(entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal entity")
+	}
+
+	request := &OracleSignEntityRequest{
+		PublicKey: publicKey.String(),
+		UserID:    userID,
+		Entity:    entityBytes,
+	}
+
+	var response OracleSignatureResponse
+	if err := o.request(http.MethodPost, OracleSignEntityPath, request, &response); err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+
+	return o.signatureField(response), nil
+}
+
+// VerifySignature checks that the oracle signature is from this oracle and that it signs sigHash.
+// Returns ErrMissingSignature if there is no signature and ErrInvalidIdentity if the signature is
+//   not valid.
+func (o *OracleClient) VerifySignature(ctx context.Context,
+	signature *messages.OracleSignatureField, sigHash []byte) error {
+
+	if signature == nil || len(signature.Signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	if len(signature.OracleURL) > 0 && strings.TrimRight(signature.OracleURL, "/") != o.URL {
+		return errors.Wrap(ErrUnknownOracle, signature.OracleURL)
+	}
+
+	publicKey, err := o.GetPublicKey(ctx)
+	if err != nil {
+		return errors.Wrap(err, "oracle public key")
+	}
+
+	sig, err := bitcoin.SignatureFromBytes(signature.Signature)
+	if err != nil {
+		return errors.Wrap(ErrInvalidIdentity, "parse signature")
+	}
+
+	if !sig.Verify(sigHash, publicKey) {
+		return errors.Wrap(ErrInvalidIdentity, "signature")
+	}
+
+	return nil
+}
+
+func (o *OracleClient) signatureField(response OracleSignatureResponse) *messages.OracleSignatureField {
+	return &messages.OracleSignatureField{
+		OracleURL:   o.URL,
+		BlockHeight: response.BlockHeight,
+		Signature:   response.Signature,
+	}
+}
+
+// request sends a request to the oracle and decodes the JSON response.
+func (o *OracleClient) request(method, path string, request, response interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return errors.Wrap(err, "encode request")
+		}
+	}
+
+	httpRequest, err := http.NewRequest(method, o.URL+path, &body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := o.client.Do(httpRequest)
+	if err != nil {
+		return errors.Wrap(err, "http")
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %d", httpResponse.StatusCode)
+	}
+
+	if err := json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return errors.Wrap(err, "decode response")
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/inspector"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// SendPendingAccept creates and broadcasts a PendingAcceptRelationship message corresponding to the
+//   relationship specified. It provides identity information to the other members before formally
+//   accepting the relationship.
+// proofOfIdentity needs to be nil, or a proof of identity message like
+//   messages.IdentityOracleProofField or messages.PaymailProofField
+func (rs *Relationships) SendPendingAccept(ctx context.Context, r *Relationship,
+	proofOfIdentity proto.Message) (*messages.PendingAcceptRelationship, error) {
+
+	logger.Info(ctx, "Creating pending accept for relationship : %s", r.TxId.String())
+
+	if r.Accepted {
+		return nil, errors.New("Already accepted")
+	}
+
+	if r.Declined {
+		return nil, errors.New("Already declined")
+	}
+
+	// Private message fields
+	pending := &messages.PendingAcceptRelationship{}
+
+	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "get key")
+	}
+
+	pending.ProofOfIdentityType, pending.ProofOfIdentity, err = rs.createProofOfIdentity(ctx,
+		baseKey.PublicKey(), proofOfIdentity)
+	if err != nil {
+		return nil, errors.Wrap(err, "proof of identity")
+	}
+
+	var pendingBuf bytes.Buffer
+	if err := pending.Serialize(&pendingBuf); err != nil {
+		return nil, errors.Wrap(err, "serialize pending accept")
+	}
+
+	if err := rs.sendMessage(ctx, r, messages.CodePendingAcceptRelationship,
+		pendingBuf.Bytes()); err != nil {
+		return nil, errors.Wrap(err, "send message")
+	}
+
+	r.PendingAccepted = true
+
+	return pending, nil
+}
+
+func (rs *Relationships) ProcessPendingAcceptRelationship(ctx context.Context,
+	itx *inspector.Transaction, message *actions.Message,
+	pending *messages.PendingAcceptRelationship, flag []byte) (bool, error) {
+
+	logger.Info(ctx, "Processing pending accept for relationship")
+
+	// Get relationship
+	r, areSender, memberIndexes, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		return false, errors.Wrap(err, "get relationship")
+	}
+	if r == nil {
+		return false, ErrNotFound
+	}
+
+	if !areSender && len(memberIndexes) == 0 {
+		return false, ErrSenderNotFound
+	}
+
+	if areSender {
+		logger.Info(ctx, "Pending accepted relationship : %s", r.TxId.String())
+		if !r.Accepted {
+			r.PendingAccepted = true
+		}
+	}
+
+	for _, memberIndex := range memberIndexes {
+		m := r.Members[memberIndex]
+
+		ra, err := m.BaseKey.RawAddress()
+		if err == nil {
+			logger.Info(ctx, "Relationship pending accepted by %s : %s",
+				bitcoin.NewAddressFromRawAddress(ra, rs.cfg.Net).String(), r.TxId.String())
+		}
+
+		if !m.Accepted {
+			m.PendingAccepted = true
+		}
+
+		rs.verifySenderIdentity(ctx, m, message, pending.ProofOfIdentityType,
+			pending.ProofOfIdentity)
+	}
+
+	return areSender && r.EncryptionType == 1, nil
+}
@@ -0,0 +1,104 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestPendingAccept(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	_, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiver, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	_, _, err = sendRS.InitiateRelationship(ctx, []bitcoin.PublicKey{receiver.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	logger.Info(ctx, "Send pending accept ********************************************************")
+
+	poi := &messages.PaymailProofField{
+		Handle: "test@tokenized.com",
+	}
+
+	originalPAR, err := receiveRS.SendPendingAccept(ctx, receiveRS.Relationships[0], poi)
+	if err != nil {
+		t.Fatalf("Failed to send pending accept : %s", err)
+	}
+
+	if !receiveRS.Relationships[0].PendingAccepted {
+		t.Fatalf("Relationship not marked pending accepted")
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	if message.MessageCode != messages.CodePendingAcceptRelationship {
+		t.Fatalf("Wrong message code : got %d, want %d", message.MessageCode,
+			messages.CodePendingAcceptRelationship)
+	}
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	par, ok := p.(*messages.PendingAcceptRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := sendRS.ProcessPendingAcceptRelationship(ctx, itx, message, par, flag); err != nil {
+		t.Fatalf("Failed to process pending accept : %s", err)
+	}
+
+	member := sendRS.Relationships[0].Members[0]
+	if !member.PendingAccepted {
+		t.Fatalf("Member not marked pending accepted")
+	}
+
+	if member.Accepted {
+		t.Fatalf("Member should not be accepted")
+	}
+
+	if member.ProofOfIdentityType != ProofOfIdentityTypePaymail {
+		t.Fatalf("Wrong POI type : got %d, want %d", member.ProofOfIdentityType,
+			ProofOfIdentityTypePaymail)
+	}
+
+	if !bytes.Equal(member.ProofOfIdentity, originalPAR.ProofOfIdentity) {
+		t.Fatalf("Wrong POI : \n  got  %x\n  want %x", member.ProofOfIdentity,
+			originalPAR.ProofOfIdentity)
+	}
+}
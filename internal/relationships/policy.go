@@ -0,0 +1,273 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tokenized/relationship-example/internal/platform/config"
+	"github.com/tokenized/relationship-example/internal/platform/db"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	policyKey = "policy"
+
+	// PolicyReview means the relationship waits for a manual accept or decline.
+	PolicyReview = uint8(0)
+
+	// PolicyAccept means the relationship is automatically accepted.
+	PolicyAccept = uint8(1)
+
+	// PolicyIgnore means the relationship is ignored.
+	PolicyIgnore = uint8(2)
+)
+
+var PolicyName = map[uint8]string{
+	PolicyReview: "Review",
+	PolicyAccept: "Accept",
+	PolicyIgnore: "Ignore",
+}
+
+// Policy decides what is done with relationships initiated with us.
+type Policy struct {
+	// AutoAccept accepts all relationships that aren't blocked.
+	AutoAccept bool
+
+	// Allow contains the base keys of initiators whose relationships are accepted.
+	Allow []bitcoin.PublicKey
+
+	// AllowIdentities contains the identities, like paymail handles or entity names, of initiators
+	//   whose relationships are accepted when their proof of identity is verified.
+	AllowIdentities []string
+
+	// Block contains the base keys of initiators whose relationships are ignored.
+	Block []bitcoin.PublicKey
+}
+
+// NewPolicy returns the initial policy from the config.
+func NewPolicy(cfg *config.Config) Policy {
+	return Policy{
+		AutoAccept:      cfg.PolicyAutoAccept,
+		Allow:           cfg.PolicyAllow,
+		AllowIdentities: cfg.PolicyAllowIdentities,
+		Block:           cfg.PolicyBlock,
+	}
+}
+
+// Evaluate returns the action for a relationship initiated by the member. Blocked keys take
+//   priority over allowed keys.
+func (p Policy) Evaluate(initiator *Member) uint8 {
+	if containsKey(p.Block, initiator.BaseKey) {
+		return PolicyIgnore
+	}
+
+	if p.AutoAccept || containsKey(p.Allow, initiator.BaseKey) {
+		return PolicyAccept
+	}
+
+	if initiator.IdentityStatus == IdentityStatusVerified {
+		for _, identity := range p.AllowIdentities {
+			if identity == initiator.Identity {
+				return PolicyAccept
+			}
+		}
+	}
+
+	return PolicyReview
+}
+
+// GetPolicy returns a copy of the current policy.
+func (rs *Relationships) GetPolicy(ctx context.Context) Policy {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return rs.policy
+}
+
+// SetPolicy replaces the current policy. It is saved with the relationships and replaces the policy
+//   from the config when loaded.
+func (rs *Relationships) SetPolicy(ctx context.Context, p Policy) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	logger.Info(ctx, "Updating policy : auto accept %t, %d allowed, %d allowed identities, %d blocked",
+		p.AutoAccept, len(p.Allow), len(p.AllowIdentities), len(p.Block))
+
+	rs.policy = p
+	rs.policySet = true
+}
+
+// autoAccept accepts a relationship initiated with us, using our default proof of identity.
+func (rs *Relationships) autoAccept(ctx context.Context, r *Relationship) error {
+	proofOfIdentity, err := rs.DefaultProofOfIdentity()
+	if err != nil {
+		return errors.Wrap(err, "default proof of identity")
+	}
+
+	if _, err := rs.AcceptRelationship(ctx, r, proofOfIdentity); err != nil {
+		return errors.Wrap(err, "accept")
+	}
+
+	return nil
+}
+
+// loadPolicy loads the saved policy if there is one. The lock must already be held.
+func (rs *Relationships) loadPolicy(ctx context.Context, dbConn *db.DB) error {
+	b, err := dbConn.Fetch(ctx, policyKey)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil // use policy from config
+		}
+		return errors.Wrap(err, "fetch policy")
+	}
+
+	var p Policy
+	if err := p.Deserialize(bytes.NewReader(b)); err != nil {
+		return errors.Wrap(err, "deserialize policy")
+	}
+
+	rs.policy = p
+	rs.policySet = true
+	return nil
+}
+
+// savePolicy saves the policy if it was changed from the config. The lock must already be held.
+func (rs *Relationships) savePolicy(ctx context.Context, dbConn db.Writer) error {
+	if !rs.policySet {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := rs.policy.Serialize(&buf); err != nil {
+		return errors.Wrap(err, "serialize policy")
+	}
+
+	if err := dbConn.Put(ctx, policyKey, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "put policy")
+	}
+
+	return nil
+}
+
+func (p Policy) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, p.AutoAccept); err != nil {
+		return errors.Wrap(err, "auto accept")
+	}
+
+	if err := serializeKeys(buf, p.Allow); err != nil {
+		return errors.Wrap(err, "allow")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(p.AllowIdentities))); err != nil {
+		return errors.Wrap(err, "allow identities size")
+	}
+	for _, identity := range p.AllowIdentities {
+		if err := binary.Write(buf, binary.LittleEndian, uint32(len(identity))); err != nil {
+			return errors.Wrap(err, "identity size")
+		}
+		if _, err := buf.Write([]byte(identity)); err != nil {
+			return errors.Wrap(err, "identity")
+		}
+	}
+
+	if err := serializeKeys(buf, p.Block); err != nil {
+		return errors.Wrap(err, "block")
+	}
+
+	return nil
+}
+
+func (p *Policy) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &p.AutoAccept); err != nil {
+		return errors.Wrap(err, "auto accept")
+	}
+
+	var err error
+	p.Allow, err = deserializeKeys(buf)
+	if err != nil {
+		return errors.Wrap(err, "allow")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "allow identities size")
+	}
+	p.AllowIdentities = make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var size uint32
+		if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+			return errors.Wrap(err, "identity size")
+		}
+		identity := make([]byte, size)
+		if _, err := buf.Read(identity); err != nil {
+			return errors.Wrap(err, "identity")
+		}
+		p.AllowIdentities = append(p.AllowIdentities, string(identity))
+	}
+
+	p.Block, err = deserializeKeys(buf)
+	if err != nil {
+		return errors.Wrap(err, "block")
+	}
+
+	return nil
+}
+
+func serializeKeys(buf *bytes.Buffer, keys []bitcoin.PublicKey) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(keys))); err != nil {
+		return errors.Wrap(err, "size")
+	}
+	for _, key := range keys {
+		if err := key.Serialize(buf); err != nil {
+			return errors.Wrap(err, "key")
+		}
+	}
+
+	return nil
+}
+
+func deserializeKeys(buf *bytes.Reader) ([]bitcoin.PublicKey, error) {
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return nil, errors.Wrap(err, "size")
+	}
+	result := make([]bitcoin.PublicKey, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var key bitcoin.PublicKey
+		if err := key.Deserialize(buf); err != nil {
+			return nil, errors.Wrap(err, "key")
+		}
+		result = append(result, key)
+	}
+
+	return result, nil
+}
+
+func containsKey(keys []bitcoin.PublicKey, key bitcoin.PublicKey) bool {
+	for _, k := range keys {
+		if k.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestPolicy(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiver, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationship address : %s", err)
+	}
+
+	_, _, err = sendRS.InitiateRelationship(ctx, []bitcoin.PublicKey{receiver.PublicKey}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	sendR := sendRS.Relationships[0]
+	sendKey, err := sendWallet.GetKey(ctx, sendR.KeyType, sendR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get send key : %s", err)
+	}
+
+	initiator := &Member{BaseKey: sendKey.PublicKey()}
+
+	// Evaluate
+	if action := (Policy{}).Evaluate(initiator); action != PolicyReview {
+		t.Fatalf("Wrong default action : got %s, want %s", PolicyName[action],
+			PolicyName[PolicyReview])
+	}
+
+	blocked := Policy{
+		AutoAccept: true,
+		Block:      []bitcoin.PublicKey{initiator.BaseKey},
+	}
+	if action := blocked.Evaluate(initiator); action != PolicyIgnore {
+		t.Fatalf("Wrong blocked action : got %s, want %s", PolicyName[action],
+			PolicyName[PolicyIgnore])
+	}
+
+	identities := Policy{
+		AllowIdentities: []string{"test@tokenized.com"},
+	}
+	if action := identities.Evaluate(&Member{
+		BaseKey:        initiator.BaseKey,
+		Identity:       "test@tokenized.com",
+		IdentityStatus: IdentityStatusUnverified,
+	}); action != PolicyReview {
+		t.Fatalf("Wrong unverified identity action : got %s, want %s", PolicyName[action],
+			PolicyName[PolicyReview])
+	}
+	if action := identities.Evaluate(&Member{
+		BaseKey:        initiator.BaseKey,
+		Identity:       "test@tokenized.com",
+		IdentityStatus: IdentityStatusVerified,
+	}); action != PolicyAccept {
+		t.Fatalf("Wrong verified identity action : got %s, want %s", PolicyName[action],
+			PolicyName[PolicyAccept])
+	}
+
+	// Serialize
+	var buf bytes.Buffer
+	if err := blocked.Serialize(&buf); err != nil {
+		t.Fatalf("Failed to serialize policy : %s", err)
+	}
+
+	var read Policy
+	if err := read.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Failed to deserialize policy : %s", err)
+	}
+
+	if !read.AutoAccept || len(read.Block) != 1 || !read.Block[0].Equal(initiator.BaseKey) {
+		t.Fatalf("Wrong deserialized policy")
+	}
+
+	// Auto accept
+	receiveRS.SetPolicy(ctx, Policy{
+		Allow: []bitcoin.PublicKey{initiator.BaseKey},
+	})
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	logger.Info(ctx, "Process initiate with allow policy *****************************************")
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if len(receiveRS.Relationships) != 1 {
+		t.Fatalf("Wrong receive relationship count : got %d, want %d",
+			len(receiveRS.Relationships), 1)
+	}
+
+	if !receiveRS.Relationships[0].Accepted {
+		t.Fatalf("Relationship not auto accepted")
+	}
+
+	_, message, _, _ = decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+	if message.MessageCode != messages.CodeAcceptRelationship {
+		t.Fatalf("Wrong message code : got %d, want %d", message.MessageCode,
+			messages.CodeAcceptRelationship)
+	}
+}
+
+func TestPolicyIgnore(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	receiveR := receiveRS.Relationships[0]
+
+	receiveKey, err := receiveWallet.GetKey(ctx, receiveR.KeyType, receiveR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get receive key : %s", err)
+	}
+
+	// Initiate from a blocked sender to the same receive key.
+	blockedWallet, blockedBroadcastTx, blockedRS := newTestRelationships(t, ctx, cfg)
+
+	if _, _, err := blockedRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveKey.PublicKey()}, nil); err != nil {
+		t.Fatalf("Failed to create initiate relationship : %s", err)
+	}
+
+	blockedR := blockedRS.Relationships[0]
+	blockedKey, err := blockedWallet.GetKey(ctx, blockedR.KeyType, blockedR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get blocked key : %s", err)
+	}
+
+	receiveRS.SetPolicy(ctx, Policy{
+		Block: []bitcoin.PublicKey{blockedKey.PublicKey()},
+	})
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, blockedBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	ir, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	logger.Info(ctx, "Process initiate with block policy *****************************************")
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, ir, encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if len(receiveRS.Relationships) != 1 {
+		t.Fatalf("Wrong receive relationship count : got %d, want %d",
+			len(receiveRS.Relationships), 1)
+	}
+
+	nextAddress, err := receiveR.NextKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get next address : %s", err)
+	}
+
+	hashes, err := nextAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get next address hashes : %s", err)
+	}
+
+	if monitored, _ := receiveWallet.AreHashesMonitored(hashes); !monitored {
+		t.Fatalf("Next key of existing relationship not monitored")
+	}
+}
@@ -0,0 +1,134 @@
+package relationships
+
+import (
+	"context"
+
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+	"github.com/tokenized/smart-contract/pkg/txbuilder"
+	"github.com/tokenized/smart-contract/pkg/wire"
+
+	"github.com/pkg/errors"
+)
+
+// prefundKeys are the next keys of a relationship to check for funding.
+type prefundKeys struct {
+	txid     bitcoin.Hash32
+	keyType  uint32
+	keyIndex uint32
+	hashes   []bitcoin.Hash32
+}
+
+// RefillFunding funds the next keys of our open and accepted relationships in advance, so that
+//   messages are sent as a single tx instead of needing a funding tx first. A relationship is
+//   refilled when fewer than the threshold of its next keys are funded. Each relationship is
+//   funded in its own tx, from the UTXOs the relationship funding policy allows for it, so the
+//   relationships aren't linked to each other.
+// Returns the funding txs, which is empty if no keys needed funding. The txs created before an
+//   error are also returned.
+func (rs *Relationships) RefillFunding(ctx context.Context) ([]*wire.MsgTx, error) {
+	count := rs.cfg.PrefundCount
+	if count <= 0 {
+		return nil, nil
+	}
+	if count > LookaheadWindow {
+		count = LookaheadWindow // keys past the window aren't monitored
+	}
+
+	// Copy the keys so the wallet isn't used while the lock is held.
+	rs.lock.Lock()
+	var candidates []*prefundKeys
+	for _, r := range rs.Relationships {
+		if r.Closed || !r.Accepted {
+			continue
+		}
+
+		pk := &prefundKeys{
+			txid:     r.TxId,
+			keyType:  r.KeyType,
+			keyIndex: r.KeyIndex,
+		}
+		h := r.NextHash
+		for i := 0; i < count; i++ {
+			pk.hashes = append(pk.hashes, h)
+			h = bitcoin.NextHash(h)
+		}
+		candidates = append(candidates, pk)
+	}
+	rs.lock.Unlock()
+
+	var result []*wire.MsgTx
+	for _, candidate := range candidates {
+		tx, err := rs.refillKeys(ctx, candidate)
+		if err != nil {
+			return result, errors.Wrapf(err, "refill %s", candidate.txid.String())
+		}
+
+		if tx != nil {
+			result = append(result, tx)
+		}
+	}
+
+	return result, nil
+}
+
+// refillKeys funds the relationship's unfunded keys when fewer than the threshold are funded.
+// Returns the funding tx, or nil if the keys didn't need funding.
+func (rs *Relationships) refillKeys(ctx context.Context, candidate *prefundKeys) (*wire.MsgTx,
+	error) {
+
+	var unfunded []bitcoin.Hash32
+	for _, hash := range candidate.hashes {
+		if !rs.wallet.IsKeyHashFunded(ctx, candidate.keyType, candidate.keyIndex, hash) {
+			unfunded = append(unfunded, hash)
+		}
+	}
+
+	if len(candidate.hashes)-len(unfunded) >= rs.cfg.PrefundThreshold {
+		return nil, nil
+	}
+
+	logger.Info(ctx, "Pre-funding %d keys for relationship : %s", len(unfunded),
+		candidate.txid.String())
+
+	baseKey, err := rs.wallet.GetKey(ctx, candidate.keyType, candidate.keyIndex)
+	if err != nil {
+		return nil, errors.Wrap(err, "get key")
+	}
+
+	tx := txbuilder.NewTxBuilder(rs.cfg.DustLimit, rs.cfg.FeeRate)
+	for _, hash := range unfunded {
+		key, err := bitcoin.NextKey(baseKey, hash)
+		if err != nil {
+			return nil, errors.Wrap(err, "next key")
+		}
+
+		ra, err := key.RawAddress()
+		if err != nil {
+			return nil, errors.Wrap(err, "raw address")
+		}
+
+		if err := tx.AddPaymentOutput(ra, rs.cfg.PrefundValue, false); err != nil {
+			return nil, errors.Wrap(err, "add payment output")
+		}
+	}
+
+	changeAddress, err := rs.wallet.GetUnusedAddress(ctx, wallet.KeyTypeInternal)
+	if err != nil {
+		return nil, errors.Wrap(err, "get change address")
+	}
+
+	if err := tx.SetChangeAddress(changeAddress.Address, ""); err != nil {
+		return nil, errors.Wrap(err, "set change address")
+	}
+
+	if err := rs.wallet.AddRelationshipFunding(ctx, candidate.keyType, candidate.keyIndex, tx,
+		rs.broadcastTx); err != nil {
+		return nil, errors.Wrap(err, "add relationship funding")
+	}
+
+	logger.Info(ctx, "Created pre-funding tx : %s", tx.MsgTx.TxHash().String())
+	return tx.MsgTx, nil
+}
@@ -0,0 +1,91 @@
+package relationships
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+)
+
+func TestPrefund(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	cfg.PrefundCount = 4
+	cfg.PrefundThreshold = 2
+	cfg.PrefundValue = 2000
+
+	txs, err := sendRS.RefillFunding(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refill funding : %s", err)
+	}
+
+	if len(txs) != 1 {
+		t.Fatalf("Wrong funding tx count : got %d, want %d", len(txs), 1)
+	}
+	tx := txs[0]
+
+	if sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1] != tx {
+		t.Fatalf("Funding tx not broadcast")
+	}
+
+	r := sendRS.Relationships[0]
+	baseKey, err := sendWallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get key : %s", err)
+	}
+
+	h := r.NextHash
+	for i := 0; i < cfg.PrefundCount; i++ {
+		if !sendWallet.IsKeyHashFunded(ctx, r.KeyType, r.KeyIndex, h) {
+			t.Fatalf("Key %d not funded", i)
+		}
+
+		key, err := bitcoin.NextKey(baseKey, h)
+		if err != nil {
+			t.Fatalf("Failed to get next key : %s", err)
+		}
+
+		ra, err := key.RawAddress()
+		if err != nil {
+			t.Fatalf("Failed to get raw address : %s", err)
+		}
+
+		script, err := ra.LockingScript()
+		if err != nil {
+			t.Fatalf("Failed to get locking script : %s", err)
+		}
+
+		found := false
+		for _, output := range tx.TxOut {
+			if bytes.Equal(output.PkScript, script) && output.Value == cfg.PrefundValue {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("Output for key %d not found", i)
+		}
+
+		h = bitcoin.NextHash(h)
+	}
+
+	// Still above the threshold so nothing should be funded.
+	txs, err = sendRS.RefillFunding(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refill funding : %s", err)
+	}
+
+	if len(txs) != 0 {
+		t.Fatalf("Funding tx should not be created when keys are funded")
+	}
+}
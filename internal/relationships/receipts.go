@@ -0,0 +1,177 @@
+package relationships
+
+import (
+	"context"
+	"time"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrReceiptsNotSupported means the relationship uses direct encryption, so receipts can't be
+	//   sent without outputs for the other members.
+	ErrReceiptsNotSupported = errors.New("Receipts require indirect encryption")
+)
+
+// SendReadReceipt creates and broadcasts a receipt telling the other members that we read the
+//   message from the tx.
+func (rs *Relationships) SendReadReceipt(ctx context.Context, r *Relationship,
+	txid bitcoin.Hash32) error {
+
+	m := rs.FindMessage(ctx, r, txid)
+	if m == nil {
+		return errors.New("Message not found")
+	}
+
+	if m.Direction != DirectionIncoming {
+		return errors.New("Not an incoming message")
+	}
+
+	if err := rs.sendReceipt(ctx, r, txid, ReceiptRead); err != nil {
+		return errors.Wrap(err, "send receipt")
+	}
+
+	return nil
+}
+
+// sendReceipt creates and broadcasts a receipt for the message from the tx. There is no receipt
+//   message in the specification, so a receipt is a private message regarding the message with the
+//   status appended as an extra field. Receipts are indirectly encrypted and don't have outputs for
+//   the other members, so they are only supported by relationships that use indirect encryption.
+func (rs *Relationships) sendReceipt(ctx context.Context, r *Relationship, txid bitcoin.Hash32,
+	status uint8) error {
+
+	logger.Info(ctx, "Creating receipt (status %d) for message : %s", status, txid.String())
+
+	if r.Closed {
+		return ErrClosed
+	}
+
+	if !r.Accepted {
+		return errors.New("Relationship not accepted")
+	}
+
+	if r.EncryptionType == 0 {
+		return ErrReceiptsNotSupported
+	}
+
+	receipt := &messages.PrivateMessage{
+		Timestamp: uint64(time.Now().UnixNano()),
+		Regarding: &messages.OutpointField{
+			TxId: txid.Bytes(),
+		},
+	}
+
+	payload, err := receipt.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "serialize receipt")
+	}
+
+	payload, err = appendField(payload, receiptField, []byte{status})
+	if err != nil {
+		return errors.Wrap(err, "append status")
+	}
+
+	if _, err := rs.sendMessageToReceivers(ctx, r, nil, messages.CodePrivateMessage,
+		payload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	return nil
+}
+
+// receiptStatus returns the status of the receipt in the message payload, or zero if it isn't a
+//   receipt.
+func receiptStatus(payload []byte) (uint8, error) {
+	b, err := findField(payload, receiptField)
+	if err != nil {
+		return 0, errors.Wrap(err, "find field")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	return b[0], nil
+}
+
+// processReceipt applies a receipt from other members to the message it is regarding in our
+//   history. Receipts for messages from other members are ignored.
+func (rs *Relationships) processReceipt(ctx context.Context, r *Relationship, areSender bool,
+	memberIndexes []uint32, receipt *messages.PrivateMessage, status uint8) error {
+
+	if areSender {
+		return nil // our own receipt, already applied when it was sent
+	}
+
+	if len(memberIndexes) == 0 {
+		return ErrSenderNotFound
+	}
+
+	if receipt.Regarding == nil {
+		return errors.New("Missing receipt regarding")
+	}
+
+	txid, err := bitcoin.NewHash32(receipt.Regarding.TxId)
+	if err != nil {
+		return errors.Wrap(err, "regarding txid")
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var m *Message
+	for _, hm := range rs.history[r.TxId] {
+		if hm.TxId.Equal(txid) {
+			m = hm
+			break
+		}
+	}
+
+	if m == nil {
+		logger.Warn(ctx, "Receipt message not found : %s", txid.String())
+		return nil
+	}
+
+	if m.Direction != DirectionOutgoing {
+		return nil
+	}
+
+	timestamp := receipt.Timestamp
+	if timestamp == 0 {
+		timestamp = uint64(time.Now().UnixNano())
+	}
+
+	for _, memberIndex := range memberIndexes {
+		logger.Info(ctx, "Receipt (status %d) from member %d for message : %s", status,
+			memberIndex, txid.String())
+		setReceipt(m, memberIndex, status, timestamp)
+	}
+
+	return nil
+}
+
+// setReceipt updates the receipt status of the member for the message. The status is never
+//   lowered, so a delivered receipt seen after a read receipt is ignored. The lock must already be
+//   held.
+func setReceipt(m *Message, memberIndex uint32, status uint8, timestamp uint64) {
+	for _, receipt := range m.Receipts {
+		if receipt.MemberIndex == memberIndex {
+			if status > receipt.Status {
+				receipt.Status = status
+				receipt.Timestamp = timestamp
+			}
+			return
+		}
+	}
+
+	m.Receipts = append(m.Receipts, &Receipt{
+		MemberIndex: memberIndex,
+		Status:      status,
+		Timestamp:   timestamp,
+	})
+}
@@ -0,0 +1,129 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestReceipts(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+	cfg.SendReceipts = true
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	// Receipts require indirect encryption, which is used when there is more than one receiver.
+	otherKey, err := bitcoin.GenerateKey(bitcoin.MainNet)
+	if err != nil {
+		t.Fatalf("Failed to generate other key : %s", err)
+	}
+
+	otherPublicKey := otherKey.PublicKey()
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, &otherPublicKey)
+
+	logger.Info(ctx, "Sending private message ****************************************************")
+
+	sendPrivateMessage := &messages.PrivateMessage{
+		Subject: "Sample message",
+	}
+
+	if err := sendRS.SendMessage(ctx, sendRS.Relationships[0], sendPrivateMessage); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+	messageTxId := *itx.Hash
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := sendRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message for sender : %s", err)
+	}
+
+	if len(sendBroadcastTx.Msgs) != 0 {
+		t.Fatalf("Sender should not send a receipt")
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message for receiver : %s", err)
+	}
+
+	statuses := []uint8{ReceiptDelivered, ReceiptRead}
+	for i, status := range statuses {
+		if status == ReceiptRead {
+			logger.Info(ctx, "Sending read receipt ***************************************************")
+
+			if err := receiveRS.SendReadReceipt(ctx, receiveRS.Relationships[0],
+				messageTxId); err != nil {
+				t.Fatalf("Failed to send read receipt : %s", err)
+			}
+		}
+
+		itx, message, _, flag = decryptMessage(t, ctx, cfg, sendRS, receiveBroadcastTx)
+
+		// Receipts don't have outputs for the other members.
+		for _, output := range itx.MsgTx.TxOut {
+			ra, err := bitcoin.RawAddressFromLockingScript(output.PkScript)
+			if err != nil {
+				continue
+			}
+
+			if _, err := ra.GetPublicKey(); err == nil {
+				t.Fatalf("Receipt should not have member outputs")
+			}
+		}
+
+		p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+		if err != nil {
+			t.Fatalf("Failed to deserialize receipt payload : %s", err)
+		}
+
+		receipt, ok := p.(*messages.PrivateMessage)
+		if !ok {
+			t.Fatalf("Wrong receipt type")
+		}
+
+		if _, err := sendRS.ProcessPrivateMessage(ctx, itx, message, receipt,
+			flag); err != nil {
+			t.Fatalf("Failed to process receipt %d : %s", i, err)
+		}
+
+		m := sendRS.FindMessage(ctx, sendRS.Relationships[0], messageTxId)
+		if m == nil {
+			t.Fatalf("Sent message not in history")
+		}
+
+		if len(m.Receipts) != 1 {
+			t.Fatalf("Wrong receipt count : got %d, want %d", len(m.Receipts), 1)
+		}
+
+		if m.Receipts[0].Status != status {
+			t.Fatalf("Wrong receipt status : got %d, want %d", m.Receipts[0].Status, status)
+		}
+
+		// Receipts are not added to history.
+		if len(sendRS.GetHistory(ctx, sendRS.Relationships[0])) != 1 {
+			t.Fatalf("Receipt should not be in history")
+		}
+	}
+}
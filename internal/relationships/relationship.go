@@ -6,6 +6,7 @@ import (
 	"github.com/tokenized/relationship-example/internal/wallet"
 
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/inspector"
 
 	"github.com/pkg/errors"
 )
@@ -14,11 +15,26 @@ var (
 	ErrKeyNotFound = errors.New("Key not found")
 )
 
+const (
+	// LookaheadWindow is the number of keys, starting at the next expected key, that are checked
+	//   for each party so that messages are still found when earlier messages are missed or seen out
+	//   of order.
+	LookaheadWindow = 20
+
+	// MaxSkippedKeys is the maximum number of keys jumped over for a member that are remembered so
+	//   that late messages using them can still be found.
+	MaxSkippedKeys = 100
+
+	// MaxHintDistance is the maximum number of keys past a member's next key that a sender hint can
+	//   point to.
+	MaxHintDistance = 1000
+)
+
 func (r *Relationship) FindKey(baseKey bitcoin.PublicKey, publicKey bitcoin.PublicKey) (bitcoin.Hash32, uint64, error) {
 	hp, _ := bitcoin.NewHash32(bitcoin.Sha256(r.Seed))
 	h := *hp
 
-	for i := uint64(1); i < r.NextIndex+10; i++ {
+	for i := uint64(1); i < r.NextIndex+LookaheadWindow; i++ {
 		npk, err := bitcoin.NextPublicKey(baseKey, h)
 		if err != nil {
 			return bitcoin.Hash32{}, 0, errors.Wrap(err, "next public key")
@@ -47,9 +63,191 @@ func (r *Relationship) IncrementHash(ctx context.Context, wallet *wallet.Wallet)
 		return errors.Wrap(err, "get key")
 	}
 
-	if err := wallet.AddIndependentKey(ctx, r.NextKey, r.KeyType, r.KeyIndex, r.NextHash); err != nil {
-		return errors.Wrap(err, "add independent key")
+	if err := r.AddLookaheadKeys(ctx, wallet); err != nil {
+		return errors.Wrap(err, "add lookahead keys")
+	}
+
+	return nil
+}
+
+// UseHash moves our hash position past the hash if it is in the lookahead window.
+// Returns true if the hash was in the lookahead window.
+func (r *Relationship) UseHash(ctx context.Context, wallet *wallet.Wallet,
+	hash bitcoin.Hash32) (bool, error) {
+
+	offset, found := r.hashOffset(hash)
+	if !found {
+		return false, nil
+	}
+
+	for i := uint64(0); i <= offset; i++ {
+		if err := r.IncrementHash(ctx, wallet); err != nil {
+			return false, errors.Wrap(err, "increment hash")
+		}
+	}
+
+	return true, nil
+}
+
+// MoveToHash moves our hash position to the hash if it is in the lookahead window, so the key
+//   derived from it is our next key. Receiving a message on a key doesn't use it, so a later key
+//   than expected means the keys before it were used.
+// Returns true if the hash was in the lookahead window.
+func (r *Relationship) MoveToHash(ctx context.Context, wallet *wallet.Wallet,
+	hash bitcoin.Hash32) (bool, error) {
+
+	offset, found := r.hashOffset(hash)
+	if !found {
+		return false, nil
+	}
+
+	for i := uint64(0); i < offset; i++ {
+		if err := r.IncrementHash(ctx, wallet); err != nil {
+			return false, errors.Wrap(err, "increment hash")
+		}
+	}
+
+	return true, nil
+}
+
+// AddLookaheadKeys adds the keys in our lookahead window to the wallet so that txs using them are
+//   recognized, even when they use a later key than expected.
+func (r *Relationship) AddLookaheadKeys(ctx context.Context, wallet *wallet.Wallet) error {
+	if r.Closed {
+		return nil // keys of closed relationships are no longer monitored
+	}
+
+	// Drop hashes before the next hash. Start over if the next hash isn't in the window.
+	for len(r.lookahead) > 0 && !r.lookahead[0].Equal(&r.NextHash) {
+		r.lookahead = r.lookahead[1:]
+	}
+
+	baseKey, err := wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
+	h := r.NextHash
+	if len(r.lookahead) > 0 {
+		h = bitcoin.NextHash(r.lookahead[len(r.lookahead)-1])
+	}
+
+	for len(r.lookahead) < LookaheadWindow {
+		pk, err := bitcoin.NextPublicKey(baseKey.PublicKey(), h)
+		if err != nil {
+			return errors.Wrap(err, "next public key")
+		}
+
+		if err := wallet.AddIndependentKey(ctx, pk, r.KeyType, r.KeyIndex, h); err != nil {
+			return errors.Wrap(err, "add independent key")
+		}
+
+		r.lookahead = append(r.lookahead, h)
+		h = bitcoin.NextHash(h)
 	}
 
 	return nil
 }
+
+// RemoveKeys removes the keys in the relationship's hash chain, from the first key derived from the
+//   seed through the lookahead window, from wallet tx filtering so that txs using them are no
+//   longer recognized. Other relationships can be derived from the same base key, so their keys
+//   are still monitored.
+func (r *Relationship) RemoveKeys(ctx context.Context, wallet *wallet.Wallet) error {
+	hashes := make([]bitcoin.Hash32, 0, r.NextIndex+LookaheadWindow+uint64(len(r.lookahead)))
+	hashes = append(hashes, r.lookahead...)
+
+	hp, err := bitcoin.NewHash32(bitcoin.Sha256(r.Seed))
+	if err != nil {
+		return errors.Wrap(err, "seed hash")
+	}
+
+	h := *hp
+	for i := uint64(1); i < r.NextIndex+LookaheadWindow; i++ {
+		hashes = append(hashes, h)
+		h = bitcoin.NextHash(h)
+	}
+
+	if err := wallet.RemoveDerivedKeys(ctx, r.KeyType, r.KeyIndex, hashes); err != nil {
+		return errors.Wrap(err, "remove derived keys")
+	}
+
+	r.lookahead = nil
+	return nil
+}
+
+// hashOffset returns the number of hashes after the next hash that the hash is, if it is in the
+//   lookahead window.
+func (r *Relationship) hashOffset(hash bitcoin.Hash32) (uint64, bool) {
+	h := r.NextHash
+	for i := uint64(0); i < LookaheadWindow; i++ {
+		if h.Equal(&hash) {
+			return i, true
+		}
+		h = bitcoin.NextHash(h)
+	}
+
+	return 0, false
+}
+
+// useSenderHint finds the member that sent the tx from the input and key index in the sender hint
+//   and moves the member's hash position past the key used.
+// Returns false if the hint doesn't match the key of any member.
+func (r *Relationship) useSenderHint(itx *inspector.Transaction, hint *SenderHint) (uint32, bool) {
+	if int(hint.SenderIndex) >= len(itx.MsgTx.TxIn) || hint.KeyIndex == 0 {
+		return 0, false
+	}
+
+	pk, err := bitcoin.PublicKeyFromUnlockingScript(itx.MsgTx.TxIn[hint.SenderIndex].SignatureScript)
+	if err != nil {
+		return 0, false
+	}
+
+	publicKey, err := bitcoin.PublicKeyFromBytes(pk)
+	if err != nil {
+		return 0, false
+	}
+
+	maxNextIndex := uint64(0)
+	for _, m := range r.Members {
+		if m.NextIndex > maxNextIndex {
+			maxNextIndex = m.NextIndex
+		}
+	}
+	if hint.KeyIndex > maxNextIndex+MaxHintDistance {
+		return 0, false
+	}
+
+	// All members use the same hash chain, so only the key derivation differs.
+	hash, err := r.hashAt(hint.KeyIndex)
+	if err != nil {
+		return 0, false
+	}
+
+	for index, m := range r.Members {
+		key, err := bitcoin.NextPublicKey(m.BaseKey, hash)
+		if err != nil || !key.Equal(publicKey) {
+			continue
+		}
+
+		m.UseIndex(hint.KeyIndex, hash)
+		return uint32(index), true
+	}
+
+	return 0, false
+}
+
+// hashAt returns the hash at the index in the relationship's hash chain.
+func (r *Relationship) hashAt(index uint64) (bitcoin.Hash32, error) {
+	hp, err := bitcoin.NewHash32(bitcoin.Sha256(r.Seed))
+	if err != nil {
+		return bitcoin.Hash32{}, errors.Wrap(err, "seed hash")
+	}
+	h := *hp
+
+	for i := uint64(1); i < index; i++ {
+		h = bitcoin.NextHash(h)
+	}
+
+	return h, nil
+}
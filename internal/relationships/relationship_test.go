@@ -0,0 +1,101 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+	"github.com/tokenized/smart-contract/pkg/wire"
+)
+
+func TestMessageOutOfOrder(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	otherKey, err := bitcoin.GenerateKey(bitcoin.MainNet)
+	if err != nil {
+		t.Fatalf("Failed to generate other key : %s", err)
+	}
+
+	otherPublicKey := otherKey.PublicKey()
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, &otherPublicKey)
+
+	logger.Info(ctx, "Sending private messages ***************************************************")
+
+	subjects := []string{"First message", "Second message"}
+	var txs []*wire.MsgTx
+	for _, subject := range subjects {
+		err = sendRS.SendMessage(ctx, sendRS.Relationships[0], &messages.PrivateMessage{
+			Subject: subject,
+		})
+		if err != nil {
+			t.Fatalf("Failed to send message : %s", err)
+		}
+
+		txs = append(txs, sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1])
+		sendBroadcastTx.Msgs = nil
+	}
+
+	r := receiveRS.Relationships[0]
+	nextIndexes := make([]uint64, len(r.Members))
+	for i, m := range r.Members {
+		nextIndexes[i] = m.NextIndex
+	}
+
+	// Process the second message before the first.
+	var memberIndex uint32
+	for i := len(txs) - 1; i >= 0; i-- {
+		sendBroadcastTx.Msgs = []*wire.MsgTx{txs[i]}
+		itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+		p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+		if err != nil {
+			t.Fatalf("Failed to deserialize message payload : %s", err)
+		}
+
+		privateMessage, ok := p.(*messages.PrivateMessage)
+		if !ok {
+			t.Fatalf("Not a private message : %d", message.MessageCode)
+		}
+
+		if privateMessage.Subject != subjects[i] {
+			t.Fatalf("Wrong private message subject : got \"%s\", want \"%s\"",
+				privateMessage.Subject, subjects[i])
+		}
+
+		_, areSender, memberIndexes, err := receiveRS.GetRelationshipForTx(ctx, itx, message, flag)
+		if err != nil {
+			t.Fatalf("Failed to get relationship for tx : %s", err)
+		}
+
+		if areSender {
+			t.Fatalf("Should not be sender")
+		}
+
+		if len(memberIndexes) != 1 {
+			t.Fatalf("Wrong sender count : got %d, want %d", len(memberIndexes), 1)
+		}
+		memberIndex = memberIndexes[0]
+
+		// The sender's position should move past the second message when it is seen first and
+		//   not move back when the first message is seen.
+		if r.Members[memberIndex].NextIndex != nextIndexes[memberIndex]+2 {
+			t.Fatalf("Wrong member next index : got %d, want %d",
+				r.Members[memberIndex].NextIndex, nextIndexes[memberIndex]+2)
+		}
+	}
+
+	if len(r.Members[memberIndex].skipped) != 0 {
+		t.Fatalf("Skipped keys not used : %d", len(r.Members[memberIndex].skipped))
+	}
+}
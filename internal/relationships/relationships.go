@@ -24,6 +24,12 @@ import (
 var (
 	ErrUnknownFlag = errors.New("Unknown Flag")
 	ErrNotFound    = errors.New("Not found")
+
+	// ErrSenderNotFound means none of the senders of a message are members of the relationship.
+	ErrSenderNotFound = errors.New("Sender not found")
+
+	// ErrClosed means the relationship has been closed so its messages are no longer processed.
+	ErrClosed = errors.New("Relationship closed")
 )
 
 const (
@@ -35,6 +41,16 @@ type Relationships struct {
 	cfg         *config.Config
 	wallet      *wallet.Wallet
 	broadcastTx wallet.BroadcastTx
+	oracle      *OracleClient
+	verifiers   map[uint32]IdentityVerifier
+	history     map[bitcoin.Hash32][]*Message
+	hints       map[bitcoin.Hash32]*SenderHint
+	policy      Policy
+	policySet   bool // policy was changed from the config
+	txDeltas    []*TxDelta
+	txSnapshot  map[bitcoin.Hash32][]byte // relationships before the tx being processed
+	sent        []*SentMessage            // unconfirmed messages we sent
+	recovering  bool                      // replaying txs from the chain
 	lock        sync.Mutex
 
 	Relationships []*Relationship
@@ -45,11 +61,85 @@ func NewRelationships(cfg *config.Config, wallet *wallet.Wallet, broadcastTx wal
 		cfg:         cfg,
 		wallet:      wallet,
 		broadcastTx: broadcastTx,
+		verifiers:   make(map[uint32]IdentityVerifier),
+		history:     make(map[bitcoin.Hash32][]*Message),
+		hints:       make(map[bitcoin.Hash32]*SenderHint),
+		policy:      NewPolicy(cfg),
+	}
+
+	wallet.SetActiveKeys(result)
+
+	if len(cfg.IdentityURL) > 0 {
+		result.oracle = NewOracleClient(cfg.IdentityURL)
+		result.verifiers[ProofOfIdentityTypePaymail] = NewPaymailVerifier(result.oracle)
+		result.verifiers[ProofOfIdentityTypeOracle] = NewOracleVerifier(result.oracle)
 	}
 
 	return result, nil
 }
 
+// SetRecovering specifies if txs are being replayed from the chain to rebuild the relationships.
+//   While recovering, processed txs don't trigger new txs, like auto accepts and receipts, since
+//   those were already sent the first time the txs were processed.
+func (rs *Relationships) SetRecovering(recovering bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.recovering = recovering
+}
+
+func (rs *Relationships) IsRecovering() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return rs.recovering
+}
+
+// IsKeyActive returns true if the relationship derived key is our next key, or is in the lookahead
+//   window, in an open relationship. UTXOs on active keys are kept to fund our messages.
+func (rs *Relationships) IsKeyActive(keyType, keyIndex uint32, keyHash bitcoin.Hash32) bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	for _, r := range rs.Relationships {
+		if r.Closed || r.KeyType != keyType || r.KeyIndex != keyIndex {
+			continue
+		}
+
+		if _, active := r.hashOffset(keyHash); active {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetActiveKeys returns the relationship derived keys that are our next key, or are in the
+//   lookahead window, in an open relationship.
+func (rs *Relationships) GetActiveKeys() map[wallet.DerivedKey]bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	result := make(map[wallet.DerivedKey]bool)
+	for _, r := range rs.Relationships {
+		if r.Closed {
+			continue
+		}
+
+		h := r.NextHash
+		for i := uint64(0); i < LookaheadWindow; i++ {
+			result[wallet.DerivedKey{
+				KeyType:  r.KeyType,
+				KeyIndex: r.KeyIndex,
+				KeyHash:  h,
+			}] = true
+			h = bitcoin.NextHash(h)
+		}
+	}
+
+	return result
+}
+
 func (rs *Relationships) ListRelationships(ctx context.Context) []*Relationship {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
@@ -75,6 +165,35 @@ func (rs *Relationships) GetRelationship(ctx context.Context, keyType, keyIndex
 	return nil
 }
 
+// getRelationshipForAddress returns the relationship for one of our addresses. When the address
+//   was derived from a hash, the relationship using that hash is preferred since a base key can be
+//   used by more than one relationship.
+func (rs *Relationships) getRelationshipForAddress(ctx context.Context,
+	ad *wallet.Address) *Relationship {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var result *Relationship
+	for _, r := range rs.Relationships {
+		if r.KeyType != ad.KeyType || r.KeyIndex != ad.KeyIndex {
+			continue
+		}
+
+		if ad.KeyHash != nil {
+			if _, found := r.hashOffset(*ad.KeyHash); found {
+				return r
+			}
+		}
+
+		if result == nil {
+			result = r
+		}
+	}
+
+	return result
+}
+
 func (rs *Relationships) FindRelationshipForFlag(ctx context.Context, flag []byte) *Relationship {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
@@ -112,11 +231,11 @@ func (rs *Relationships) findRelationshipForFlag(ctx context.Context, flag []byt
 //   transaction and also increments all of the hashes for the keys involved.
 // Returns:
 //   *Relationship - matching relationship. nil if not found
-//   bool - true if we are the sender
-//   uint32 - the index of the member that sent the tx
+//   bool - true if we are a sender
+//   []uint32 - the indexes of the members that sent the tx
 //   error - if applicable
 func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspector.Transaction,
-	message *actions.Message, flag []byte) (*Relationship, bool, uint32, error) {
+	message *actions.Message, flag []byte) (*Relationship, bool, []uint32, error) {
 
 	var r *Relationship
 	if len(flag) > 0 {
@@ -128,26 +247,25 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 	}
 
 	areSender := false
-	memberIndex := uint32(0)
 	for _, senderIndex := range message.SenderIndexes {
 		if int(senderIndex) >= len(itx.MsgTx.TxIn) {
-			return nil, false, 0, fmt.Errorf("Sender index out of range : %d/%d", senderIndex,
+			return nil, false, nil, fmt.Errorf("Sender index out of range : %d/%d", senderIndex,
 				len(itx.MsgTx.TxIn))
 		}
 
 		pk, err := bitcoin.PublicKeyFromUnlockingScript(itx.MsgTx.TxIn[senderIndex].SignatureScript)
 		if err != nil {
-			return nil, false, 0, errors.Wrap(err, "sender parse script")
+			return nil, false, nil, errors.Wrap(err, "sender parse script")
 		}
 
 		publicKey, err := bitcoin.PublicKeyFromBytes(pk)
 		if err != nil {
-			return nil, false, 0, errors.Wrap(err, "sender public key")
+			return nil, false, nil, errors.Wrap(err, "sender public key")
 		}
 
 		ra, err := publicKey.RawAddress()
 		if err != nil {
-			return nil, false, 0, errors.Wrap(err, "sender address")
+			return nil, false, nil, errors.Wrap(err, "sender address")
 		}
 
 		ad, err := rs.wallet.FindAddress(ctx, ra)
@@ -155,7 +273,7 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 			if errors.Cause(err) == bitcoin.ErrUnknownScriptTemplate {
 				continue
 			}
-			return nil, false, 0, errors.Wrap(err, "find sender address")
+			return nil, false, nil, errors.Wrap(err, "find sender address")
 		}
 
 		if ad != nil &&
@@ -163,20 +281,20 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 
 			if r != nil {
 				if ad.KeyType != r.KeyType || ad.KeyIndex != r.KeyIndex {
-					return nil, false, 0, errors.New("Wrong key for relationship")
+					return nil, false, nil, errors.New("Wrong key for relationship")
 				}
 			} else {
-				r = rs.GetRelationship(ctx, ad.KeyType, ad.KeyIndex)
+				r = rs.getRelationshipForAddress(ctx, ad)
 				if r == nil {
-					return nil, false, 0, ErrNotFound
+					return nil, false, nil, ErrNotFound
 				}
 			}
 
 			areSender = true
 
-			if ad.PublicKey.Equal(r.NextKey) {
-				if err := r.IncrementHash(ctx, rs.wallet); err != nil {
-					return nil, false, 0, errors.Wrap(err, "increment hash")
+			if ad.KeyHash != nil {
+				if _, err := r.UseHash(ctx, rs.wallet, *ad.KeyHash); err != nil {
+					return nil, false, nil, errors.Wrap(err, "use hash")
 				}
 			}
 		}
@@ -189,7 +307,7 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 	if !areSender {
 		for _, receiverIndex := range message.ReceiverIndexes {
 			if int(receiverIndex) >= len(itx.Outputs) {
-				return nil, false, 0, fmt.Errorf("Receiver index out of range : %d/%d", receiverIndex,
+				return nil, false, nil, fmt.Errorf("Receiver index out of range : %d/%d", receiverIndex,
 					len(itx.Outputs))
 			}
 
@@ -198,7 +316,7 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 				if errors.Cause(err) == bitcoin.ErrUnknownScriptTemplate {
 					continue
 				}
-				return nil, false, 0, errors.Wrap(err, "find receiver address")
+				return nil, false, nil, errors.Wrap(err, "find receiver address")
 			}
 
 			if ad != nil &&
@@ -206,17 +324,19 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 
 				if r != nil {
 					if ad.KeyType != r.KeyType || ad.KeyIndex != r.KeyIndex {
-						return nil, false, 0, errors.New("Wrong key for relationship")
+						return nil, false, nil, errors.New("Wrong key for relationship")
 					}
 				} else {
-					r = rs.GetRelationship(ctx, ad.KeyType, ad.KeyIndex)
+					r = rs.getRelationshipForAddress(ctx, ad)
 					if r == nil {
-						return nil, false, 0, ErrNotFound
+						return nil, false, nil, ErrNotFound
 					}
 
-					if ad.PublicKey.Equal(r.NextKey) {
-						if err := r.IncrementHash(ctx, rs.wallet); err != nil {
-							return nil, false, 0, errors.Wrap(err, "increment hash")
+					// Receiving on a key doesn't use it, so our next message is authorized by the
+					//   dust received on it. A later key than expected moves our position to it.
+					if ad.KeyHash != nil {
+						if _, err := r.MoveToHash(ctx, rs.wallet, *ad.KeyHash); err != nil {
+							return nil, false, nil, errors.Wrap(err, "move to hash")
 						}
 					}
 				}
@@ -225,26 +345,47 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 	}
 
 	if r == nil {
-		return nil, false, 0, ErrNotFound
+		return nil, false, nil, ErrNotFound
+	}
+
+	if r.Closed {
+		return r, areSender, nil, ErrClosed
+	}
+
+	// The sender hint from the encrypted payload identifies the sender's input and key directly.
+	// Messages without a hint, or with a hint that doesn't match, fall back to checking the expected
+	//   keys of all members.
+	// Every sender's hash is moved past the key it used since co-signed messages have inputs from
+	//   more than one member.
+	var memberIndexes []uint32
+	hint := rs.takeSenderHint(*itx.Hash)
+	if hint != nil {
+		if index, found := r.useSenderHint(itx, hint); found {
+			logger.Info(ctx, "Sender hint matches key %d for member %d", hint.KeyIndex, index)
+			memberIndexes = append(memberIndexes, index)
+		} else {
+			hint = nil
+		}
 	}
 
 	for _, senderIndex := range message.SenderIndexes {
+		if hint != nil && senderIndex == hint.SenderIndex {
+			continue // already found with hint
+		}
+
 		pk, err := bitcoin.PublicKeyFromUnlockingScript(itx.MsgTx.TxIn[senderIndex].SignatureScript)
 		if err != nil {
-			return nil, false, 0, errors.Wrap(err, "sender parse script")
+			return nil, false, nil, errors.Wrap(err, "sender parse script")
 		}
 
 		publicKey, err := bitcoin.PublicKeyFromBytes(pk)
 		if err != nil {
-			return nil, false, 0, errors.Wrap(err, "sender public key")
+			return nil, false, nil, errors.Wrap(err, "sender public key")
 		}
 
 		for index, m := range r.Members {
-			if publicKey.Equal(m.NextKey) {
-				if !areSender {
-					memberIndex = uint32(index)
-				}
-				m.IncrementHash()
+			if m.UseKey(publicKey) {
+				memberIndexes = append(memberIndexes, uint32(index))
 				break
 			}
 		}
@@ -256,12 +397,11 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 			if errors.Cause(err) == bitcoin.ErrWrongType {
 				continue
 			}
-			return nil, false, 0, errors.Wrap(err, "get public key")
+			return nil, false, nil, errors.Wrap(err, "get public key")
 		}
 
 		for _, m := range r.Members {
-			if publicKey.Equal(m.NextKey) {
-				m.IncrementHash()
+			if m.UseKey(publicKey) {
 				break
 			}
 		}
@@ -269,7 +409,7 @@ func (rs *Relationships) GetRelationshipForTx(ctx context.Context, itx *inspecto
 
 	logger.Info(ctx, "Found relationship : %s", r.TxId.String())
 
-	return r, areSender, memberIndex, nil
+	return r, areSender, memberIndexes, nil
 }
 
 func (rs *Relationships) FindHash(ctx context.Context, r *Relationship,
@@ -288,7 +428,7 @@ func (rs *Relationships) FindHash(ctx context.Context, r *Relationship,
 		}
 	}
 
-	// Check past keys
+	// Check past keys and keys in the lookahead windows
 	baseKey, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
 	if err != nil {
 		return bitcoin.Hash32{}, errors.Wrap(err, "get key")
@@ -312,7 +452,7 @@ func (rs *Relationships) FindHash(ctx context.Context, r *Relationship,
 		}
 
 		logger.Info(ctx, "Key matches index %d for member %d", in, i)
-		return bitcoin.AddHashes(r.EncryptionKey, h), nil
+		return h, nil
 	}
 
 	return bitcoin.Hash32{}, ErrKeyNotFound
@@ -348,20 +488,20 @@ func (rs *Relationships) DecryptAction(ctx context.Context, itx *inspector.Trans
 	defer rs.lock.Unlock()
 
 	if len(flag) == 0 { // Not related to a relationship with a indirect encryption
-		return rs.wallet.DecryptActionDirect(ctx, itx.MsgTx, index, env)
+		return rs.decryptActionDirect(ctx, itx, env)
 	}
 
 	// Find relationship
 	r := rs.findRelationshipForFlag(ctx, flag)
 	if r == nil { // Not related to a relationship with a indirect encryption
-		return rs.wallet.DecryptActionDirect(ctx, itx.MsgTx, index, env)
+		return rs.decryptActionDirect(ctx, itx, env)
 	}
 
 	logger.Info(ctx, "Found relationship for decryption : %s", r.TxId.String())
 
 	if r.EncryptionType == 0 { // Relationship uses direct encryption
 		logger.Info(ctx, "Relationship uses direct encryption : %s", r.TxId.String())
-		return rs.wallet.DecryptActionDirect(ctx, itx.MsgTx, index, env)
+		return rs.decryptActionDirect(ctx, itx, env)
 	}
 
 	logger.Info(ctx, "Relationship uses indirect encryption : %s", r.TxId.String())
@@ -370,10 +510,11 @@ func (rs *Relationships) DecryptAction(ctx context.Context, itx *inspector.Trans
 	// The encryption key is based on which key is used to create the message.
 	// For example if the sender used their 5th derived key to send the message, then the encryption
 	//   key is the 5th derived encryption key for the relationship.
-	// TODO Pull information from encrypted payload about which input is the sender. --ce
-	// TODO Implement system for checking more than just the expected index key as the sender. For
-	//   example if the sender sends two messages and we see them out of order. --ce
-	for i, _ := range itx.Inputs {
+	// Keys in each member's lookahead window and keys that were skipped over are checked as well as
+	//   the expected key, so messages that are seen out of order can still be decrypted.
+	// The sender hint is inside the encrypted payload so it can't be used to find the key, but the
+	//   public sender indexes are checked before the other inputs.
+	for _, i := range inputOrder(ctx, env, len(itx.Inputs)) {
 		publicKey, err := itx.GetPublicKeyForInput(i)
 		if err != nil {
 			if errors.Cause(err) == bitcoin.ErrWrongType {
@@ -395,17 +536,74 @@ func (rs *Relationships) DecryptAction(ctx context.Context, itx *inspector.Trans
 		}
 
 		logger.Info(ctx, "Decrypting action with indirect key")
-		action, err := rs.wallet.DecryptActionIndirect(ctx, env, encryptionKey)
+		decrypted, err := rs.wallet.DecryptPayloadIndirect(ctx, env, encryptionKey)
 		if err != nil {
 			logger.Info(ctx, "Failed to decrypt action indirect : %s", err)
 			return nil, bitcoin.Hash32{}, errors.Wrap(err, "decrypt action indirect")
 		}
-		if action != nil {
-			return action, bitcoin.Hash32{}, err
+
+		action, err := rs.deserializeAction(ctx, itx, env, decrypted)
+		if err != nil {
+			return nil, bitcoin.Hash32{}, errors.Wrap(err, "deserialize action")
 		}
+
+		logger.Info(ctx, "Indirect decryption of %s", action.Code())
+		return action, bitcoin.Hash32{}, nil
+	}
+
+	return rs.decryptActionDirect(ctx, itx, env)
+}
+
+// decryptActionDirect decrypts any payloads directly encrypted to our keys. The lock must already
+//   be held.
+func (rs *Relationships) decryptActionDirect(ctx context.Context, itx *inspector.Transaction,
+	env envelope.BaseMessage) (actions.Action, bitcoin.Hash32, error) {
+
+	decrypted, encryptionKey, err := rs.wallet.DecryptPayloadDirect(ctx, itx.MsgTx, env)
+	if err != nil {
+		return nil, bitcoin.Hash32{}, errors.Wrap(err, "decrypt payload direct")
 	}
 
-	return rs.wallet.DecryptActionDirect(ctx, itx.MsgTx, index, env)
+	action, err := rs.deserializeAction(ctx, itx, env, decrypted)
+	if err != nil {
+		return nil, bitcoin.Hash32{}, errors.Wrap(err, "deserialize action")
+	}
+
+	return action, encryptionKey, nil
+}
+
+// inputOrder returns the input indexes to check for the sender, starting with the sender indexes
+//   in the unencrypted part of the message.
+func inputOrder(ctx context.Context, env envelope.BaseMessage, count int) []int {
+	result := make([]int, 0, count)
+
+	a, err := actions.Deserialize(env.PayloadIdentifier(), env.Payload())
+	if err == nil {
+		if message, ok := a.(*actions.Message); ok {
+			for _, senderIndex := range message.SenderIndexes {
+				if int(senderIndex) < count && !containsInt(result, int(senderIndex)) {
+					result = append(result, int(senderIndex))
+				}
+			}
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if !containsInt(result, i) {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 func (rs *Relationships) Load(ctx context.Context, dbConn *db.DB) error {
@@ -432,12 +630,32 @@ func (rs *Relationships) Load(ctx context.Context, dbConn *db.DB) error {
 		if err != nil {
 			return errors.Wrap(err, "next key")
 		}
+
+		if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "add lookahead keys")
+		}
+	}
+
+	if err := rs.loadHistory(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "load history")
+	}
+
+	if err := rs.loadPolicy(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "load policy")
+	}
+
+	if err := rs.loadTxDeltas(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "load tx deltas")
+	}
+
+	if err := rs.loadSent(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "load sent")
 	}
 
 	return nil
 }
 
-func (rs *Relationships) Save(ctx context.Context, dbConn *db.DB) error {
+func (rs *Relationships) Save(ctx context.Context, dbConn db.Writer) error {
 	rs.lock.Lock()
 	defer rs.lock.Unlock()
 
@@ -450,5 +668,21 @@ func (rs *Relationships) Save(ctx context.Context, dbConn *db.DB) error {
 		return errors.Wrap(err, "put wallet")
 	}
 
+	if err := rs.saveHistory(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "save history")
+	}
+
+	if err := rs.savePolicy(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "save policy")
+	}
+
+	if err := rs.saveTxDeltas(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "save tx deltas")
+	}
+
+	if err := rs.saveSent(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "save sent")
+	}
+
 	return nil
 }
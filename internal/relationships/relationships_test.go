@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/tokenized/envelope/pkg/golang/envelope"
+	"github.com/tokenized/relationship-example/internal/platform/config"
 	"github.com/tokenized/relationship-example/internal/platform/tests"
 	"github.com/tokenized/relationship-example/internal/wallet"
 
@@ -67,7 +68,7 @@ func TestInitiate(t *testing.T) {
 			continue
 		}
 
-		action, _, err := wallet.DecryptActionDirect(ctx, tx, index, env)
+		action, _, err := wallet.DecryptActionDirect(ctx, tx, env)
 		if err != nil {
 			continue
 		}
@@ -170,7 +171,7 @@ func TestInitiateMulti(t *testing.T) {
 			continue
 		}
 
-		action, _, err := wallet.DecryptActionDirect(ctx, tx, index, env)
+		action, _, err := wallet.DecryptActionDirect(ctx, tx, env)
 		if err != nil {
 			continue
 		}
@@ -285,7 +286,7 @@ func TestAcceptDirect(t *testing.T) {
 		}
 
 		var action actions.Action
-		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, index, env)
+		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, env)
 		if err != nil {
 			continue
 		}
@@ -359,7 +360,7 @@ func TestAcceptDirect(t *testing.T) {
 		}
 
 		var action actions.Action
-		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, index, env)
+		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, env)
 		if err != nil {
 			continue
 		}
@@ -502,7 +503,7 @@ func TestAcceptIndirect(t *testing.T) {
 		}
 
 		var action actions.Action
-		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, index, env)
+		action, encryptionKey, err = receiveWallet.DecryptActionDirect(ctx, tx, env)
 		if err != nil {
 			continue
 		}
@@ -788,3 +789,212 @@ func TestMessageIndirect(t *testing.T) {
 			"Sample encrypted message")
 	}
 }
+
+func TestRecoverInitiate(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveCfg := tests.NewMockConfig()
+	receiveCfg.PolicyAutoAccept = true
+
+	receiveWallet, err := tests.NewMockWallet(ctx, receiveCfg)
+	if err != nil {
+		t.Fatalf("Failed to create mock wallet : %s", err)
+	}
+
+	receiveBroadcastTx := tests.NewMockBroadcaster(receiveCfg)
+
+	receiveAddress, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationships address : %s", err)
+	}
+
+	if _, _, err := sendRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveAddress.PublicKey},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	tx := sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1]
+
+	// Rebuild the sender's relationship from the initiate tx as if the relationships were lost.
+	recoverSendRS, err := NewRelationships(cfg, sendWallet, sendBroadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to create relationships : %s", err)
+	}
+	recoverSendRS.SetRecovering(true)
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, recoverSendRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := recoverSendRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if len(recoverSendRS.Relationships) != 1 {
+		t.Fatalf("Wrong send relationship count : got %d, want %d",
+			len(recoverSendRS.Relationships), 1)
+	}
+
+	r := recoverSendRS.Relationships[0]
+	if r.KeyType != wallet.KeyTypeRelateOut {
+		t.Fatalf("Wrong send key type : got %s, want %s", wallet.KeyTypeName[r.KeyType],
+			wallet.KeyTypeName[wallet.KeyTypeRelateOut])
+	}
+
+	if !r.NextKey.Equal(sendRS.Relationships[0].NextKey) {
+		t.Fatalf("Wrong send next key : got %s, want %s", r.NextKey.String(),
+			sendRS.Relationships[0].NextKey.String())
+	}
+
+	// Rebuild the receiver's relationship. It must not be auto accepted again.
+	recoverReceiveRS, err := NewRelationships(receiveCfg, receiveWallet, receiveBroadcastTx)
+	if err != nil {
+		t.Fatalf("Failed to create relationships : %s", err)
+	}
+	recoverReceiveRS.SetRecovering(true)
+
+	sendBroadcastTx.Msgs = append(sendBroadcastTx.Msgs, tx)
+	itx, message, encryptionKey, _ = decryptMessage(t, ctx, receiveCfg, recoverReceiveRS,
+		sendBroadcastTx)
+
+	if err := recoverReceiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	if len(recoverReceiveRS.Relationships) != 1 {
+		t.Fatalf("Wrong receive relationship count : got %d, want %d",
+			len(recoverReceiveRS.Relationships), 1)
+	}
+
+	if recoverReceiveRS.Relationships[0].KeyType != wallet.KeyTypeRelateIn {
+		t.Fatalf("Wrong receive key type : got %s, want %s",
+			wallet.KeyTypeName[recoverReceiveRS.Relationships[0].KeyType],
+			wallet.KeyTypeName[wallet.KeyTypeRelateIn])
+	}
+
+	if !receiveAddress.Used {
+		t.Fatalf("Receive address should be marked used")
+	}
+
+	if len(receiveBroadcastTx.Msgs) != 0 {
+		t.Fatalf("Recovered relationship should not be accepted : %d txs broadcast",
+			len(receiveBroadcastTx.Msgs))
+	}
+}
+
+func TestRetiredKeys(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+	cfg.RelationshipFunding = config.RelationshipFundingSame
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := sendRS.Relationships[0]
+	previousHash := r.NextHash
+
+	if !sendRS.IsKeyActive(r.KeyType, r.KeyIndex, previousHash) {
+		t.Fatalf("Next key should be active")
+	}
+
+	if err := sendRS.SendMessage(ctx, r, &messages.PrivateMessage{Subject: "Test"}); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	if sendRS.IsKeyActive(r.KeyType, r.KeyIndex, previousHash) {
+		t.Fatalf("Used key should not be active")
+	}
+
+	if !sendRS.IsKeyActive(r.KeyType, r.KeyIndex, r.NextHash) {
+		t.Fatalf("Next key should be active")
+	}
+
+	// Leave some bitcoin on the used key.
+	key, err := sendWallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get key : %s", err)
+	}
+
+	key, err = bitcoin.NextKey(key, previousHash)
+	if err != nil {
+		t.Fatalf("Failed to get next key : %s", err)
+	}
+
+	ra, err := key.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get raw address : %s", err)
+	}
+
+	script, err := ra.LockingScript()
+	if err != nil {
+		t.Fatalf("Failed to get locking script : %s", err)
+	}
+
+	if err := sendWallet.CreateUTXO(ctx, &wallet.UTXO{
+		UTXO: bitcoin.UTXO{
+			Hash:          bitcoin.Hash32{1},
+			Index:         0,
+			Value:         1000,
+			LockingScript: script,
+		},
+		KeyType:  r.KeyType,
+		KeyIndex: r.KeyIndex,
+		KeyHash:  &previousHash,
+	}); err != nil {
+		t.Fatalf("Failed to create utxo : %s", err)
+	}
+
+	retired, err := sendWallet.GetRetiredUTXOs(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get retired utxos : %s", err)
+	}
+
+	if len(retired) != 1 {
+		t.Fatalf("Wrong retired utxo count : got %d, want %d", len(retired), 1)
+	}
+
+	utxos, err := sendWallet.GetBitcoinUTXOs(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get bitcoin utxos : %s", err)
+	}
+
+	for _, utxo := range utxos {
+		if utxo.KeyHash != nil {
+			t.Fatalf("Retired utxo should only fund the same relationship")
+		}
+	}
+
+	utxos, err = sendWallet.GetKeyFundingUTXOs(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get key funding utxos : %s", err)
+	}
+
+	found := false
+	for _, utxo := range utxos {
+		if utxo.KeyHash != nil && utxo.KeyHash.Equal(&previousHash) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("Retired utxo should fund the same relationship")
+	}
+}
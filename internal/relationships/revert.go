@@ -0,0 +1,1066 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tokenized/relationship-example/internal/platform/db"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	txDeltasKey = "tx_deltas"
+
+	// MaxTxDeltas is the number of processed txs that can be reverted. Older txs are assumed to be
+	//   too deep in the chain to be reverted.
+	MaxTxDeltas = 1000
+)
+
+// TxDelta contains the changes to relationships made by processing a tx so they can be rolled
+//   back if the tx is reverted.
+type TxDelta struct {
+	TxId bitcoin.Hash32
+
+	// Created contains the txids of the relationships created by the tx.
+	Created []bitcoin.Hash32
+
+	// Changes contains the changes made by the tx to existing relationships.
+	Changes []*RelationshipChange
+}
+
+// RelationshipChange contains the changes made by a tx to a relationship. Only the fields that
+//   the tx changed are reverted, so changes made to the relationship by later txs are kept.
+type RelationshipChange struct {
+	RelationshipTxId bitcoin.Hash32
+
+	// Position is the change to our hash position, or nil if it wasn't changed.
+	Position *PositionChange
+
+	// Flags is the change to the accepted, pending accepted, closed, and declined flags, or nil if
+	//   they weren't changed.
+	Flags *FlagsChange
+
+	// Members contains the changes to each member that was changed.
+	Members []*MemberChange
+
+	// Threads contains the txids of the threads started by the tx.
+	Threads []bitcoin.Hash32
+
+	// Previous is the serialized relationship as it was before the tx when the tx changed the
+	//   structure of the relationship, like an amendment that changes the members or seed. The
+	//   whole relationship is restored to revert it.
+	Previous []byte
+}
+
+// MemberChange contains the changes made by a tx to a member of a relationship. The member is
+//   identified by its base key.
+type MemberChange struct {
+	BaseKey  bitcoin.PublicKey
+	Position *PositionChange
+	Flags    *FlagsChange
+	Identity *IdentityChange
+}
+
+// PositionChange is a change to a position in a hash chain.
+type PositionChange struct {
+	FromHash  bitcoin.Hash32
+	FromIndex uint64
+	ToHash    bitcoin.Hash32
+	ToIndex   uint64
+}
+
+// FlagsChange is a change to the state flags of a relationship or member.
+type FlagsChange struct {
+	From uint8
+	To   uint8
+}
+
+// IdentityChange is a change to the proof of identity of a member.
+type IdentityChange struct {
+	From MemberIdentity
+	To   MemberIdentity
+}
+
+// MemberIdentity is the proof of identity provided by a member and the result of verifying it.
+type MemberIdentity struct {
+	ProofOfIdentityType uint32
+	ProofOfIdentity     []byte
+	Identity            string
+	IdentityStatus      uint8
+}
+
+// State flags of relationships and members recorded in tx deltas.
+const (
+	stateAccepted        = uint8(0x01)
+	statePendingAccepted = uint8(0x02)
+	stateClosed          = uint8(0x04)
+	stateDeclined        = uint8(0x08)
+)
+
+// BeginTx records the state of the relationships before a tx is processed. EndTx must be called
+//   after the tx is processed.
+func (rs *Relationships) BeginTx(ctx context.Context, txid bitcoin.Hash32) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.txSnapshot = make(map[bitcoin.Hash32][]byte)
+	for _, r := range rs.Relationships {
+		var buf bytes.Buffer
+		if err := r.Serialize(&buf); err != nil {
+			logger.Error(ctx, "Failed to serialize relationship %s : %s", r.TxId.String(), err)
+			continue
+		}
+		rs.txSnapshot[r.TxId] = buf.Bytes()
+	}
+}
+
+// EndTx compares the relationships to the state recorded by BeginTx and saves the changes made
+//   by the tx so it can be reverted.
+func (rs *Relationships) EndTx(ctx context.Context, txid bitcoin.Hash32) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if rs.txSnapshot == nil {
+		return
+	}
+
+	delta := &TxDelta{TxId: txid}
+	for _, r := range rs.Relationships {
+		b, exists := rs.txSnapshot[r.TxId]
+		if !exists {
+			delta.Created = append(delta.Created, r.TxId)
+			continue
+		}
+
+		previous := &Relationship{}
+		if err := previous.Deserialize(bytes.NewReader(b)); err != nil {
+			logger.Error(ctx, "Failed to deserialize relationship %s : %s", r.TxId.String(), err)
+			continue
+		}
+
+		if change := relationshipChange(previous, r, b); change != nil {
+			delta.Changes = append(delta.Changes, change)
+		}
+	}
+	rs.txSnapshot = nil
+
+	if len(delta.Created) == 0 && len(delta.Changes) == 0 {
+		return // no relationship changes
+	}
+
+	logger.Info(ctx, "Tx %s created %d and changed %d relationships", txid.String(),
+		len(delta.Created), len(delta.Changes))
+
+	// Replace any delta from the tx being processed previously.
+	for i, d := range rs.txDeltas {
+		if d.TxId.Equal(&txid) {
+			rs.txDeltas = append(rs.txDeltas[:i], rs.txDeltas[i+1:]...)
+			break
+		}
+	}
+
+	rs.txDeltas = append(rs.txDeltas, delta)
+	if len(rs.txDeltas) > MaxTxDeltas {
+		rs.txDeltas = rs.txDeltas[len(rs.txDeltas)-MaxTxDeltas:]
+	}
+}
+
+// RevertTx rolls back the changes made to the relationships by the tx. Relationships created by
+//   the tx are removed and the fields changed by the tx are set back to their values before the
+//   tx, unless a later tx changed them again. Relationships whose structure was changed by the tx
+//   are restored completely, so they lose any changes made by later txs and the deltas of those
+//   txs are dropped. The tx's messages are removed from the history.
+// Returns false if the tx didn't change any relationships.
+func (rs *Relationships) RevertTx(ctx context.Context, txid bitcoin.Hash32) (bool, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.removeTxHistory(ctx, txid)
+
+	index := -1
+	for i, d := range rs.txDeltas {
+		if d.TxId.Equal(&txid) {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return false, nil
+	}
+
+	delta := rs.txDeltas[index]
+	logger.Info(ctx, "Reverting relationship changes from tx : %s", txid.String())
+
+	for _, created := range delta.Created {
+		if err := rs.removeRelationship(ctx, created); err != nil {
+			return false, errors.Wrap(err, "remove relationship")
+		}
+	}
+
+	restored := make(map[bitcoin.Hash32]bool)
+	for _, c := range delta.Changes {
+		if len(c.Previous) > 0 {
+			previous := &Relationship{}
+			if err := previous.Deserialize(bytes.NewReader(c.Previous)); err != nil {
+				return false, errors.Wrap(err, "deserialize relationship")
+			}
+
+			if err := rs.restoreRelationship(ctx, previous); err != nil {
+				return false, errors.Wrap(err, "restore relationship")
+			}
+			restored[previous.TxId] = true
+			continue
+		}
+
+		if err := rs.revertChange(ctx, c); err != nil {
+			return false, errors.Wrap(err, "revert change")
+		}
+	}
+
+	// Drop the deltas of later txs that changed the relationships that were removed or completely
+	//   restored since those changes were rolled back too.
+	remaining := rs.txDeltas[:index]
+	for _, d := range rs.txDeltas[index+1:] {
+		if d.affects(delta, restored) {
+			logger.Warn(ctx, "Changes from tx %s rolled back by revert of tx : %s",
+				d.TxId.String(), txid.String())
+			continue
+		}
+		remaining = append(remaining, d)
+	}
+	rs.txDeltas = remaining
+
+	return true, nil
+}
+
+// affects returns true if the delta changed any of the relationships created or restored by the
+//   other delta.
+func (d *TxDelta) affects(other *TxDelta, restored map[bitcoin.Hash32]bool) bool {
+	for _, c := range d.Changes {
+		if restored[c.RelationshipTxId] {
+			return true
+		}
+
+		for _, created := range other.Created {
+			if created.Equal(&c.RelationshipTxId) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// relationshipChange returns the changes from the previous state of the relationship to its
+//   current state, or nil if there are none. b is the serialized previous state.
+func relationshipChange(previous, r *Relationship, b []byte) *RelationshipChange {
+	result := &RelationshipChange{RelationshipTxId: r.TxId}
+
+	if structureChanged(previous, r) {
+		result.Previous = b
+		return result
+	}
+
+	result.Position = positionChange(previous.NextHash, previous.NextIndex, r.NextHash,
+		r.NextIndex)
+	result.Flags = flagsChange(relationshipFlags(previous), relationshipFlags(r))
+
+	for i, m := range r.Members {
+		if mc := memberChange(previous.Members[i], m); mc != nil {
+			result.Members = append(result.Members, mc)
+		}
+	}
+
+	for _, t := range r.Threads {
+		if previous.FindThread(t.TxId) == nil {
+			result.Threads = append(result.Threads, t.TxId)
+		}
+	}
+
+	if result.Position == nil && result.Flags == nil && len(result.Members) == 0 &&
+		len(result.Threads) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// structureChanged returns true if the keys, encryption, or members of the relationship changed.
+func structureChanged(previous, r *Relationship) bool {
+	if previous.KeyType != r.KeyType || previous.KeyIndex != r.KeyIndex ||
+		!bytes.Equal(previous.Seed, r.Seed) || !bytes.Equal(previous.Flag, r.Flag) ||
+		previous.EncryptionType != r.EncryptionType ||
+		!previous.EncryptionKey.Equal(&r.EncryptionKey) ||
+		len(previous.Members) != len(r.Members) {
+		return true
+	}
+
+	for i, m := range r.Members {
+		if !m.BaseKey.Equal(previous.Members[i].BaseKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// memberChange returns the changes from the previous state of the member to its current state, or
+//   nil if there are none.
+func memberChange(previous, m *Member) *MemberChange {
+	result := &MemberChange{
+		BaseKey:  m.BaseKey,
+		Position: positionChange(previous.NextHash, previous.NextIndex, m.NextHash, m.NextIndex),
+		Flags:    flagsChange(memberFlags(previous), memberFlags(m)),
+	}
+
+	from := memberIdentity(previous)
+	to := memberIdentity(m)
+	if !from.Equal(to) {
+		result.Identity = &IdentityChange{From: from, To: to}
+	}
+
+	if result.Position == nil && result.Flags == nil && result.Identity == nil {
+		return nil
+	}
+
+	return result
+}
+
+func positionChange(fromHash bitcoin.Hash32, fromIndex uint64, toHash bitcoin.Hash32,
+	toIndex uint64) *PositionChange {
+
+	if fromIndex == toIndex && fromHash.Equal(&toHash) {
+		return nil
+	}
+
+	return &PositionChange{
+		FromHash:  fromHash,
+		FromIndex: fromIndex,
+		ToHash:    toHash,
+		ToIndex:   toIndex,
+	}
+}
+
+func flagsChange(from, to uint8) *FlagsChange {
+	if from == to {
+		return nil
+	}
+
+	return &FlagsChange{From: from, To: to}
+}
+
+// revert returns the flags with each flag that was changed set back to its previous value, unless
+//   it was changed again since.
+func (c FlagsChange) revert(current uint8) uint8 {
+	for bit := uint8(1); bit != 0; bit <<= 1 {
+		if (c.From^c.To)&bit == 0 || current&bit != c.To&bit {
+			continue
+		}
+		current = (current &^ bit) | (c.From & bit)
+	}
+	return current
+}
+
+// isCurrent returns true if the hash chain position is still the one the change moved it to.
+func (c PositionChange) isCurrent(hash bitcoin.Hash32, index uint64) bool {
+	return index == c.ToIndex && hash.Equal(&c.ToHash)
+}
+
+func relationshipFlags(r *Relationship) uint8 {
+	return stateFlags(r.Accepted, r.PendingAccepted, r.Closed, r.Declined)
+}
+
+func memberFlags(m *Member) uint8 {
+	return stateFlags(m.Accepted, m.PendingAccepted, m.Closed, m.Declined)
+}
+
+func stateFlags(accepted, pendingAccepted, closed, declined bool) uint8 {
+	var result uint8
+	if accepted {
+		result |= stateAccepted
+	}
+	if pendingAccepted {
+		result |= statePendingAccepted
+	}
+	if closed {
+		result |= stateClosed
+	}
+	if declined {
+		result |= stateDeclined
+	}
+	return result
+}
+
+func memberIdentity(m *Member) MemberIdentity {
+	return MemberIdentity{
+		ProofOfIdentityType: m.ProofOfIdentityType,
+		ProofOfIdentity:     m.ProofOfIdentity,
+		Identity:            m.Identity,
+		IdentityStatus:      m.IdentityStatus,
+	}
+}
+
+func (id MemberIdentity) Equal(other MemberIdentity) bool {
+	return id.ProofOfIdentityType == other.ProofOfIdentityType &&
+		bytes.Equal(id.ProofOfIdentity, other.ProofOfIdentity) && id.Identity == other.Identity &&
+		id.IdentityStatus == other.IdentityStatus
+}
+
+// revertChange sets the fields of the relationship changed by a tx back to their values before the
+//   tx. Fields that were changed again by a later tx are left as they are. The lock must already be
+//   held.
+func (rs *Relationships) revertChange(ctx context.Context, c *RelationshipChange) error {
+	r := rs.findRelationship(c.RelationshipTxId)
+	if r == nil {
+		return fmt.Errorf("Relationship not found : %s", c.RelationshipTxId.String())
+	}
+
+	wasClosed := r.Closed
+
+	if c.Flags != nil {
+		flags := c.Flags.revert(relationshipFlags(r))
+		r.Accepted = flags&stateAccepted != 0
+		r.PendingAccepted = flags&statePendingAccepted != 0
+		r.Closed = flags&stateClosed != 0
+		r.Declined = flags&stateDeclined != 0
+	}
+
+	positionReverted := c.Position != nil && c.Position.isCurrent(r.NextHash, r.NextIndex)
+	if positionReverted {
+		logger.Info(ctx, "Restoring relationship to index %d : %s", c.Position.FromIndex,
+			r.TxId.String())
+
+		if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "remove keys")
+		}
+
+		r.NextHash = c.Position.FromHash
+		r.NextIndex = c.Position.FromIndex
+	}
+
+	if positionReverted || (wasClosed && !r.Closed) {
+		key, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+		if err != nil {
+			return errors.Wrap(err, "get key")
+		}
+
+		r.NextKey, err = bitcoin.NextPublicKey(key.PublicKey(), r.NextHash)
+		if err != nil {
+			return errors.Wrap(err, "next key")
+		}
+
+		if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "add lookahead keys")
+		}
+	}
+
+	for _, mc := range c.Members {
+		m := r.findMember(mc.BaseKey)
+		if m == nil {
+			continue // dropped by a later amendment
+		}
+
+		if mc.Position != nil && mc.Position.isCurrent(m.NextHash, m.NextIndex) {
+			m.NextHash = mc.Position.FromHash
+			m.NextIndex = mc.Position.FromIndex
+			m.NextKey, _ = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+			m.lookahead = nil
+		}
+
+		if mc.Flags != nil {
+			flags := mc.Flags.revert(memberFlags(m))
+			m.Accepted = flags&stateAccepted != 0
+			m.PendingAccepted = flags&statePendingAccepted != 0
+			m.Closed = flags&stateClosed != 0
+			m.Declined = flags&stateDeclined != 0
+		}
+
+		if mc.Identity != nil && memberIdentity(m).Equal(mc.Identity.To) {
+			m.ProofOfIdentityType = mc.Identity.From.ProofOfIdentityType
+			m.ProofOfIdentity = mc.Identity.From.ProofOfIdentity
+			m.Identity = mc.Identity.From.Identity
+			m.IdentityStatus = mc.Identity.From.IdentityStatus
+		}
+	}
+
+	for _, txid := range c.Threads {
+		for i, t := range r.Threads {
+			if t.TxId.Equal(&txid) {
+				r.Threads = append(r.Threads[:i], r.Threads[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// findRelationship returns the relationship initiated by the tx. The lock must already be held.
+func (rs *Relationships) findRelationship(txid bitcoin.Hash32) *Relationship {
+	for _, r := range rs.Relationships {
+		if r.TxId.Equal(&txid) {
+			return r
+		}
+	}
+	return nil
+}
+
+// findMember returns the member with the base key.
+func (r *Relationship) findMember(baseKey bitcoin.PublicKey) *Member {
+	for _, m := range r.Members {
+		if m.BaseKey.Equal(baseKey) {
+			return m
+		}
+	}
+	return nil
+}
+
+// removeRelationship stops monitoring the relationship's keys and removes it and its history. The
+//   lock must already be held.
+func (rs *Relationships) removeRelationship(ctx context.Context, txid bitcoin.Hash32) error {
+	for i, r := range rs.Relationships {
+		if !r.TxId.Equal(&txid) {
+			continue
+		}
+
+		logger.Info(ctx, "Removing relationship : %s", txid.String())
+
+		if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "remove keys")
+		}
+
+		rs.Relationships = append(rs.Relationships[:i], rs.Relationships[i+1:]...)
+		delete(rs.history, txid)
+		return nil
+	}
+
+	return nil
+}
+
+// restoreRelationship replaces the current state of the relationship with the previous state and
+//   updates the monitored keys. The lock must already be held.
+func (rs *Relationships) restoreRelationship(ctx context.Context, previous *Relationship) error {
+	for _, r := range rs.Relationships {
+		if !r.TxId.Equal(&previous.TxId) {
+			continue
+		}
+
+		logger.Info(ctx, "Restoring relationship to index %d : %s", previous.NextIndex,
+			r.TxId.String())
+
+		if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "remove keys")
+		}
+
+		*r = *previous
+
+		key, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+		if err != nil {
+			return errors.Wrap(err, "get key")
+		}
+
+		r.NextKey, err = bitcoin.NextPublicKey(key.PublicKey(), r.NextHash)
+		if err != nil {
+			return errors.Wrap(err, "next key")
+		}
+
+		if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "add lookahead keys")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Relationship not found : %s", previous.TxId.String())
+}
+
+// removeTxHistory removes the messages in the tx from the history. The lock must already be held.
+func (rs *Relationships) removeTxHistory(ctx context.Context, txid bitcoin.Hash32) {
+	for rtxid, list := range rs.history {
+		for i, m := range list {
+			if m.TxId.Equal(&txid) {
+				logger.Info(ctx, "Removing message from history : %s", txid.String())
+				rs.history[rtxid] = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// loadTxDeltas loads the deltas of processed txs. The lock must already be held.
+func (rs *Relationships) loadTxDeltas(ctx context.Context, dbConn *db.DB) error {
+	b, err := dbConn.Fetch(ctx, txDeltasKey)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil
+		}
+		return errors.Wrap(err, "fetch tx deltas")
+	}
+
+	buf := bytes.NewReader(b)
+
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "tx deltas size")
+	}
+
+	rs.txDeltas = make([]*TxDelta, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var d TxDelta
+		if err := d.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "tx delta %d", i)
+		}
+		rs.txDeltas = append(rs.txDeltas, &d)
+	}
+
+	return nil
+}
+
+// saveTxDeltas saves the deltas of processed txs. The lock must already be held.
+func (rs *Relationships) saveTxDeltas(ctx context.Context, dbConn db.Writer) error {
+	var buf bytes.Buffer
+
+	// Version
+	if err := binary.Write(&buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(rs.txDeltas))); err != nil {
+		return errors.Wrap(err, "tx deltas size")
+	}
+
+	for i, d := range rs.txDeltas {
+		if err := d.Serialize(&buf); err != nil {
+			return errors.Wrapf(err, "tx delta %d", i)
+		}
+	}
+
+	if err := dbConn.Put(ctx, txDeltasKey, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "put tx deltas")
+	}
+
+	return nil
+}
+
+func (d TxDelta) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := d.TxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(d.Created))); err != nil {
+		return errors.Wrap(err, "created size")
+	}
+	for _, txid := range d.Created {
+		if err := txid.Serialize(buf); err != nil {
+			return errors.Wrap(err, "created")
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(d.Changes))); err != nil {
+		return errors.Wrap(err, "changes size")
+	}
+	for i, c := range d.Changes {
+		if err := c.Serialize(buf); err != nil {
+			return errors.Wrapf(err, "change %d", i)
+		}
+	}
+
+	return nil
+}
+
+func (d *TxDelta) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := d.TxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "created size")
+	}
+	d.Created = make([]bitcoin.Hash32, count)
+	for i := range d.Created {
+		if err := d.Created[i].Deserialize(buf); err != nil {
+			return errors.Wrap(err, "created")
+		}
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "changes size")
+	}
+	d.Changes = make([]*RelationshipChange, 0, count)
+	for i := uint32(0); i < count; i++ {
+		c := &RelationshipChange{}
+		if err := c.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "change %d", i)
+		}
+		d.Changes = append(d.Changes, c)
+	}
+
+	return nil
+}
+
+func (c RelationshipChange) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := c.RelationshipTxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "relationship txid")
+	}
+
+	if err := writePositionChange(buf, c.Position); err != nil {
+		return errors.Wrap(err, "position")
+	}
+
+	if err := writeFlagsChange(buf, c.Flags); err != nil {
+		return errors.Wrap(err, "flags")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(c.Members))); err != nil {
+		return errors.Wrap(err, "members size")
+	}
+	for i, mc := range c.Members {
+		if err := mc.Serialize(buf); err != nil {
+			return errors.Wrapf(err, "member %d", i)
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(c.Threads))); err != nil {
+		return errors.Wrap(err, "threads size")
+	}
+	for _, txid := range c.Threads {
+		if err := txid.Serialize(buf); err != nil {
+			return errors.Wrap(err, "thread")
+		}
+	}
+
+	if err := writeDeltaBytes(buf, c.Previous); err != nil {
+		return errors.Wrap(err, "previous")
+	}
+
+	return nil
+}
+
+func (c *RelationshipChange) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := c.RelationshipTxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "relationship txid")
+	}
+
+	var err error
+	c.Position, err = readPositionChange(buf)
+	if err != nil {
+		return errors.Wrap(err, "position")
+	}
+
+	c.Flags, err = readFlagsChange(buf)
+	if err != nil {
+		return errors.Wrap(err, "flags")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "members size")
+	}
+	c.Members = make([]*MemberChange, 0, count)
+	for i := uint32(0); i < count; i++ {
+		mc := &MemberChange{}
+		if err := mc.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "member %d", i)
+		}
+		c.Members = append(c.Members, mc)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "threads size")
+	}
+	c.Threads = make([]bitcoin.Hash32, count)
+	for i := range c.Threads {
+		if err := c.Threads[i].Deserialize(buf); err != nil {
+			return errors.Wrap(err, "thread")
+		}
+	}
+
+	c.Previous, err = readDeltaBytes(buf)
+	if err != nil {
+		return errors.Wrap(err, "previous")
+	}
+
+	return nil
+}
+
+func (mc MemberChange) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := mc.BaseKey.Serialize(buf); err != nil {
+		return errors.Wrap(err, "base key")
+	}
+
+	if err := writePositionChange(buf, mc.Position); err != nil {
+		return errors.Wrap(err, "position")
+	}
+
+	if err := writeFlagsChange(buf, mc.Flags); err != nil {
+		return errors.Wrap(err, "flags")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, mc.Identity != nil); err != nil {
+		return errors.Wrap(err, "identity included")
+	}
+	if mc.Identity != nil {
+		if err := mc.Identity.From.Serialize(buf); err != nil {
+			return errors.Wrap(err, "identity from")
+		}
+		if err := mc.Identity.To.Serialize(buf); err != nil {
+			return errors.Wrap(err, "identity to")
+		}
+	}
+
+	return nil
+}
+
+func (mc *MemberChange) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := mc.BaseKey.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "base key")
+	}
+
+	var err error
+	mc.Position, err = readPositionChange(buf)
+	if err != nil {
+		return errors.Wrap(err, "position")
+	}
+
+	mc.Flags, err = readFlagsChange(buf)
+	if err != nil {
+		return errors.Wrap(err, "flags")
+	}
+
+	var included bool
+	if err := binary.Read(buf, binary.LittleEndian, &included); err != nil {
+		return errors.Wrap(err, "identity included")
+	}
+	if included {
+		mc.Identity = &IdentityChange{}
+		if err := mc.Identity.From.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "identity from")
+		}
+		if err := mc.Identity.To.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "identity to")
+		}
+	}
+
+	return nil
+}
+
+func (id MemberIdentity) Serialize(buf *bytes.Buffer) error {
+	if err := binary.Write(buf, binary.LittleEndian, id.ProofOfIdentityType); err != nil {
+		return errors.Wrap(err, "proof of identity type")
+	}
+
+	if err := writeDeltaBytes(buf, id.ProofOfIdentity); err != nil {
+		return errors.Wrap(err, "proof of identity")
+	}
+
+	if err := writeDeltaBytes(buf, []byte(id.Identity)); err != nil {
+		return errors.Wrap(err, "identity")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, id.IdentityStatus); err != nil {
+		return errors.Wrap(err, "identity status")
+	}
+
+	return nil
+}
+
+func (id *MemberIdentity) Deserialize(buf *bytes.Reader) error {
+	if err := binary.Read(buf, binary.LittleEndian, &id.ProofOfIdentityType); err != nil {
+		return errors.Wrap(err, "proof of identity type")
+	}
+
+	var err error
+	id.ProofOfIdentity, err = readDeltaBytes(buf)
+	if err != nil {
+		return errors.Wrap(err, "proof of identity")
+	}
+
+	identity, err := readDeltaBytes(buf)
+	if err != nil {
+		return errors.Wrap(err, "identity")
+	}
+	id.Identity = string(identity)
+
+	if err := binary.Read(buf, binary.LittleEndian, &id.IdentityStatus); err != nil {
+		return errors.Wrap(err, "identity status")
+	}
+
+	return nil
+}
+
+// writePositionChange writes a bool specifying if the position changed, followed by the change.
+func writePositionChange(buf *bytes.Buffer, c *PositionChange) error {
+	if err := binary.Write(buf, binary.LittleEndian, c != nil); err != nil {
+		return errors.Wrap(err, "included")
+	}
+	if c == nil {
+		return nil
+	}
+
+	if err := c.FromHash.Serialize(buf); err != nil {
+		return errors.Wrap(err, "from hash")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.FromIndex); err != nil {
+		return errors.Wrap(err, "from index")
+	}
+	if err := c.ToHash.Serialize(buf); err != nil {
+		return errors.Wrap(err, "to hash")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.ToIndex); err != nil {
+		return errors.Wrap(err, "to index")
+	}
+
+	return nil
+}
+
+func readPositionChange(buf *bytes.Reader) (*PositionChange, error) {
+	var included bool
+	if err := binary.Read(buf, binary.LittleEndian, &included); err != nil {
+		return nil, errors.Wrap(err, "included")
+	}
+	if !included {
+		return nil, nil
+	}
+
+	c := &PositionChange{}
+	if err := c.FromHash.Deserialize(buf); err != nil {
+		return nil, errors.Wrap(err, "from hash")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &c.FromIndex); err != nil {
+		return nil, errors.Wrap(err, "from index")
+	}
+	if err := c.ToHash.Deserialize(buf); err != nil {
+		return nil, errors.Wrap(err, "to hash")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &c.ToIndex); err != nil {
+		return nil, errors.Wrap(err, "to index")
+	}
+
+	return c, nil
+}
+
+// writeFlagsChange writes a bool specifying if the flags changed, followed by the change.
+func writeFlagsChange(buf *bytes.Buffer, c *FlagsChange) error {
+	if err := binary.Write(buf, binary.LittleEndian, c != nil); err != nil {
+		return errors.Wrap(err, "included")
+	}
+	if c == nil {
+		return nil
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, c.From); err != nil {
+		return errors.Wrap(err, "from")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.To); err != nil {
+		return errors.Wrap(err, "to")
+	}
+
+	return nil
+}
+
+func readFlagsChange(buf *bytes.Reader) (*FlagsChange, error) {
+	var included bool
+	if err := binary.Read(buf, binary.LittleEndian, &included); err != nil {
+		return nil, errors.Wrap(err, "included")
+	}
+	if !included {
+		return nil, nil
+	}
+
+	c := &FlagsChange{}
+	if err := binary.Read(buf, binary.LittleEndian, &c.From); err != nil {
+		return nil, errors.Wrap(err, "from")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &c.To); err != nil {
+		return nil, errors.Wrap(err, "to")
+	}
+
+	return c, nil
+}
+
+// writeDeltaBytes writes the size of the bytes followed by the bytes.
+func writeDeltaBytes(buf *bytes.Buffer, b []byte) error {
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(b))); err != nil {
+		return errors.Wrap(err, "size")
+	}
+	if _, err := buf.Write(b); err != nil {
+		return errors.Wrap(err, "bytes")
+	}
+	return nil
+}
+
+func readDeltaBytes(buf *bytes.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return nil, errors.Wrap(err, "size")
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(buf, b); err != nil {
+		return nil, errors.Wrap(err, "bytes")
+	}
+	return b, nil
+}
@@ -0,0 +1,318 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+	"github.com/tokenized/relationship-example/internal/wallet"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+)
+
+func TestRevertInitiate(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	_, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, _, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	receiveAddress, err := receiveWallet.GetUnusedAddress(ctx, wallet.KeyTypeRelateIn)
+	if err != nil {
+		t.Fatalf("Failed to get relationships address : %s", err)
+	}
+
+	if _, _, err := sendRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveAddress.PublicKey},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	for i := 0; i < 2; i++ {
+		receiveRS.BeginTx(ctx, *itx.Hash)
+		if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+			encryptionKey); err != nil {
+			t.Fatalf("Failed to process initiate : %s", err)
+		}
+		receiveRS.EndTx(ctx, *itx.Hash)
+
+		if len(receiveRS.Relationships) != 1 {
+			t.Fatalf("Wrong relationship count : got %d, want %d", len(receiveRS.Relationships), 1)
+		}
+
+		if i == 1 {
+			break // reapplied after revert
+		}
+
+		reverted, err := receiveRS.RevertTx(ctx, *itx.Hash)
+		if err != nil {
+			t.Fatalf("Failed to revert tx : %s", err)
+		}
+
+		if !reverted {
+			t.Fatalf("Tx should be reverted")
+		}
+
+		if len(receiveRS.Relationships) != 0 {
+			t.Fatalf("Wrong reverted relationship count : got %d, want %d",
+				len(receiveRS.Relationships), 0)
+		}
+	}
+}
+
+func TestRevertMessage(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	sendPrivateMessage := &messages.PrivateMessage{
+		Subject: "Sample message",
+	}
+
+	if err := sendRS.SendMessage(ctx, sendRS.Relationships[0], sendPrivateMessage); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	r := receiveRS.Relationships[0]
+	previousIndex := r.Members[0].NextIndex
+	previousHash := r.Members[0].NextHash
+
+	for i := 0; i < 2; i++ {
+		receiveRS.BeginTx(ctx, *itx.Hash)
+		if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+			flag); err != nil {
+			t.Fatalf("Failed to process message : %s", err)
+		}
+		receiveRS.EndTx(ctx, *itx.Hash)
+
+		if r.Members[0].NextIndex != previousIndex+1 {
+			t.Fatalf("Wrong member next index : got %d, want %d", r.Members[0].NextIndex,
+				previousIndex+1)
+		}
+
+		if len(receiveRS.GetHistory(ctx, r)) != 1 {
+			t.Fatalf("Wrong history count : got %d, want %d",
+				len(receiveRS.GetHistory(ctx, r)), 1)
+		}
+
+		if i == 1 {
+			break // reapplied after revert
+		}
+
+		if _, err := receiveRS.RevertTx(ctx, *itx.Hash); err != nil {
+			t.Fatalf("Failed to revert tx : %s", err)
+		}
+
+		if r.Members[0].NextIndex != previousIndex {
+			t.Fatalf("Wrong reverted member next index : got %d, want %d",
+				r.Members[0].NextIndex, previousIndex)
+		}
+
+		if !r.Members[0].NextHash.Equal(&previousHash) {
+			t.Fatalf("Wrong reverted member next hash : got %s, want %s",
+				r.Members[0].NextHash.String(), previousHash.String())
+		}
+
+		if len(receiveRS.GetHistory(ctx, r)) != 0 {
+			t.Fatalf("Wrong reverted history count : got %d, want %d",
+				len(receiveRS.GetHistory(ctx, r)), 0)
+		}
+	}
+}
+
+func TestRevertFirstOfTwoMessages(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := receiveRS.Relationships[0]
+	previousIndex := r.Members[0].NextIndex
+
+	// Process two messages that both change the sender's position in the relationship.
+	var txids []bitcoin.Hash32
+	for _, subject := range []string{"First message", "Second message"} {
+		if err := sendRS.SendMessage(ctx, sendRS.Relationships[0], &messages.PrivateMessage{
+			Subject: subject,
+		}); err != nil {
+			t.Fatalf("Failed to send message : %s", err)
+		}
+
+		itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+		p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+		if err != nil {
+			t.Fatalf("Failed to deserialize message payload : %s", err)
+		}
+
+		privateMessage, ok := p.(*messages.PrivateMessage)
+		if !ok {
+			t.Fatalf("Wrong message type")
+		}
+
+		receiveRS.BeginTx(ctx, *itx.Hash)
+		if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+			flag); err != nil {
+			t.Fatalf("Failed to process message : %s", err)
+		}
+		receiveRS.EndTx(ctx, *itx.Hash)
+
+		txids = append(txids, *itx.Hash)
+	}
+
+	if r.Members[0].NextIndex != previousIndex+2 {
+		t.Fatalf("Wrong member next index : got %d, want %d", r.Members[0].NextIndex,
+			previousIndex+2)
+	}
+
+	// Reverting the first tx keeps the changes made by the second.
+	if _, err := receiveRS.RevertTx(ctx, txids[0]); err != nil {
+		t.Fatalf("Failed to revert tx : %s", err)
+	}
+
+	if r.Members[0].NextIndex != previousIndex+2 {
+		t.Fatalf("Wrong member next index after first revert : got %d, want %d",
+			r.Members[0].NextIndex, previousIndex+2)
+	}
+
+	history := receiveRS.GetHistory(ctx, r)
+	if len(history) != 1 || !history[0].TxId.Equal(&txids[1]) {
+		t.Fatalf("History should only contain the second message")
+	}
+
+	// The second tx can still be reverted.
+	reverted, err := receiveRS.RevertTx(ctx, txids[1])
+	if err != nil {
+		t.Fatalf("Failed to revert tx : %s", err)
+	}
+
+	if !reverted {
+		t.Fatalf("Second tx should be reverted")
+	}
+
+	if r.Members[0].NextIndex != previousIndex+1 {
+		t.Fatalf("Wrong member next index after second revert : got %d, want %d",
+			r.Members[0].NextIndex, previousIndex+1)
+	}
+
+	if len(receiveRS.GetHistory(ctx, r)) != 0 {
+		t.Fatalf("Wrong reverted history count : got %d, want %d",
+			len(receiveRS.GetHistory(ctx, r)), 0)
+	}
+}
+
+func TestRevertInitiateKeepsOtherKeys(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	receiveR := receiveRS.Relationships[0]
+
+	// Initiate another relationship to the same receive key.
+	receiveKey, err := receiveWallet.GetKey(ctx, receiveR.KeyType, receiveR.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get receive key : %s", err)
+	}
+
+	_, otherBroadcastTx, otherRS := newTestRelationships(t, ctx, cfg)
+
+	if _, _, err := otherRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveKey.PublicKey()},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, otherBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	receiveRS.BeginTx(ctx, *itx.Hash)
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+	receiveRS.EndTx(ctx, *itx.Hash)
+
+	if len(receiveRS.Relationships) != 2 {
+		t.Fatalf("Wrong relationship count : got %d, want %d", len(receiveRS.Relationships), 2)
+	}
+
+	reverted, err := receiveRS.RevertTx(ctx, *itx.Hash)
+	if err != nil {
+		t.Fatalf("Failed to revert tx : %s", err)
+	}
+
+	if !reverted {
+		t.Fatalf("Tx should be reverted")
+	}
+
+	if len(receiveRS.Relationships) != 1 {
+		t.Fatalf("Wrong reverted relationship count : got %d, want %d",
+			len(receiveRS.Relationships), 1)
+	}
+
+	nextAddress, err := receiveR.NextKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get next address : %s", err)
+	}
+
+	hashes, err := nextAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get next address hashes : %s", err)
+	}
+
+	if monitored, _ := receiveWallet.AreHashesMonitored(hashes); !monitored {
+		t.Fatalf("Next key of other relationship not monitored")
+	}
+}
@@ -0,0 +1,515 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tokenized/relationship-example/internal/platform/db"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sentKey = "sent"
+
+	// MaxSentMessages is the number of unconfirmed messages we sent that are kept so they can be
+	//   sent again if their txs are cancelled. The oldest is dropped, with a warning, when there
+	//   are more.
+	MaxSentMessages = 100
+)
+
+var (
+	// ErrNotCancelled means the message's tx wasn't cancelled, so it can't be sent again.
+	ErrNotCancelled = errors.New("Message tx not cancelled")
+
+	// ErrNotResendable means the tx contains a relationship change, like an initiation or
+	//   amendment, or a co-signed message, which has to be created again instead of resent.
+	ErrNotResendable = errors.New("Tx can't be sent again")
+)
+
+// SentMessage is a relationship tx we sent that isn't confirmed yet. It contains what is needed to
+//   send the message again, and to move the hashes back, if the tx is cancelled.
+type SentMessage struct {
+	TxId             bitcoin.Hash32
+	RelationshipTxId bitcoin.Hash32
+	MessageCode      uint32
+	Payload          []byte
+
+	// Timestamp is the time the message was sent in nanoseconds since the unix epoch.
+	Timestamp uint64
+
+	// KeyHash and KeyIndex are the position of our next key in the hash chain before the message
+	//   was sent. KeyUsed is false when the tx wasn't sent from our next key, like an initiation,
+	//   which is sent from the relationship's base key.
+	KeyUsed  bool
+	KeyHash  bitcoin.Hash32
+	KeyIndex uint64
+
+	// Members contains the hash positions of the members that co-signed the message, before it was
+	//   sent. It is empty for messages only we signed.
+	Members []*SentMemberHash
+
+	// Failed is true when the tx was cancelled.
+	Failed bool
+}
+
+// SentMemberHash is the position in a member's hash chain before a message was sent to them.
+type SentMemberHash struct {
+	MemberIndex uint32
+	Hash        bitcoin.Hash32
+	Index       uint64
+}
+
+// CancelTx handles one of our message txs being double spent or abandoned. Our hash, and the
+//   hashes of the members it was sent to, are moved back to the keys it used when no later message
+//   used the next keys. The message is marked failed in the history so it can be sent again with
+//   Resend.
+// Returns false if the tx isn't an unconfirmed message we sent.
+func (rs *Relationships) CancelTx(ctx context.Context, txid bitcoin.Hash32) (bool, error) {
+	r, sent, err := rs.cancelSent(ctx, txid)
+	if err != nil {
+		return false, err
+	}
+	if sent == nil {
+		return false, nil
+	}
+
+	if r == nil {
+		return true, nil // relationship was removed
+	}
+
+	if err := rs.markSentFailed(ctx, r, sent); err != nil {
+		return true, errors.Wrap(err, "mark failed")
+	}
+
+	return true, nil
+}
+
+// Resend sends the message from one of our cancelled txs again from our next key in the
+//   relationship. The failed message is removed from the history, and is added again when the new
+//   tx is processed. Only private messages and accepts that only we signed can be sent again.
+func (rs *Relationships) Resend(ctx context.Context, r *Relationship, txid bitcoin.Hash32) error {
+	rs.lock.Lock()
+	sent := rs.findSent(txid)
+	if sent == nil || !sent.RelationshipTxId.Equal(&r.TxId) {
+		rs.lock.Unlock()
+		return ErrNotFound
+	}
+	failed := sent.Failed
+	coSigned := len(sent.Members) > 0
+	messageCode := sent.MessageCode
+	payload := sent.Payload
+	rs.lock.Unlock()
+
+	if !failed {
+		return ErrNotCancelled
+	}
+
+	if coSigned || (messageCode != messages.CodePrivateMessage &&
+		messageCode != messages.CodeAcceptRelationship) {
+		return ErrNotResendable
+	}
+
+	if r.Closed {
+		return ErrClosed
+	}
+
+	logger.Info(ctx, "Resending message from cancelled tx : %s", txid.String())
+
+	if err := rs.sendMessage(ctx, r, messageCode, payload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.removeSent(txid)
+	rs.removeTxHistory(ctx, txid)
+	return nil
+}
+
+// cancelSent marks the message we sent in the tx failed and moves the hashes it used back.
+// Returns nil if the tx isn't an unconfirmed message we sent, or was already cancelled.
+func (rs *Relationships) cancelSent(ctx context.Context,
+	txid bitcoin.Hash32) (*Relationship, *SentMessage, error) {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	sent := rs.findSent(txid)
+	if sent == nil || sent.Failed {
+		return nil, nil, nil
+	}
+
+	logger.Warn(ctx, "Sent message tx cancelled : %s", txid.String())
+	sent.Failed = true
+
+	for _, r := range rs.Relationships {
+		if !r.TxId.Equal(&sent.RelationshipTxId) {
+			continue
+		}
+
+		if err := rs.restoreSentHashes(ctx, r, sent); err != nil {
+			return nil, sent, errors.Wrap(err, "restore hashes")
+		}
+		return r, sent, nil
+	}
+
+	return nil, sent, nil
+}
+
+// restoreSentHashes moves our hash and the member hashes back to where they were before the
+//   message was sent. Hashes that were moved again since aren't changed because the later keys
+//   were used by other messages. The lock must already be held.
+func (rs *Relationships) restoreSentHashes(ctx context.Context, r *Relationship,
+	sent *SentMessage) error {
+
+	if r.Closed {
+		return nil // keys are no longer monitored
+	}
+
+	next := bitcoin.NextHash(sent.KeyHash)
+	if sent.KeyUsed && r.NextIndex == sent.KeyIndex+1 && r.NextHash.Equal(&next) {
+		logger.Info(ctx, "Restoring relationship to index %d : %s", sent.KeyIndex,
+			r.TxId.String())
+
+		if err := r.RemoveKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "remove keys")
+		}
+
+		r.NextHash = sent.KeyHash
+		r.NextIndex = sent.KeyIndex
+
+		key, err := rs.wallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+		if err != nil {
+			return errors.Wrap(err, "get key")
+		}
+
+		r.NextKey, err = bitcoin.NextPublicKey(key.PublicKey(), r.NextHash)
+		if err != nil {
+			return errors.Wrap(err, "next key")
+		}
+
+		if err := r.AddLookaheadKeys(ctx, rs.wallet); err != nil {
+			return errors.Wrap(err, "add lookahead keys")
+		}
+	}
+
+	for _, mh := range sent.Members {
+		if int(mh.MemberIndex) >= len(r.Members) {
+			continue
+		}
+
+		m := r.Members[mh.MemberIndex]
+		next := bitcoin.NextHash(mh.Hash)
+		if m.NextIndex != mh.Index+1 || !m.NextHash.Equal(&next) {
+			continue
+		}
+
+		m.NextHash = mh.Hash
+		m.NextIndex = mh.Index
+		m.NextKey, _ = bitcoin.NextPublicKey(m.BaseKey, m.NextHash)
+		m.fillLookahead()
+	}
+
+	return nil
+}
+
+// markSentFailed marks the message from the cancelled tx failed in the history. Private messages
+//   that weren't processed before the tx was cancelled are added to the history so the failure
+//   is shown. Other messages, like receipts, aren't in the history.
+func (rs *Relationships) markSentFailed(ctx context.Context, r *Relationship,
+	sent *SentMessage) error {
+
+	var status uint8
+	if sent.MessageCode == messages.CodePrivateMessage {
+		var err error
+		status, err = receiptStatus(sent.Payload)
+		if err != nil {
+			logger.Warn(ctx, "Invalid receipt status : %s", err)
+		}
+	}
+
+	if sent.MessageCode == messages.CodePrivateMessage && status == 0 &&
+		rs.FindMessage(ctx, r, sent.TxId) == nil {
+
+		p, err := messages.Deserialize(sent.MessageCode, sent.Payload)
+		if err != nil {
+			return errors.Wrap(err, "deserialize message")
+		}
+
+		privateMessage, ok := p.(*messages.PrivateMessage)
+		if !ok {
+			return errors.New("Message not a private message")
+		}
+
+		thread, err := messageThread(sent.Payload)
+		if err != nil {
+			logger.Warn(ctx, "Invalid message thread : %s", err)
+		}
+
+		if _, err := rs.addPrivateMessageHistory(ctx, r, sent.TxId, true, nil, thread,
+			privateMessage); err != nil {
+			return errors.Wrap(err, "add history")
+		}
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	for _, m := range rs.history[r.TxId] {
+		if m.TxId.Equal(&sent.TxId) {
+			logger.Info(ctx, "Message failed : %s", sent.TxId.String())
+			m.Failed = true
+		}
+	}
+
+	return nil
+}
+
+// addSent remembers a message we sent until its tx is confirmed.
+func (rs *Relationships) addSent(ctx context.Context, sent *SentMessage) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.sent = append(rs.sent, sent)
+	for len(rs.sent) > MaxSentMessages {
+		logger.Warn(ctx, "Dropping unconfirmed sent message, so it can't be resent : %s",
+			rs.sent[0].TxId.String())
+		rs.sent = rs.sent[1:]
+	}
+}
+
+// findSent returns the message we sent in the tx, or nil if it isn't found. The lock must
+//   already be held.
+func (rs *Relationships) findSent(txid bitcoin.Hash32) *SentMessage {
+	for _, sent := range rs.sent {
+		if sent.TxId.Equal(&txid) {
+			return sent
+		}
+	}
+	return nil
+}
+
+// removeSent stops remembering the message we sent in the tx. The lock must already be held.
+func (rs *Relationships) removeSent(txid bitcoin.Hash32) {
+	for i, sent := range rs.sent {
+		if sent.TxId.Equal(&txid) {
+			rs.sent = append(rs.sent[:i], rs.sent[i+1:]...)
+			return
+		}
+	}
+}
+
+// loadSent loads the unconfirmed messages we sent. The lock must already be held.
+func (rs *Relationships) loadSent(ctx context.Context, dbConn *db.DB) error {
+	b, err := dbConn.Fetch(ctx, sentKey)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil
+		}
+		return errors.Wrap(err, "fetch sent")
+	}
+
+	buf := bytes.NewReader(b)
+
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "sent size")
+	}
+
+	rs.sent = make([]*SentMessage, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var sent SentMessage
+		if err := sent.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "sent %d", i)
+		}
+		rs.sent = append(rs.sent, &sent)
+	}
+
+	return nil
+}
+
+// saveSent saves the unconfirmed messages we sent. The lock must already be held.
+func (rs *Relationships) saveSent(ctx context.Context, dbConn db.Writer) error {
+	var buf bytes.Buffer
+
+	// Version
+	if err := binary.Write(&buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(rs.sent))); err != nil {
+		return errors.Wrap(err, "sent size")
+	}
+
+	for i, sent := range rs.sent {
+		if err := sent.Serialize(&buf); err != nil {
+			return errors.Wrapf(err, "sent %d", i)
+		}
+	}
+
+	if err := dbConn.Put(ctx, sentKey, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "put sent")
+	}
+
+	return nil
+}
+
+func (s SentMessage) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := s.TxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := s.RelationshipTxId.Serialize(buf); err != nil {
+		return errors.Wrap(err, "relationship txid")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, s.MessageCode); err != nil {
+		return errors.Wrap(err, "message code")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(s.Payload))); err != nil {
+		return errors.Wrap(err, "payload size")
+	}
+	if _, err := buf.Write(s.Payload); err != nil {
+		return errors.Wrap(err, "payload")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, s.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, s.KeyUsed); err != nil {
+		return errors.Wrap(err, "key used")
+	}
+
+	if err := s.KeyHash.Serialize(buf); err != nil {
+		return errors.Wrap(err, "key hash")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, s.KeyIndex); err != nil {
+		return errors.Wrap(err, "key index")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(s.Members))); err != nil {
+		return errors.Wrap(err, "members size")
+	}
+	for i, mh := range s.Members {
+		if err := binary.Write(buf, binary.LittleEndian, mh.MemberIndex); err != nil {
+			return errors.Wrapf(err, "member %d index", i)
+		}
+
+		if err := mh.Hash.Serialize(buf); err != nil {
+			return errors.Wrapf(err, "member %d hash", i)
+		}
+
+		if err := binary.Write(buf, binary.LittleEndian, mh.Index); err != nil {
+			return errors.Wrapf(err, "member %d hash index", i)
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, s.Failed); err != nil {
+		return errors.Wrap(err, "failed")
+	}
+
+	return nil
+}
+
+func (s *SentMessage) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	if err := s.TxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "txid")
+	}
+
+	if err := s.RelationshipTxId.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "relationship txid")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &s.MessageCode); err != nil {
+		return errors.Wrap(err, "message code")
+	}
+
+	var size uint32
+	if err := binary.Read(buf, binary.LittleEndian, &size); err != nil {
+		return errors.Wrap(err, "payload size")
+	}
+	s.Payload = make([]byte, size)
+	if _, err := io.ReadFull(buf, s.Payload); err != nil {
+		return errors.Wrap(err, "payload")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &s.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &s.KeyUsed); err != nil {
+		return errors.Wrap(err, "key used")
+	}
+
+	if err := s.KeyHash.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "key hash")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &s.KeyIndex); err != nil {
+		return errors.Wrap(err, "key index")
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "members size")
+	}
+	s.Members = make([]*SentMemberHash, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var mh SentMemberHash
+		if err := binary.Read(buf, binary.LittleEndian, &mh.MemberIndex); err != nil {
+			return errors.Wrapf(err, "member %d index", i)
+		}
+
+		if err := mh.Hash.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "member %d hash", i)
+		}
+
+		if err := binary.Read(buf, binary.LittleEndian, &mh.Index); err != nil {
+			return errors.Wrapf(err, "member %d hash index", i)
+		}
+
+		s.Members = append(s.Members, &mh)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &s.Failed); err != nil {
+		return errors.Wrap(err, "failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+)
+
+func TestCancelledMessage(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := sendRS.Relationships[0]
+	nextIndex := r.NextIndex
+	nextHash := r.NextHash
+	memberIndex := r.Members[0].NextIndex
+
+	if err := sendRS.SendMessage(ctx, r, &messages.PrivateMessage{Subject: "Lost"}); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	cancelledTx := sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1]
+	cancelledTxId := *cancelledTx.TxHash()
+
+	if r.NextIndex != nextIndex+1 {
+		t.Fatalf("Wrong next index : got %d, want %d", r.NextIndex, nextIndex+1)
+	}
+
+	// A tx we didn't send isn't cancelled.
+	if cancelled, err := sendRS.CancelTx(ctx, bitcoin.Hash32{}); err != nil || cancelled {
+		t.Fatalf("Unknown tx should not be cancelled : %v", err)
+	}
+
+	// The tx is double spent, so the message is marked failed and the hashes are moved back.
+	cancelled, err := sendRS.CancelTx(ctx, cancelledTxId)
+	if err != nil {
+		t.Fatalf("Failed to cancel tx : %s", err)
+	}
+
+	if !cancelled {
+		t.Fatalf("Message tx not cancelled")
+	}
+
+	if r.NextIndex != nextIndex || !r.NextHash.Equal(&nextHash) {
+		t.Fatalf("Wrong next index : got %d, want %d", r.NextIndex, nextIndex)
+	}
+
+	if r.Members[0].NextIndex != memberIndex {
+		t.Fatalf("Wrong member next index : got %d, want %d", r.Members[0].NextIndex, memberIndex)
+	}
+
+	m := sendRS.FindMessage(ctx, r, cancelledTxId)
+	if m == nil {
+		t.Fatalf("Cancelled message not in history")
+	}
+
+	if !m.Failed || m.Direction != DirectionOutgoing {
+		t.Fatalf("Cancelled message not marked failed")
+	}
+
+	// Release the UTXOs spent by the cancelled tx, like the node does.
+	if err := sendWallet.RevertUTXOs(ctx, cancelledTx, false); err != nil {
+		t.Fatalf("Failed to revert utxos : %s", err)
+	}
+
+	// The message is sent again from the same key.
+	if err := sendRS.Resend(ctx, r, cancelledTxId); err != nil {
+		t.Fatalf("Failed to resend message : %s", err)
+	}
+
+	resentTx := sendBroadcastTx.Msgs[len(sendBroadcastTx.Msgs)-1]
+	if resentTx.TxHash().Equal(&cancelledTxId) {
+		t.Fatalf("Message not sent in a new tx")
+	}
+
+	if r.NextIndex != nextIndex+1 {
+		t.Fatalf("Wrong next index : got %d, want %d", r.NextIndex, nextIndex+1)
+	}
+
+	if sendRS.FindMessage(ctx, r, cancelledTxId) != nil {
+		t.Fatalf("Failed message should be removed from history")
+	}
+
+	if err := sendRS.Resend(ctx, r, cancelledTxId); err != ErrNotFound {
+		t.Fatalf("Resending twice should fail : %v", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	_, areSender, memberIndexes, err := receiveRS.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		t.Fatalf("Failed to get relationship : %s", err)
+	}
+
+	if areSender || len(memberIndexes) != 1 {
+		t.Fatalf("Resent message sender not found")
+	}
+
+	// A message that isn't cancelled can't be sent again.
+	if err := sendRS.Resend(ctx, r, *resentTx.TxHash()); err != ErrNotCancelled {
+		t.Fatalf("Resending an uncancelled message should fail : %v", err)
+	}
+}
+
+func TestCancelledInitiation(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := sendRS.Relationships[0]
+	nextIndex := r.NextIndex
+
+	// The initiation is sent from the base key, so no hashes are moved back.
+	cancelled, err := sendRS.CancelTx(ctx, r.TxId)
+	if err != nil {
+		t.Fatalf("Failed to cancel tx : %s", err)
+	}
+
+	if !cancelled {
+		t.Fatalf("Initiation tx not cancelled")
+	}
+
+	if r.NextIndex != nextIndex {
+		t.Fatalf("Wrong next index : got %d, want %d", r.NextIndex, nextIndex)
+	}
+
+	if err := sendRS.Resend(ctx, r, r.TxId); err != ErrNotResendable {
+		t.Fatalf("Resending an initiation should fail : %v", err)
+	}
+}
+
+func TestCancelledMessageKeepsOtherKeys(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	r := receiveRS.Relationships[0]
+
+	// Initiate another relationship to the same receive key.
+	receiveKey, err := receiveWallet.GetKey(ctx, r.KeyType, r.KeyIndex)
+	if err != nil {
+		t.Fatalf("Failed to get receive key : %s", err)
+	}
+
+	_, otherBroadcastTx, otherRS := newTestRelationships(t, ctx, cfg)
+
+	if _, _, err := otherRS.InitiateRelationship(ctx,
+		[]bitcoin.PublicKey{receiveKey.PublicKey()},
+		&messages.IdentityOracleProofField{}); err != nil {
+		t.Fatalf("Failed to initiate relationship : %s", err)
+	}
+
+	itx, message, encryptionKey, _ := decryptMessage(t, ctx, cfg, receiveRS, otherBroadcastTx)
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiate, ok := p.(*messages.InitiateRelationship)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if err := receiveRS.ProcessInitiateRelationship(ctx, itx, message, initiate,
+		encryptionKey); err != nil {
+		t.Fatalf("Failed to process initiate : %s", err)
+	}
+
+	otherR := receiveRS.Relationships[1]
+
+	if err := receiveRS.SendMessage(ctx, r, &messages.PrivateMessage{Subject: "Lost"}); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	cancelledTx := receiveBroadcastTx.Msgs[len(receiveBroadcastTx.Msgs)-1]
+
+	cancelled, err := receiveRS.CancelTx(ctx, *cancelledTx.TxHash())
+	if err != nil {
+		t.Fatalf("Failed to cancel tx : %s", err)
+	}
+
+	if !cancelled {
+		t.Fatalf("Message tx not cancelled")
+	}
+
+	nextAddress, err := otherR.NextKey.RawAddress()
+	if err != nil {
+		t.Fatalf("Failed to get next address : %s", err)
+	}
+
+	hashes, err := nextAddress.Hashes()
+	if err != nil {
+		t.Fatalf("Failed to get next address hashes : %s", err)
+	}
+
+	if monitored, _ := receiveWallet.AreHashesMonitored(hashes); !monitored {
+		t.Fatalf("Next key of other relationship not monitored")
+	}
+}
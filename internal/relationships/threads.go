@@ -0,0 +1,203 @@
+package relationships
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/inspector"
+	"github.com/tokenized/smart-contract/pkg/logger"
+
+	"github.com/tokenized/specification/dist/golang/actions"
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/pkg/errors"
+)
+
+// InitiateThread creates and broadcasts an InitiateThread message that starts a named thread within
+//   the relationship specified. The specification doesn't include a name, so it is appended to the
+//   message as an extra field.
+// The thread is added to the relationship when the tx is processed, the same as for the other
+//   members, and is identified by the txid.
+func (rs *Relationships) InitiateThread(ctx context.Context, r *Relationship,
+	name string) (*messages.InitiateThread, error) {
+
+	logger.Info(ctx, "Initiating thread \"%s\" for relationship : %s", name, r.TxId.String())
+
+	if r.Closed {
+		return nil, ErrClosed
+	}
+
+	if !r.Accepted {
+		return nil, errors.New("Relationship not accepted")
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("Missing thread name")
+	}
+
+	seedValue, err := bitcoin.GenerateSeedValue()
+	if err != nil {
+		return nil, errors.Wrap(err, "seed value")
+	}
+
+	flagValue, err := bitcoin.GenerateSeedValue()
+	if err != nil {
+		return nil, errors.Wrap(err, "flag value")
+	}
+
+	initiate := &messages.InitiateThread{
+		Seed: seedValue.Bytes(),
+		Flag: flagValue.Bytes(),
+	}
+
+	var initiateBuf bytes.Buffer
+	if err := initiate.Serialize(&initiateBuf); err != nil {
+		return nil, errors.Wrap(err, "serialize initiate thread")
+	}
+
+	payload, err := appendField(initiateBuf.Bytes(), threadNameField, []byte(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "append name")
+	}
+
+	if err := rs.sendMessage(ctx, r, messages.CodeInitiateThread, payload); err != nil {
+		return nil, errors.Wrap(err, "send message")
+	}
+
+	return initiate, nil
+}
+
+// ProcessInitiateThread adds the thread to the relationship the message was sent in.
+func (rs *Relationships) ProcessInitiateThread(ctx context.Context, itx *inspector.Transaction,
+	message *actions.Message, initiate *messages.InitiateThread, flag []byte) (bool, error) {
+
+	logger.Info(ctx, "Processing initiate thread : %s", itx.Hash.String())
+
+	// Get relationship
+	r, areSender, memberIndexes, err := rs.GetRelationshipForTx(ctx, itx, message, flag)
+	if err != nil {
+		return false, errors.Wrap(err, "get relationship")
+	}
+	if r == nil {
+		return false, ErrNotFound
+	}
+
+	if !areSender && len(memberIndexes) == 0 {
+		return false, ErrSenderNotFound
+	}
+
+	name, err := findField(message.MessagePayload, threadNameField)
+	if err != nil {
+		return false, errors.Wrap(err, "thread name")
+	}
+
+	if r.FindThread(*itx.Hash) == nil {
+		logger.Info(ctx, "New thread \"%s\" in relationship %s : %s", string(name),
+			r.TxId.String(), itx.Hash.String())
+
+		r.Threads = append(r.Threads, &Thread{
+			TxId: *itx.Hash,
+			Name: string(name),
+			Flag: initiate.Flag,
+			Seed: initiate.Seed,
+		})
+	}
+
+	return areSender && r.EncryptionType == 1, nil
+}
+
+// SendThreadMessage creates and broadcasts a message within a thread of the relationship
+//   specified. The thread's txid is appended to the message as an extra field.
+func (rs *Relationships) SendThreadMessage(ctx context.Context, r *Relationship,
+	threadTxId bitcoin.Hash32, message messages.Message) error {
+
+	messagePayload, err := message.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "Serialize message")
+	}
+
+	messagePayload, err = appendAttachmentHashes(message, messagePayload)
+	if err != nil {
+		return errors.Wrap(err, "append attachment hashes")
+	}
+
+	return rs.sendThreadPayload(ctx, r, threadTxId, message.Code(), messagePayload)
+}
+
+// sendThreadPayload checks that messages can be sent in the thread, appends the thread's txid to
+//   the payload, and sends it.
+func (rs *Relationships) sendThreadPayload(ctx context.Context, r *Relationship,
+	threadTxId bitcoin.Hash32, messageCode uint32, messagePayload []byte) error {
+
+	logger.Info(ctx, "Creating message for thread %s in relationship : %s", threadTxId.String(),
+		r.TxId.String())
+
+	if r.Closed {
+		return ErrClosed
+	}
+
+	if !r.Accepted {
+		return errors.New("Relationship not accepted")
+	}
+
+	if r.FindThread(threadTxId) == nil {
+		return errors.New("Thread not found")
+	}
+
+	messagePayload, err := appendField(messagePayload, threadField, threadTxId[:])
+	if err != nil {
+		return errors.Wrap(err, "append thread")
+	}
+
+	if err := rs.sendMessage(ctx, r, messageCode, messagePayload); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	return nil
+}
+
+// GetThreadHistory returns the messages in the thread of the relationship.
+func (rs *Relationships) GetThreadHistory(ctx context.Context, r *Relationship,
+	threadTxId bitcoin.Hash32) []*Message {
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	var result []*Message
+	for _, m := range rs.history[r.TxId] {
+		if m.Thread.Equal(&threadTxId) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// FindThread returns the thread in the relationship with the txid, or nil if it isn't found.
+func (r *Relationship) FindThread(txid bitcoin.Hash32) *Thread {
+	for _, t := range r.Threads {
+		if t.TxId.Equal(&txid) {
+			return t
+		}
+	}
+	return nil
+}
+
+// messageThread returns the txid of the thread the message payload is tagged with, or zero if it
+//   isn't in a thread.
+func messageThread(payload []byte) (bitcoin.Hash32, error) {
+	b, err := findField(payload, threadField)
+	if err != nil {
+		return bitcoin.Hash32{}, errors.Wrap(err, "find field")
+	}
+	if b == nil {
+		return bitcoin.Hash32{}, nil
+	}
+
+	txid, err := bitcoin.NewHash32(b)
+	if err != nil {
+		return bitcoin.Hash32{}, errors.Wrap(err, "txid")
+	}
+
+	return *txid, nil
+}
@@ -0,0 +1,139 @@
+package relationships
+
+import (
+	"testing"
+
+	"github.com/tokenized/relationship-example/internal/platform/tests"
+
+	"github.com/tokenized/specification/dist/golang/messages"
+
+	"github.com/tokenized/smart-contract/pkg/logger"
+)
+
+func TestThreads(t *testing.T) {
+	ctx := tests.Context()
+	cfg := tests.NewMockConfig()
+
+	sendWallet, sendBroadcastTx, sendRS := newTestRelationships(t, ctx, cfg)
+
+	receiveWallet, receiveBroadcastTx, receiveRS := newTestRelationships(t, ctx, cfg)
+
+	createRelationship(t, ctx, cfg, sendWallet, sendRS, sendBroadcastTx, receiveWallet, receiveRS,
+		receiveBroadcastTx, nil)
+
+	logger.Info(ctx, "Initiating thread **********************************************************")
+
+	if _, err := sendRS.InitiateThread(ctx, sendRS.Relationships[0], "Sample thread"); err != nil {
+		t.Fatalf("Failed to initiate thread : %s", err)
+	}
+
+	itx, message, _, flag := decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	if message.MessageCode != messages.CodeInitiateThread {
+		t.Fatalf("Not an initiate thread message : %d", message.MessageCode)
+	}
+
+	p, err := messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	initiateThread, ok := p.(*messages.InitiateThread)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := sendRS.ProcessInitiateThread(ctx, itx, message, initiateThread,
+		flag); err != nil {
+		t.Fatalf("Failed to process initiate thread for sender : %s", err)
+	}
+
+	if _, err := receiveRS.ProcessInitiateThread(ctx, itx, message, initiateThread,
+		flag); err != nil {
+		t.Fatalf("Failed to process initiate thread for receiver : %s", err)
+	}
+
+	r := receiveRS.Relationships[0]
+	if len(r.Threads) != 1 {
+		t.Fatalf("Wrong thread count : got %d, want %d", len(r.Threads), 1)
+	}
+
+	if r.Threads[0].Name != "Sample thread" {
+		t.Fatalf("Wrong thread name : got \"%s\", want \"%s\"", r.Threads[0].Name,
+			"Sample thread")
+	}
+
+	threadTxId := *itx.Hash
+	if !r.Threads[0].TxId.Equal(&threadTxId) {
+		t.Fatalf("Wrong thread txid : got %s, want %s", r.Threads[0].TxId.String(),
+			threadTxId.String())
+	}
+
+	logger.Info(ctx, "Sending thread messages ****************************************************")
+
+	sendPrivateMessage := &messages.PrivateMessage{
+		Subject: "Sample thread message",
+	}
+
+	if err := sendRS.SendThreadMessage(ctx, sendRS.Relationships[0], threadTxId,
+		sendPrivateMessage); err != nil {
+		t.Fatalf("Failed to send thread message : %s", err)
+	}
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok := p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message : %s", err)
+	}
+
+	sendPrivateMessage = &messages.PrivateMessage{
+		Subject: "Sample message",
+	}
+
+	if err := sendRS.SendMessage(ctx, sendRS.Relationships[0], sendPrivateMessage); err != nil {
+		t.Fatalf("Failed to send message : %s", err)
+	}
+
+	itx, message, _, flag = decryptMessage(t, ctx, cfg, receiveRS, sendBroadcastTx)
+
+	p, err = messages.Deserialize(message.MessageCode, message.MessagePayload)
+	if err != nil {
+		t.Fatalf("Failed to deserialize message payload : %s", err)
+	}
+
+	privateMessage, ok = p.(*messages.PrivateMessage)
+	if !ok {
+		t.Fatalf("Wrong message type")
+	}
+
+	if _, err := receiveRS.ProcessPrivateMessage(ctx, itx, message, privateMessage,
+		flag); err != nil {
+		t.Fatalf("Failed to process message : %s", err)
+	}
+
+	history := receiveRS.GetHistory(ctx, r)
+	if len(history) != 2 {
+		t.Fatalf("Wrong history count : got %d, want %d", len(history), 2)
+	}
+
+	threadHistory := receiveRS.GetThreadHistory(ctx, r, threadTxId)
+	if len(threadHistory) != 1 {
+		t.Fatalf("Wrong thread history count : got %d, want %d", len(threadHistory), 1)
+	}
+
+	if !threadHistory[0].Thread.Equal(&threadTxId) {
+		t.Fatalf("Wrong message thread : got %s, want %s", threadHistory[0].Thread.String(),
+			threadTxId.String())
+	}
+}
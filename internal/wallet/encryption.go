@@ -15,10 +15,31 @@ import (
 	"github.com/pkg/errors"
 )
 
-func (w *Wallet) DecryptActionDirect(ctx context.Context, tx *wire.MsgTx, index int,
+func (w *Wallet) DecryptActionDirect(ctx context.Context, tx *wire.MsgTx,
 	env envelope.BaseMessage) (actions.Action, bitcoin.Hash32, error) {
 
-	payload := env.Payload() // Unencrypted
+	decrypted, encryptionKey, err := w.DecryptPayloadDirect(ctx, tx, env)
+	if err != nil {
+		return nil, bitcoin.Hash32{}, err
+	}
+
+	// Copy so the decrypted data isn't appended into the envelope's payload.
+	payload := append(append([]byte{}, env.Payload()...), decrypted...)
+
+	a, err := actions.Deserialize(env.PayloadIdentifier(), payload)
+	if err != nil {
+		return nil, bitcoin.Hash32{}, errors.Wrap(err, "deserialize action")
+	}
+
+	return a, encryptionKey, nil
+}
+
+// DecryptPayloadDirect returns the data from any directly encrypted payloads in the envelope that
+//   can be decrypted by the wallet's keys, and the encryption key used.
+func (w *Wallet) DecryptPayloadDirect(ctx context.Context, tx *wire.MsgTx,
+	env envelope.BaseMessage) ([]byte, bitcoin.Hash32, error) {
+
+	var payload []byte
 	var encryptionKey bitcoin.Hash32
 	var decrypted []byte
 
@@ -146,18 +167,36 @@ func (w *Wallet) DecryptActionDirect(ctx context.Context, tx *wire.MsgTx, index
 		}
 	}
 
+	return payload, encryptionKey, nil
+}
+
+func (w *Wallet) DecryptActionIndirect(ctx context.Context, env envelope.BaseMessage,
+	encryptionKey bitcoin.Hash32) (actions.Action, error) {
+
+	decrypted, err := w.DecryptPayloadIndirect(ctx, env, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy so the decrypted data isn't appended into the envelope's payload.
+	payload := append(append([]byte{}, env.Payload()...), decrypted...)
+
 	a, err := actions.Deserialize(env.PayloadIdentifier(), payload)
 	if err != nil {
-		return nil, bitcoin.Hash32{}, errors.Wrap(err, "deserialize action")
+		return nil, errors.Wrap(err, "deserialize action")
 	}
 
-	return a, encryptionKey, nil
+	logger.Info(ctx, "Indirect decryption of %s", a.Code())
+
+	return a, nil
 }
 
-func (w *Wallet) DecryptActionIndirect(ctx context.Context, env envelope.BaseMessage,
-	encryptionKey bitcoin.Hash32) (actions.Action, error) {
+// DecryptPayloadIndirect returns the data from any indirectly encrypted payloads in the envelope
+//   decrypted with the encryption key.
+func (w *Wallet) DecryptPayloadIndirect(ctx context.Context, env envelope.BaseMessage,
+	encryptionKey bitcoin.Hash32) ([]byte, error) {
 
-	payload := env.Payload() // Unencrypted
+	var payload []byte
 	var decrypted []byte
 
 	// Convert to specific version of envelope
@@ -186,12 +225,5 @@ func (w *Wallet) DecryptActionIndirect(ctx context.Context, env envelope.BaseMes
 		payload = append(payload, decrypted...) // append decrypted data
 	}
 
-	a, err := actions.Deserialize(env.PayloadIdentifier(), payload)
-	if err != nil {
-		return nil, errors.Wrap(err, "deserialize action")
-	}
-
-	logger.Info(ctx, "Indirect decryption of %s", a.Code())
-
-	return a, nil
+	return payload, nil
 }
@@ -39,12 +39,8 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 		}
 
 		// Broadcast transaction
-		if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-			return errors.Wrap(err, "broadcast funding tx")
-		}
-
-		if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-			return errors.Wrap(err, "process utxos")
+		if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+			return errors.Wrap(err, "broadcast tx")
 		}
 
 		return nil
@@ -57,7 +53,7 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 	if len(tx.Inputs) > 0 {
 		// There is at least one UTXO for authorization so just add additional funding from bitcoin
 		//   funds
-		butxos, err := w.GetBitcoinUTXOs(ctx)
+		butxos, err := w.GetKeyFundingUTXOs(ctx, keyType, keyIndex)
 		if err != nil {
 			return errors.Wrap(err, "fetch bitcoin utxos")
 		}
@@ -81,12 +77,8 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 		}
 
 		// Broadcast transaction
-		if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-			return errors.Wrap(err, "broadcast funding tx")
-		}
-
-		if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-			return errors.Wrap(err, "process utxos")
+		if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+			return errors.Wrap(err, "broadcast tx")
 		}
 
 		return nil
@@ -111,6 +103,11 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 		return fmt.Errorf("Address not found : %s %d", KeyTypeName[keyType], keyIndex)
 	}
 
+	fundingKey, err := w.GetKey(ctx, keyType, keyIndex)
+	if err != nil {
+		return errors.Wrap(err, "get key")
+	}
+
 	fundingAmount := tx.EstimatedFee() + uint64(float32(txbuilder.MaximumP2PKHInputSize)*w.cfg.FeeRate)*2
 	if fundingAmount < w.cfg.DustLimit {
 		fundingAmount = 2 * w.cfg.DustLimit
@@ -128,7 +125,7 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 	}
 
 	// Fund transaction
-	butxos, err := w.GetBitcoinUTXOs(ctx)
+	butxos, err := w.GetKeyFundingUTXOs(ctx, keyType, keyIndex)
 	if err != nil {
 		return errors.Wrap(err, "fetch bitcoin utxos")
 	}
@@ -153,38 +150,21 @@ func (w *Wallet) AddKeyIndexFunding(ctx context.Context, keyType, keyIndex uint3
 
 	logger.Info(ctx, "Created funding tx : %s", fundTx.MsgTx.TxHash().String())
 
-	// Broadcast transaction
-	if err := broadcastTx.BroadcastTx(ctx, fundTx.MsgTx); err != nil {
-		return errors.Wrap(err, "broadcast funding tx")
-	}
-
-	if err := w.ProcessUTXOs(ctx, fundTx.MsgTx, false); err != nil {
-		return errors.Wrap(err, "process utxos")
-	}
-
 	// Fund transaction directly from funding tx above
 	if err := tx.AddInput(wire.OutPoint{Hash: *fundTx.MsgTx.TxHash(), Index: 0},
 		fundTx.MsgTx.TxOut[0].PkScript, fundTx.MsgTx.TxOut[0].Value); err != nil {
 		return errors.Wrap(err, "add funding input")
 	}
 
-	keys, err = w.GetInputKeys(ctx, tx)
-	if err != nil {
-		return errors.Wrap(err, "get input keys")
-	}
-
-	// Sign transaction
-	if err := tx.Sign(keys); err != nil {
+	// Sign transaction. The funding output is its only input.
+	if err := tx.Sign([]bitcoin.Key{fundingKey}); err != nil {
 		return errors.Wrap(err, "sign tx")
 	}
 
-	// Broadcast transaction
-	if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-		return errors.Wrap(err, "broadcast funding tx")
-	}
-
-	if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-		return errors.Wrap(err, "process utxos")
+	// Both txs are signed before either is broadcast, so a failure can't leave the funding tx
+	//   broadcast without the tx.
+	if err := w.broadcastTxs(ctx, broadcastTx, fundTx.MsgTx, tx.MsgTx); err != nil {
+		return errors.Wrap(err, "broadcast txs")
 	}
 
 	return nil
@@ -213,12 +193,8 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 		}
 
 		// Broadcast transaction
-		if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-			return errors.Wrap(err, "broadcast funding tx")
-		}
-
-		if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-			return errors.Wrap(err, "process utxos")
+		if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+			return errors.Wrap(err, "broadcast tx")
 		}
 
 		return nil
@@ -231,7 +207,7 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 	if len(tx.Inputs) > 0 {
 		// There is at least one UTXO for authorization so just add additional funding from bitcoin
 		//   funds
-		butxos, err := w.GetBitcoinUTXOs(ctx)
+		butxos, err := w.GetKeyFundingUTXOs(ctx, keyType, keyIndex)
 		if err != nil {
 			return errors.Wrap(err, "fetch bitcoin utxos")
 		}
@@ -255,12 +231,8 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 		}
 
 		// Broadcast transaction
-		if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-			return errors.Wrap(err, "broadcast funding tx")
-		}
-
-		if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-			return errors.Wrap(err, "process utxos")
+		if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+			return errors.Wrap(err, "broadcast tx")
 		}
 
 		return nil
@@ -312,7 +284,7 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 	}
 
 	// Fund transaction
-	butxos, err := w.GetBitcoinUTXOs(ctx)
+	butxos, err := w.GetKeyFundingUTXOs(ctx, keyType, keyIndex)
 	if err != nil {
 		return errors.Wrap(err, "fetch bitcoin utxos")
 	}
@@ -337,38 +309,21 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 
 	logger.Info(ctx, "Created funding tx : %s", fundTx.MsgTx.TxHash().String())
 
-	// Broadcast transaction
-	if err := broadcastTx.BroadcastTx(ctx, fundTx.MsgTx); err != nil {
-		return errors.Wrap(err, "broadcast funding tx")
-	}
-
-	if err := w.ProcessUTXOs(ctx, fundTx.MsgTx, false); err != nil {
-		return errors.Wrap(err, "process utxos")
-	}
-
 	// Fund transaction directly from funding tx above
 	if err := tx.AddInput(wire.OutPoint{Hash: *fundTx.MsgTx.TxHash(), Index: 0},
 		fundTx.MsgTx.TxOut[0].PkScript, fundTx.MsgTx.TxOut[0].Value); err != nil {
 		return errors.Wrap(err, "add funding input")
 	}
 
-	keys, err = w.GetInputKeys(ctx, tx)
-	if err != nil {
-		return errors.Wrap(err, "get input keys")
-	}
-
-	// Sign transaction
-	if err := tx.Sign(keys); err != nil {
+	// Sign transaction. The funding output is its only input.
+	if err := tx.Sign([]bitcoin.Key{key}); err != nil {
 		return errors.Wrap(err, "sign tx")
 	}
 
-	// Broadcast transaction
-	if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-		return errors.Wrap(err, "broadcast funding tx")
-	}
-
-	if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-		return errors.Wrap(err, "process utxos")
+	// Both txs are signed before either is broadcast, so a failure can't leave the funding tx
+	//   broadcast without the tx.
+	if err := w.broadcastTxs(ctx, broadcastTx, fundTx.MsgTx, tx.MsgTx); err != nil {
+		return errors.Wrap(err, "broadcast txs")
 	}
 
 	return nil
@@ -379,16 +334,38 @@ func (w *Wallet) AddKeyFunding(ctx context.Context, keyType, keyIndex uint32, ke
 func (w *Wallet) AddBitcoinFunding(ctx context.Context, tx *txbuilder.TxBuilder,
 	broadcastTx BroadcastTx) error {
 
-	// Fund transaction
 	butxos, err := w.GetBitcoinUTXOs(ctx)
 	if err != nil {
 		return errors.Wrap(err, "fetch bitcoin utxos")
 	}
 
+	return w.addFunding(ctx, tx, butxos, broadcastTx)
+}
+
+// AddRelationshipFunding adds inputs to a transaction for the relationship with the base key
+//   specified by keyType and keyIndex to fund it. The relationship funding policy decides if UTXOs
+//   on the relationship's derived keys that are no longer in use are spent.
+// This also broadcasts the tx.
+func (w *Wallet) AddRelationshipFunding(ctx context.Context, keyType, keyIndex uint32,
+	tx *txbuilder.TxBuilder, broadcastTx BroadcastTx) error {
+
+	butxos, err := w.GetKeyFundingUTXOs(ctx, keyType, keyIndex)
+	if err != nil {
+		return errors.Wrap(err, "fetch bitcoin utxos")
+	}
+
+	return w.addFunding(ctx, tx, butxos, broadcastTx)
+}
+
+// addFunding adds inputs from the UTXOs to a transaction to fund it, then signs and broadcasts it.
+func (w *Wallet) addFunding(ctx context.Context, tx *txbuilder.TxBuilder, butxos []*UTXO,
+	broadcastTx BroadcastTx) error {
+
 	if len(butxos) == 0 {
 		return errors.New("No bitcoin funding found")
 	}
 
+	// Fund transaction
 	if err := tx.AddFunding(ConvertUTXOs(butxos)); err != nil {
 		return errors.Wrap(err, "fund funding tx")
 	}
@@ -404,13 +381,132 @@ func (w *Wallet) AddBitcoinFunding(ctx context.Context, tx *txbuilder.TxBuilder,
 	}
 
 	// Broadcast transaction
-	if err := broadcastTx.BroadcastTx(ctx, tx.MsgTx); err != nil {
-		return errors.Wrap(err, "broadcast funding tx")
+	if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+		return errors.Wrap(err, "broadcast tx")
+	}
+
+	return nil
+}
+
+// SignBitcoinFunding adds inputs to a transaction to fund it and signs it, but doesn't broadcast
+//   it. The UTXOs spent are reserved so they aren't used by other txs. BroadcastTxs broadcasts it
+//   later with the txs that spend its outputs.
+func (w *Wallet) SignBitcoinFunding(ctx context.Context, tx *txbuilder.TxBuilder) error {
+	butxos, err := w.GetBitcoinUTXOs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch bitcoin utxos")
+	}
+
+	if len(butxos) == 0 {
+		return errors.New("No bitcoin funding found")
+	}
+
+	if err := tx.AddFunding(ConvertUTXOs(butxos)); err != nil {
+		return errors.Wrap(err, "fund funding tx")
+	}
+
+	keys, err := w.GetInputKeys(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "get input keys")
+	}
+
+	if err := tx.Sign(keys); err != nil {
+		return errors.Wrap(err, "sign tx")
 	}
 
-	if err := w.ProcessUTXOs(ctx, tx.MsgTx, false); err != nil {
-		return errors.Wrap(err, "process utxos")
+	for _, input := range tx.MsgTx.TxIn {
+		if _, err := w.ReserveUTXO(ctx, input.PreviousOutPoint.Hash,
+			input.PreviousOutPoint.Index); err != nil {
+			return errors.Wrap(err, "reserve utxo")
+		}
 	}
 
 	return nil
 }
+
+// Sweep consolidates the UTXOs on relationship derived keys that are no longer in use into
+//   internal addresses so they can be spent as bitcoin funding. Each relationship's UTXOs are
+//   swept in a separate tx, to a separate address, so the relationships aren't linked to each
+//   other. Returns the txs, which is empty if there is nothing to sweep.
+// This also broadcasts the txs.
+func (w *Wallet) Sweep(ctx context.Context, broadcastTx BroadcastTx) ([]*wire.MsgTx, error) {
+	utxos, err := w.GetRetiredUTXOs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get retired utxos")
+	}
+
+	// Group the UTXOs by the relationship's base key.
+	var bases [][]*UTXO
+	for _, utxo := range utxos {
+		found := false
+		for i, base := range bases {
+			if base[0].KeyType == utxo.KeyType && base[0].KeyIndex == utxo.KeyIndex {
+				bases[i] = append(base, utxo)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			bases = append(bases, []*UTXO{utxo})
+		}
+	}
+
+	var result []*wire.MsgTx
+	for _, base := range bases {
+		tx, err := w.sweepUTXOs(ctx, base, broadcastTx)
+		if err != nil {
+			return result, errors.Wrapf(err, "sweep key %d %d", base[0].KeyType, base[0].KeyIndex)
+		}
+
+		result = append(result, tx)
+	}
+
+	return result, nil
+}
+
+// sweepUTXOs consolidates the UTXOs into a new internal address and broadcasts the tx.
+func (w *Wallet) sweepUTXOs(ctx context.Context, utxos []*UTXO,
+	broadcastTx BroadcastTx) (*wire.MsgTx, error) {
+
+	tx := txbuilder.NewTxBuilder(w.cfg.DustLimit, w.cfg.FeeRate)
+
+	value := uint64(0)
+	for _, utxo := range utxos {
+		if err := tx.AddInput(wire.OutPoint{Hash: utxo.UTXO.Hash, Index: utxo.UTXO.Index},
+			utxo.UTXO.LockingScript, utxo.UTXO.Value); err != nil {
+			return nil, errors.Wrap(err, "add input")
+		}
+		value += utxo.UTXO.Value
+	}
+
+	address, err := w.GetUnusedAddress(ctx, KeyTypeInternal)
+	if err != nil {
+		return nil, errors.Wrap(err, "get sweep address")
+	}
+
+	logger.Info(ctx, "Sweeping %d UTXOs (%d) to address %d : %s", len(utxos), value,
+		address.KeyIndex, bitcoin.NewAddressFromRawAddress(address.Address, w.cfg.Net).String())
+
+	// The fee is taken from the output when the tx is signed.
+	if err := tx.AddPaymentOutput(address.Address, value, true); err != nil {
+		return nil, errors.Wrap(err, "add payment output")
+	}
+
+	keys, err := w.GetInputKeys(ctx, tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get input keys")
+	}
+
+	// Sign transaction
+	if err := tx.Sign(keys); err != nil {
+		return nil, errors.Wrap(err, "sign tx")
+	}
+
+	// Broadcast transaction
+	if err := w.broadcastTxs(ctx, broadcastTx, tx.MsgTx); err != nil {
+		return nil, errors.Wrap(err, "broadcast sweep tx")
+	}
+
+	return tx.MsgTx, nil
+}
@@ -103,3 +103,37 @@ func (w *Wallet) AddIndependentKey(ctx context.Context, pk bitcoin.PublicKey,
 
 	return nil
 }
+
+// RemoveDerivedKeys removes the keys derived outside the wallet from the specified base key with
+//   the specified hashes from wallet tx filtering. Keys derived from the base key with other
+//   hashes, like those of other relationships, are still monitored. The addresses are still known
+//   to the wallet so txs already seen using them can be processed.
+func (w *Wallet) RemoveDerivedKeys(ctx context.Context, keyType, keyIndex uint32,
+	keyHashes []bitcoin.Hash32) error {
+
+	remove := make(map[bitcoin.Hash32]bool)
+	for _, keyHash := range keyHashes {
+		remove[keyHash] = true
+	}
+
+	w.addressLock.Lock()
+	var hashes []bitcoin.Hash20
+	for hash, ad := range w.addressesMap {
+		if ad.KeyHash != nil && ad.KeyType == keyType && ad.KeyIndex == keyIndex &&
+			remove[*ad.KeyHash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	w.addressLock.Unlock()
+
+	logger.Info(ctx, "Removing %d derived key hashes for key %d/%d", len(hashes), keyType,
+		keyIndex)
+
+	w.hashLock.Lock()
+	for _, hash := range hashes {
+		delete(w.hashes, hash)
+	}
+	w.hashLock.Unlock()
+
+	return nil
+}
@@ -0,0 +1,529 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tokenized/relationship-example/internal/platform/db"
+
+	"github.com/tokenized/smart-contract/pkg/bitcoin"
+	"github.com/tokenized/smart-contract/pkg/logger"
+	"github.com/tokenized/smart-contract/pkg/wire"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	outboxKey = "outbox"
+
+	// MaxBroadcastAttempts is the number of times a tx in the outbox is broadcast. A tx that still
+	//   isn't safe after that is then abandoned and the UTXOs it spends are released.
+	MaxBroadcastAttempts = 10
+
+	// MaxOutboxFinished is the number of confirmed and cancelled txs kept in the outbox.
+	MaxOutboxFinished = 100
+)
+
+// Outbox tx states, in the order they normally happen.
+const (
+	// OutboxBuilt is a signed tx that hasn't been broadcast successfully.
+	OutboxBuilt = uint8(0)
+
+	// OutboxBroadcast is a tx that was broadcast, but spynode hasn't reported it safe yet.
+	OutboxBroadcast = uint8(1)
+
+	// OutboxSafe is a tx that has been in the mempool long enough without a double spend.
+	OutboxSafe = uint8(2)
+
+	// OutboxConfirmed is a tx that is in a block.
+	OutboxConfirmed = uint8(3)
+
+	// OutboxCancelled is a tx that was double spent or abandoned.
+	OutboxCancelled = uint8(4)
+)
+
+var (
+	OutboxStateName = []string{
+		"Built",
+		"Broadcast",
+		"Safe",
+		"Confirmed",
+		"Cancelled",
+	}
+)
+
+// OutboxTx is one of our txs and its broadcast state.
+type OutboxTx struct {
+	Tx       *wire.MsgTx
+	State    uint8
+	Attempts uint32
+
+	// Timestamp is the time the tx was added in nanoseconds since the unix epoch.
+	Timestamp uint64
+
+	// Funds is the txid of the tx that spends this tx's funding output. It is only set for funding
+	//   txs.
+	Funds *bitcoin.Hash32
+}
+
+// BroadcastTxs broadcasts signed txs through the outbox, like a funding tx from SignBitcoinFunding
+//   followed by the tx that spends it.
+func (w *Wallet) BroadcastTxs(ctx context.Context, broadcastTx BroadcastTx,
+	txs ...*wire.MsgTx) error {
+	return w.broadcastTxs(ctx, broadcastTx, txs...)
+}
+
+// broadcastTxs broadcasts signed txs, in order, through the outbox. Each tx except the last is
+//   the funding tx for the last tx. The UTXOs spent by all of the txs are reserved first. If the
+//   first tx can't be broadcast then none of them are, and they are unwound so the UTXOs are
+//   released. Once the first tx is broadcast the rest stay in the outbox until RetryOutbox
+//   broadcasts them, since they spend its outputs. QueuedTxs reports them so the command that
+//   created them isn't reported as sent.
+func (w *Wallet) broadcastTxs(ctx context.Context, broadcastTx BroadcastTx,
+	txs ...*wire.MsgTx) error {
+
+	last := txs[len(txs)-1].TxHash()
+	now := uint64(time.Now().UnixNano())
+
+	w.outboxLock.Lock()
+	for i, tx := range txs {
+		otx := &OutboxTx{
+			Tx:        tx,
+			State:     OutboxBuilt,
+			Timestamp: now,
+		}
+		if i < len(txs)-1 {
+			otx.Funds = last
+		}
+		w.outbox = append(w.outbox, otx)
+	}
+	w.outboxLock.Unlock()
+
+	for i, tx := range txs {
+		if err := w.ProcessUTXOs(ctx, tx, false); err != nil {
+			w.unwindTxs(ctx, txs[:i])
+			w.removeOutbox(txs...)
+			return errors.Wrap(err, "process utxos")
+		}
+	}
+
+	for i, tx := range txs {
+		if err := w.broadcastOutboxTx(ctx, broadcastTx, tx); err != nil {
+			if i == 0 {
+				w.unwindTxs(ctx, txs)
+				w.removeOutbox(txs...)
+				return err
+			}
+
+			logger.Warn(ctx, "Tx left in outbox to retry broadcast %s : %s",
+				tx.TxHash().String(), err)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// RetryOutbox broadcasts the txs in the outbox that aren't safe yet again. Each tx is broadcast up
+//   to MaxBroadcastAttempts times. Txs that still aren't safe on the next retry are cancelled,
+//   with the txs their funding outputs pay for, and the UTXOs they spend are released.
+// Returns the txids of the txs cancelled.
+func (w *Wallet) RetryOutbox(ctx context.Context,
+	broadcastTx BroadcastTx) ([]bitcoin.Hash32, error) {
+
+	w.outboxLock.Lock()
+	stuck := w.stuckTxs()
+	w.outboxLock.Unlock()
+
+	var cancelled []bitcoin.Hash32
+	if len(stuck) > 0 {
+		w.unwindTxs(ctx, stuck)
+		for _, tx := range stuck {
+			logger.Error(ctx, "Abandoning outbox tx that isn't safe : %s", tx.TxHash().String())
+			w.SetOutboxState(ctx, *tx.TxHash(), OutboxCancelled)
+			cancelled = append(cancelled, *tx.TxHash())
+		}
+	}
+
+	w.outboxLock.Lock()
+	var txs []*wire.MsgTx
+	for _, otx := range w.outbox {
+		if otx.needsBroadcast() {
+			txs = append(txs, otx.Tx)
+		}
+	}
+	w.outboxLock.Unlock()
+
+	for _, tx := range txs {
+		if err := w.broadcastOutboxTx(ctx, broadcastTx, tx); err != nil {
+			logger.Warn(ctx, "Failed to broadcast outbox tx %s : %s", tx.TxHash().String(), err)
+		}
+	}
+
+	return cancelled, nil
+}
+
+// OutboxPending returns the number of txs in the outbox that aren't safe yet, so they will be
+//   broadcast again or cancelled.
+func (w *Wallet) OutboxPending() int {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	result := 0
+	for _, otx := range w.outbox {
+		if otx.isPending() {
+			result++
+		}
+	}
+
+	return result
+}
+
+// QueuedTxs returns the txids of the txs added to the outbox at or after the time, in nanoseconds
+//   since the unix epoch, that weren't broadcast. They spend the outputs of a funding tx that was
+//   broadcast, so they are kept in the outbox and RetryOutbox broadcasts them later.
+func (w *Wallet) QueuedTxs(since uint64) []bitcoin.Hash32 {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	var result []bitcoin.Hash32
+	for _, otx := range w.outbox {
+		if otx.Timestamp >= since && otx.State == OutboxBuilt {
+			result = append(result, *otx.Tx.TxHash())
+		}
+	}
+
+	return result
+}
+
+// GetOutbox returns copies of the txs in the outbox.
+func (w *Wallet) GetOutbox(ctx context.Context) []OutboxTx {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	result := make([]OutboxTx, 0, len(w.outbox))
+	for _, otx := range w.outbox {
+		result = append(result, *otx)
+	}
+
+	return result
+}
+
+// OutboxState returns the state of a tx in the outbox, and false if the tx isn't in the outbox.
+func (w *Wallet) OutboxState(txid bitcoin.Hash32) (uint8, bool) {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	for _, otx := range w.outbox {
+		if otx.Tx.TxHash().Equal(&txid) {
+			return otx.State, true
+		}
+	}
+
+	return 0, false
+}
+
+// SetOutboxState updates the state of a tx in the outbox. States only move forward, except that
+//   a tx can become cancelled at any time, and a tx returns to broadcast when it is reverted so
+//   that it is broadcast again. Cancelled txs don't change.
+// Returns true if the tx is in the outbox and its state changed.
+func (w *Wallet) SetOutboxState(ctx context.Context, txid bitcoin.Hash32, state uint8) bool {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	for _, otx := range w.outbox {
+		if !otx.Tx.TxHash().Equal(&txid) {
+			continue
+		}
+
+		switch {
+		case otx.State == OutboxCancelled:
+			return false
+		case state == OutboxCancelled:
+		case state == OutboxBroadcast && otx.State > OutboxBroadcast:
+			otx.Attempts = 0 // reverted, so broadcast it again
+		case state <= otx.State:
+			return false
+		}
+
+		logger.Info(ctx, "Outbox tx %s : %s", OutboxStateName[state], txid.String())
+		otx.State = state
+		w.pruneOutbox()
+		return true
+	}
+
+	return false
+}
+
+// isPending returns true if the tx hasn't been reported safe, confirmed, or cancelled.
+func (otx OutboxTx) isPending() bool {
+	return otx.State == OutboxBuilt || otx.State == OutboxBroadcast
+}
+
+// needsBroadcast returns true if the tx is pending and hasn't been broadcast the maximum number of
+//   times.
+func (otx OutboxTx) needsBroadcast() bool {
+	return otx.isPending() && otx.Attempts < MaxBroadcastAttempts
+}
+
+// stuckTxs returns the pending txs that have been broadcast the maximum number of times, each
+//   followed by the pending tx it funds, so they can be unwound in reverse order. The outbox lock
+//   must already be held.
+func (w *Wallet) stuckTxs() []*wire.MsgTx {
+	var result []*wire.MsgTx
+	added := make(map[bitcoin.Hash32]bool)
+	for _, otx := range w.outbox {
+		txid := *otx.Tx.TxHash()
+		if !otx.isPending() || otx.Attempts < MaxBroadcastAttempts || added[txid] {
+			continue
+		}
+
+		result = append(result, otx.Tx)
+		added[txid] = true
+
+		if otx.Funds == nil || added[*otx.Funds] {
+			continue
+		}
+
+		for _, funded := range w.outbox {
+			if funded.isPending() && funded.Tx.TxHash().Equal(otx.Funds) {
+				result = append(result, funded.Tx)
+				added[*otx.Funds] = true
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// broadcastOutboxTx broadcasts a tx in the outbox and updates its state.
+func (w *Wallet) broadcastOutboxTx(ctx context.Context, broadcastTx BroadcastTx,
+	tx *wire.MsgTx) error {
+
+	txid := tx.TxHash()
+
+	w.outboxLock.Lock()
+	for _, otx := range w.outbox {
+		if otx.Tx.TxHash().Equal(txid) {
+			otx.Attempts++
+			break
+		}
+	}
+	w.outboxLock.Unlock()
+
+	if err := broadcastTx.BroadcastTx(ctx, tx); err != nil {
+		return errors.Wrap(err, "broadcast tx")
+	}
+
+	w.SetOutboxState(ctx, *txid, OutboxBroadcast)
+	return nil
+}
+
+// unwindTxs releases the UTXOs spent by txs that weren't broadcast and removes their outputs.
+func (w *Wallet) unwindTxs(ctx context.Context, txs []*wire.MsgTx) {
+	for i := len(txs) - 1; i >= 0; i-- {
+		logger.Info(ctx, "Unwinding tx : %s", txs[i].TxHash().String())
+		if err := w.RevertUTXOs(ctx, txs[i], false); err != nil {
+			logger.Error(ctx, "Failed to revert utxos for tx %s : %s", txs[i].TxHash().String(),
+				err)
+		}
+	}
+}
+
+// removeOutbox removes the txs from the outbox.
+func (w *Wallet) removeOutbox(txs ...*wire.MsgTx) {
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	for _, tx := range txs {
+		txid := tx.TxHash()
+		for i, otx := range w.outbox {
+			if otx.Tx.TxHash().Equal(txid) {
+				w.outbox = append(w.outbox[:i], w.outbox[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// pruneOutbox removes the oldest confirmed and cancelled txs when there are more than
+//   MaxOutboxFinished. The outbox lock must already be held.
+func (w *Wallet) pruneOutbox() {
+	finished := 0
+	for _, otx := range w.outbox {
+		if otx.State == OutboxConfirmed || otx.State == OutboxCancelled {
+			finished++
+		}
+	}
+
+	if finished <= MaxOutboxFinished {
+		return
+	}
+
+	remaining := make([]*OutboxTx, 0, len(w.outbox))
+	for _, otx := range w.outbox {
+		if finished > MaxOutboxFinished &&
+			(otx.State == OutboxConfirmed || otx.State == OutboxCancelled) {
+			finished--
+			continue
+		}
+		remaining = append(remaining, otx)
+	}
+	w.outbox = remaining
+}
+
+// loadOutbox loads our txs and their broadcast states.
+func (w *Wallet) loadOutbox(ctx context.Context, dbConn *db.DB) error {
+	b, err := dbConn.Fetch(ctx, outboxKey)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil
+		}
+		return errors.Wrap(err, "fetch outbox")
+	}
+
+	buf := bytes.NewReader(b)
+
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version != 0 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	var count uint32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return errors.Wrap(err, "outbox size")
+	}
+
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	w.outbox = make([]*OutboxTx, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var otx OutboxTx
+		if err := otx.Deserialize(buf); err != nil {
+			return errors.Wrapf(err, "outbox tx %d", i)
+		}
+		w.outbox = append(w.outbox, &otx)
+	}
+
+	return nil
+}
+
+// saveOutbox saves our txs and their broadcast states.
+func (w *Wallet) saveOutbox(ctx context.Context, dbConn db.Writer) error {
+	var buf bytes.Buffer
+
+	// Version
+	if err := binary.Write(&buf, binary.LittleEndian, uint8(0)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	w.outboxLock.Lock()
+	defer w.outboxLock.Unlock()
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(w.outbox))); err != nil {
+		return errors.Wrap(err, "outbox size")
+	}
+
+	for i, otx := range w.outbox {
+		if err := otx.Serialize(&buf); err != nil {
+			return errors.Wrapf(err, "outbox tx %d", i)
+		}
+	}
+
+	if err := dbConn.Put(ctx, outboxKey, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "put outbox")
+	}
+
+	return nil
+}
+
+func (otx OutboxTx) Serialize(buf *bytes.Buffer) error {
+	// Version
+	if err := binary.Write(buf, binary.LittleEndian, uint8(1)); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if err := otx.Tx.Serialize(buf); err != nil {
+		return errors.Wrap(err, "tx")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, otx.Attempts); err != nil {
+		return errors.Wrap(err, "attempts")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, otx.State); err != nil {
+		return errors.Wrap(err, "state")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, otx.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, otx.Funds != nil); err != nil {
+		return errors.Wrap(err, "funds flag")
+	}
+
+	if otx.Funds != nil {
+		if err := otx.Funds.Serialize(buf); err != nil {
+			return errors.Wrap(err, "funds")
+		}
+	}
+
+	return nil
+}
+
+func (otx *OutboxTx) Deserialize(buf *bytes.Reader) error {
+	var version uint8
+	if err := binary.Read(buf, binary.LittleEndian, &version); err != nil {
+		return errors.Wrap(err, "version")
+	}
+
+	if version > 1 {
+		return fmt.Errorf("Unsupported version : %d", version)
+	}
+
+	otx.Tx = &wire.MsgTx{}
+	if err := otx.Tx.Deserialize(buf); err != nil {
+		return errors.Wrap(err, "tx")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &otx.Attempts); err != nil {
+		return errors.Wrap(err, "attempts")
+	}
+
+	if version < 1 {
+		otx.State = OutboxBuilt // version 0 only kept txs that weren't broadcast
+		return nil
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &otx.State); err != nil {
+		return errors.Wrap(err, "state")
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &otx.Timestamp); err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+
+	var hasFunds bool
+	if err := binary.Read(buf, binary.LittleEndian, &hasFunds); err != nil {
+		return errors.Wrap(err, "funds flag")
+	}
+
+	if hasFunds {
+		otx.Funds = &bitcoin.Hash32{}
+		if err := otx.Funds.Deserialize(buf); err != nil {
+			return errors.Wrap(err, "funds")
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package wallet
 import (
 	"context"
 
+	"github.com/tokenized/relationship-example/internal/platform/config"
+
 	"github.com/tokenized/smart-contract/pkg/bitcoin"
 	"github.com/tokenized/smart-contract/pkg/logger"
 	"github.com/tokenized/smart-contract/pkg/txbuilder"
@@ -64,18 +66,134 @@ func (w *Wallet) GetKeyHashUTXOs(ctx context.Context, keyType, keyIndex uint32,
 	return result, nil
 }
 
+// IsKeyHashFunded returns true if there is a UTXO, including pending UTXOs, on the key derived
+//   from the hash that isn't already being spent.
+func (w *Wallet) IsKeyHashFunded(ctx context.Context, keyType, keyIndex uint32,
+	keyHash bitcoin.Hash32) bool {
+	w.utxoLock.Lock()
+	defer w.utxoLock.Unlock()
+
+	for _, utxos := range w.utxos {
+		for _, utxo := range utxos {
+			if !utxo.Reserved && !utxo.Deleted && utxo.KeyHash != nil &&
+				keyHash.Equal(utxo.KeyHash) && utxo.KeyType == keyType && utxo.KeyIndex == keyIndex {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// DerivedKey identifies a relationship derived key by its base key and hash.
+type DerivedKey struct {
+	KeyType  uint32
+	KeyIndex uint32
+	KeyHash  bitcoin.Hash32
+}
+
+// ActiveKeys reports which relationship derived keys are still used by relationships.
+type ActiveKeys interface {
+	GetActiveKeys() map[DerivedKey]bool
+}
+
+// SetActiveKeys sets the source of the relationship derived keys that are still in use. UTXOs on
+//   other relationship derived keys can be used as bitcoin funding.
+func (w *Wallet) SetActiveKeys(activeKeys ActiveKeys) {
+	w.utxoLock.Lock()
+	defer w.utxoLock.Unlock()
+
+	w.activeKeys = activeKeys
+}
+
+// getActiveKeys returns the relationship derived keys that are still in use. It must be called
+//   before the UTXO lock is held since the relationships lock is held while calling the wallet.
+func (w *Wallet) getActiveKeys() map[DerivedKey]bool {
+	w.utxoLock.Lock()
+	activeKeys := w.activeKeys
+	w.utxoLock.Unlock()
+
+	if activeKeys == nil {
+		return nil
+	}
+
+	return activeKeys.GetActiveKeys()
+}
+
+// GetBitcoinUTXOs returns the UTXOs that can fund any tx. These are the UTXOs on bitcoin keys,
+//   plus the UTXOs on relationship derived keys that are no longer in use when the relationship
+//   funding policy allows them to be used in any tx.
 func (w *Wallet) GetBitcoinUTXOs(ctx context.Context) ([]*UTXO, error) {
+	active := w.getActiveKeys()
+
 	w.utxoLock.Lock()
 	defer w.utxoLock.Unlock()
 
-	// TODO UTXOs with hashes, from relationship derived keys need to be randomly used as bitcoin
-	//   funding because those keys should not be reused so there is no point in saving the UTXOs.
+	return w.bitcoinUTXOs(active), nil
+}
 
+// bitcoinUTXOs returns the UTXOs that can fund any tx. The UTXO lock must already be held.
+func (w *Wallet) bitcoinUTXOs(active map[DerivedKey]bool) []*UTXO {
 	result := make([]*UTXO, 0)
 	for _, utxos := range w.utxos {
 		for _, utxo := range utxos {
-			if !utxo.Reserved && !utxo.Deleted && !utxo.Pending &&
-				(utxo.KeyType == KeyTypeExternal || utxo.KeyType == KeyTypeInternal) {
+			if utxo.Reserved || utxo.Deleted || utxo.Pending {
+				continue
+			}
+
+			if utxo.KeyType == KeyTypeExternal || utxo.KeyType == KeyTypeInternal ||
+				(w.cfg.RelationshipFunding == config.RelationshipFundingAny &&
+					w.isRetired(utxo, active)) {
+				result = append(result, utxo)
+			}
+		}
+	}
+
+	return result
+}
+
+// GetKeyFundingUTXOs returns the UTXOs that can fund a tx for the relationship with the base key
+//   specified by keyType and keyIndex. These are the bitcoin UTXOs, plus the UTXOs on the
+//   relationship's derived keys that are no longer in use when the relationship funding policy
+//   allows them to be used in txs for the same relationship.
+func (w *Wallet) GetKeyFundingUTXOs(ctx context.Context, keyType, keyIndex uint32) ([]*UTXO,
+	error) {
+
+	active := w.getActiveKeys()
+
+	w.utxoLock.Lock()
+	defer w.utxoLock.Unlock()
+
+	result := w.bitcoinUTXOs(active)
+
+	if w.cfg.RelationshipFunding != config.RelationshipFundingSame {
+		return result, nil
+	}
+
+	for _, utxos := range w.utxos {
+		for _, utxo := range utxos {
+			if !utxo.Reserved && !utxo.Deleted && !utxo.Pending && utxo.KeyType == keyType &&
+				utxo.KeyIndex == keyIndex && w.isRetired(utxo, active) {
+				result = append(result, utxo)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// GetRetiredUTXOs returns the UTXOs on relationship derived keys that are no longer in use,
+//   regardless of the relationship funding policy.
+func (w *Wallet) GetRetiredUTXOs(ctx context.Context) ([]*UTXO, error) {
+	active := w.getActiveKeys()
+
+	w.utxoLock.Lock()
+	defer w.utxoLock.Unlock()
+
+	result := make([]*UTXO, 0)
+	for _, utxos := range w.utxos {
+		for _, utxo := range utxos {
+			if !utxo.Reserved && !utxo.Deleted && !utxo.Pending && w.isRetired(utxo, active) {
 				result = append(result, utxo)
 			}
 		}
@@ -84,6 +202,20 @@ func (w *Wallet) GetBitcoinUTXOs(ctx context.Context) ([]*UTXO, error) {
 	return result, nil
 }
 
+// isRetired returns true if the UTXO is on a relationship derived key that is no longer in use.
+//   Returns false when the active keys aren't known.
+func (w *Wallet) isRetired(utxo *UTXO, active map[DerivedKey]bool) bool {
+	if utxo.KeyHash == nil || active == nil {
+		return false
+	}
+
+	return !active[DerivedKey{
+		KeyType:  utxo.KeyType,
+		KeyIndex: utxo.KeyIndex,
+		KeyHash:  *utxo.KeyHash,
+	}]
+}
+
 func (w *Wallet) GetInputKeys(ctx context.Context, tx *txbuilder.TxBuilder) ([]bitcoin.Key, error) {
 	w.utxoLock.Lock()
 	defer w.utxoLock.Unlock()
@@ -47,6 +47,13 @@ type Wallet struct {
 	// Transactions
 	txs    map[bitcoin.Hash32]*Transaction
 	txLock sync.Mutex
+
+	// Relationship derived keys still in use
+	activeKeys ActiveKeys
+
+	// Signed txs waiting to be broadcast
+	outbox     []*OutboxTx
+	outboxLock sync.Mutex
 }
 
 func NewWallet(cfg *config.Config, keyText string) (*Wallet, error) {
@@ -78,6 +85,10 @@ func (w *Wallet) Load(ctx context.Context, dbConn *db.DB) error {
 		return errors.Wrap(err, "fetch wallet")
 	}
 
+	if err := w.loadOutbox(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "load outbox")
+	}
+
 	return w.Prepare(ctx)
 }
 
@@ -124,7 +135,7 @@ func (w *Wallet) Prepare(ctx context.Context) error {
 	return nil
 }
 
-func (w *Wallet) Save(ctx context.Context, dbConn *db.DB) error {
+func (w *Wallet) Save(ctx context.Context, dbConn db.Writer) error {
 	var buf bytes.Buffer
 	if err := w.Serialize(&buf); err != nil {
 		return errors.Wrap(err, "serialize wallet")
@@ -134,6 +145,10 @@ func (w *Wallet) Save(ctx context.Context, dbConn *db.DB) error {
 		return errors.Wrap(err, "put wallet")
 	}
 
+	if err := w.saveOutbox(ctx, dbConn); err != nil {
+		return errors.Wrap(err, "save outbox")
+	}
+
 	return nil
 }
 